@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/runwayapp/air-traffic-control/internal/apikeys"
+	"github.com/runwayapp/air-traffic-control/internal/metrics"
+)
+
+type ApiKey struct {
+	Id           string
+	Organization string
+	Name         string
+	Scopes       sql.NullString
+	Expires_at   sql.NullString
+	Last_used_at sql.NullString
+	Revoked_at   sql.NullString
+	Created_at   string
+}
+
+type ApiKeyResponse struct {
+	Id           string   `json:"id"`
+	Organization string   `json:"organization"`
+	Name         string   `json:"name"`
+	Scopes       []string `json:"scopes"`
+	Expires_at   *string  `json:"expires_at"`
+	Last_used_at *string  `json:"last_used_at"`
+	Revoked_at   *string  `json:"revoked_at"`
+	Created_at   string   `json:"created_at"`
+	// the plaintext key, only returned when the key is created
+	Key string `json:"key,omitempty"`
+}
+
+type ApiKeyRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	// optional lifetime such as 720h, keys without one never expire
+	Expires_in string `json:"expires_in"`
+}
+
+// nullStringPointer converts a nullable column into a JSON null or string
+func nullStringPointer(value sql.NullString) *string {
+	if !value.Valid {
+		return nil
+	}
+	return &value.String
+}
+
+// scanApiKey reads an api_keys row without its hash
+func scanApiKey(row interface{ Scan(...interface{}) error }) (ApiKey, error) {
+	var apiKey ApiKey
+	err := row.Scan(&apiKey.Id, &apiKey.Organization, &apiKey.Name, &apiKey.Scopes, &apiKey.Expires_at, &apiKey.Last_used_at, &apiKey.Revoked_at, &apiKey.Created_at)
+	return apiKey, err
+}
+
+// buildApiKeyResponse converts an api key row into an API response
+func buildApiKeyResponse(apiKey ApiKey) (ApiKeyResponse, error) {
+	scopes := []string{}
+	if apiKey.Scopes.Valid {
+		if err := json.Unmarshal([]byte(apiKey.Scopes.String), &scopes); err != nil {
+			return ApiKeyResponse{}, err
+		}
+	}
+
+	return ApiKeyResponse{
+		Id:           apiKey.Id,
+		Organization: apiKey.Organization,
+		Name:         apiKey.Name,
+		Scopes:       scopes,
+		Expires_at:   nullStringPointer(apiKey.Expires_at),
+		Last_used_at: nullStringPointer(apiKey.Last_used_at),
+		Revoked_at:   nullStringPointer(apiKey.Revoked_at),
+		Created_at:   apiKey.Created_at,
+	}, nil
+}
+
+func CreateApiKey(c *gin.Context) {
+	var request ApiKeyRequest
+	err := c.BindJSON(&request)
+	if err != nil {
+		panic(fmt.Sprintf("(CreateApiKey) c.BindJSON %s", err))
+	}
+
+	org := c.Param("org")
+	org = strings.ReplaceAll(org, "/", "")
+
+	if request.Name == "" || len(request.Scopes) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name and scopes are required"})
+		return
+	}
+
+	// a key can only hand out scopes it holds itself
+	caller := c.MustGet(apikeys.ContextKey).(*apikeys.Key)
+	for _, scope := range request.Scopes {
+		if !apikeys.ValidScope(scope) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unknown scope %q, valid scopes are %s", scope, strings.Join(apikeys.Scopes, ", "))})
+			return
+		}
+		if !caller.HasScope(scope) {
+			metrics.AuthFailures.Inc("api_key_scope")
+			c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("api key can't grant the %s scope it does not have", scope)})
+			return
+		}
+	}
+
+	var expiresIn time.Duration
+	if request.Expires_in != "" {
+		expiresIn, err = time.ParseDuration(request.Expires_in)
+		if err != nil || expiresIn <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "expires_in must be a positive duration such as 720h"})
+			return
+		}
+	}
+
+	var exists bool
+	query := `SELECT EXISTS(SELECT 1 FROM organizations WHERE name = ?)`
+	err = db.QueryRowContext(c.Request.Context(), query, org).Scan(&exists)
+	if err != nil {
+		panic(fmt.Sprintf("(CreateApiKey) db.QueryRow %s", err))
+	}
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "organization not found"})
+		return
+	}
+
+	id, key, err := insertApiKey(c.Request.Context(), org, request.Name, request.Scopes, expiresIn)
+	if err != nil {
+		panic(fmt.Sprintf("(CreateApiKey) insertApiKey %s", err))
+	}
+
+	query = `SELECT id, organization, name, scopes, expires_at, last_used_at, revoked_at, created_at FROM api_keys WHERE id = ?`
+	apiKey, err := scanApiKey(db.QueryRowContext(c.Request.Context(), query, id))
+	if err != nil {
+		panic(fmt.Sprintf("(CreateApiKey) db.QueryRow %s", err))
+	}
+
+	apiKeyResponse, err := buildApiKeyResponse(apiKey)
+	if err != nil {
+		panic(fmt.Sprintf("(CreateApiKey) json.Unmarshal %s", err))
+	}
+	apiKeyResponse.Key = key
+
+	c.JSON(http.StatusCreated, apiKeyResponse)
+}
+
+// insertApiKey stores a new key for org and returns its id and the plaintext key, expiresIn 0 never expires
+func insertApiKey(ctx context.Context, org string, name string, scopes []string, expiresIn time.Duration) (string, string, error) {
+	id, key, hash, err := apikeys.Generate()
+	if err != nil {
+		return "", "", err
+	}
+
+	scopeData, _ := json.Marshal(scopes)
+
+	if expiresIn == 0 {
+		query := `INSERT INTO api_keys (id, organization, name, key_hash, scopes) VALUES (?, ?, ?, ?, ?)`
+		_, err = db.ExecContext(ctx, query, id, org, name, hash, string(scopeData))
+	} else {
+		query := `INSERT INTO api_keys (id, organization, name, key_hash, scopes, expires_at) VALUES (?, ?, ?, ?, ?, DATE_ADD(CURRENT_TIMESTAMP, INTERVAL ? SECOND))`
+		_, err = db.ExecContext(ctx, query, id, org, name, hash, string(scopeData), int64(expiresIn.Seconds()))
+	}
+	if err != nil {
+		return "", "", err
+	}
+	return id, key, nil
+}
+
+// revokeApiKey revokes a key of org, returning false if there was no such unrevoked key
+func revokeApiKey(ctx context.Context, org string, keyId string) (bool, error) {
+	query := `UPDATE api_keys SET revoked_at = CURRENT_TIMESTAMP WHERE id = ? AND organization = ? AND revoked_at IS NULL`
+	result, err := db.ExecContext(ctx, query, keyId, org)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected > 0, nil
+}
+
+func GetApiKeys(c *gin.Context) {
+	org := c.Param("org")
+	org = strings.ReplaceAll(org, "/", "")
+
+	query := `SELECT id, organization, name, scopes, expires_at, last_used_at, revoked_at, created_at FROM api_keys WHERE organization = ? ORDER BY created_at`
+	res, err := db.QueryContext(c.Request.Context(), query, org)
+	if err != nil {
+		panic(fmt.Sprintf("(GetApiKeys) db.Query %s", err))
+	}
+	defer res.Close()
+
+	apiKeys := []ApiKeyResponse{}
+	for res.Next() {
+		apiKey, err := scanApiKey(res)
+		if err != nil {
+			panic(fmt.Sprintf("(GetApiKeys) res.Scan %s", err))
+		}
+
+		apiKeyResponse, err := buildApiKeyResponse(apiKey)
+		if err != nil {
+			panic(fmt.Sprintf("(GetApiKeys) json.Unmarshal %s", err))
+		}
+
+		apiKeys = append(apiKeys, apiKeyResponse)
+	}
+
+	c.JSON(http.StatusOK, apiKeys)
+}
+
+// RevokeApiKey revokes a key immediately, other keys of the org stay valid so keys can be rotated without downtime
+func RevokeApiKey(c *gin.Context) {
+	org := c.Param("org")
+	org = strings.ReplaceAll(org, "/", "")
+	keyId := c.Param("keyId")
+	keyId = strings.ReplaceAll(keyId, "/", "")
+
+	revoked, err := revokeApiKey(c.Request.Context(), org, keyId)
+	if err != nil {
+		panic(fmt.Sprintf("(RevokeApiKey) revokeApiKey %s", err))
+	}
+
+	if !revoked {
+		c.JSON(http.StatusNotFound, gin.H{"error": "api key not found"})
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/runwayapp/air-traffic-control/internal/apikeys"
+	"github.com/runwayapp/air-traffic-control/internal/database/dbtest"
+)
+
+// fakeApiKeys stores the keys inserted through the fake database by id
+func fakeApiKeys(t *testing.T) map[string][]driver.Value {
+	stored := map[string][]driver.Value{}
+	fake := &dbtest.Fake{
+		Query: func(query string, args []driver.Value) (dbtest.Rows, error) {
+			switch {
+			case strings.Contains(query, "FROM organizations WHERE name = ?"):
+				return dbtest.Rows{Columns: []string{"exists"}, Values: [][]driver.Value{{args[0] == "runwayapp"}}}, nil
+			case strings.Contains(query, "FROM api_keys WHERE id = ?"):
+				row := stored[args[0].(string)]
+				columns := []string{"id", "organization", "name", "scopes", "expires_at", "last_used_at", "revoked_at", "created_at"}
+				return dbtest.Rows{Columns: columns, Values: [][]driver.Value{{row[0], row[1], row[2], row[4], nil, nil, nil, "2026-10-19 09:00:00"}}}, nil
+			}
+			t.Fatalf("unexpected query %s", query)
+			return dbtest.Rows{}, nil
+		},
+		Exec: func(query string, args []driver.Value) (driver.Result, error) {
+			if strings.HasPrefix(query, "INSERT INTO api_keys") {
+				stored[args[0].(string)] = args
+			}
+			return dbtest.Result(1), nil
+		},
+	}
+	db = dbtest.Open(t, fake)
+	return stored
+}
+
+// createApiKeyAs sends a create request authenticated by caller
+func createApiKeyAs(caller *apikeys.Key, body string) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) { c.Set(apikeys.ContextKey, caller) })
+	router.POST("/api/v1/org_api_keys/:org", CreateApiKey)
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodPost, "/api/v1/org_api_keys/runwayapp", strings.NewReader(body))
+	request.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestCreateApiKeyScopes(t *testing.T) {
+	cases := []struct {
+		name   string
+		scopes []string
+		body   string
+		status int
+	}{
+		{name: "escalation", scopes: []string{apikeys.ScopeApiKeys}, body: `{"name": "ci", "scopes": ["auth", "api_keys"]}`, status: http.StatusForbidden},
+		{name: "unknown scope", scopes: apikeys.Scopes, body: `{"name": "ci", "scopes": ["admin"]}`, status: http.StatusBadRequest},
+		{name: "held scopes", scopes: []string{apikeys.ScopeAuth, apikeys.ScopeApiKeys}, body: `{"name": "ci", "scopes": ["auth"]}`, status: http.StatusCreated},
+	}
+	for _, tc := range cases {
+		stored := fakeApiKeys(t)
+		caller := &apikeys.Key{Id: "caller", Organization: "runwayapp", Scopes: tc.scopes}
+
+		recorder := createApiKeyAs(caller, tc.body)
+		if recorder.Code != tc.status {
+			t.Errorf("%s: expected %d, got %d: %s", tc.name, tc.status, recorder.Code, recorder.Body)
+		}
+		if tc.status != http.StatusCreated {
+			if len(stored) != 0 {
+				t.Errorf("%s: expected no key to be stored", tc.name)
+			}
+			continue
+		}
+
+		var response ApiKeyResponse
+		if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		row, ok := stored[response.Id]
+		if !ok {
+			t.Fatalf("%s: expected key %s to be stored", tc.name, response.Id)
+		}
+		// only the hash is stored, and it must match the key handed back once
+		if row[3] != apikeys.Hash(response.Key) || strings.Contains(row[3].(string), response.Key) {
+			t.Errorf("%s: expected the stored hash to match the returned key", tc.name)
+		}
+		if strings.Join(response.Scopes, ",") != "auth" {
+			t.Errorf("%s: unexpected scopes %v", tc.name, response.Scopes)
+		}
+	}
+}
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	jwt "github.com/golang-jwt/jwt/v5"
+	"github.com/runwayapp/air-traffic-control/internal/apikeys"
+	"github.com/runwayapp/air-traffic-control/internal/logging"
+	"github.com/runwayapp/air-traffic-control/internal/metrics"
+	token "github.com/runwayapp/air-traffic-control/internal/utils"
+)
+
+type TokenResponse struct {
+	Message       string `json:"message"`
+	Token         string `json:"token"`
+	Refresh_token string `json:"refresh_token"`
+	// seconds until the access token expires
+	Expires_in int64 `json:"expires_in"`
+}
+
+type RefreshRequest struct {
+	Refresh_token string `json:"refresh_token"`
+}
+
+type RevokeRequest struct {
+	// revoke every token issued to a login
+	Login string `json:"login"`
+	// or revoke a single access token by its jti claim
+	Jti string `json:"jti"`
+}
+
+type RevokeOrgTokenRequest struct {
+	// the access token to revoke
+	Token string `json:"token"`
+}
+
+// issueTokens mints an access token and a refresh token in the given refresh token family
+func issueTokens(ctx context.Context, login string, grant token.Grant, familyId string) (TokenResponse, error) {
+	accessToken, err := token.GenerateToken(login, grant)
+	if err != nil {
+		return TokenResponse{}, err
+	}
+
+	accessLifespan := token.AccessTokenLifespan()
+	refreshLifespan := token.RefreshTokenLifespan()
+
+	id, refreshToken, hash, err := token.GenerateRefreshToken()
+	if err != nil {
+		return TokenResponse{}, err
+	}
+
+	grantData, err := json.Marshal(grant)
+	if err != nil {
+		return TokenResponse{}, err
+	}
+
+	query := `INSERT INTO refresh_tokens (id, family_id, login, token_grant, token_hash, expires_at)
+		VALUES (?, ?, ?, ?, ?, DATE_ADD(CURRENT_TIMESTAMP, INTERVAL ? SECOND))`
+	_, err = db.ExecContext(ctx, query, id, familyId, login, string(grantData), hash, int64(refreshLifespan.Seconds()))
+	if err != nil {
+		return TokenResponse{}, err
+	}
+
+	return TokenResponse{
+		Message:       "ok",
+		Token:         accessToken,
+		Refresh_token: refreshToken,
+		Expires_in:    int64(accessLifespan.Seconds()),
+	}, nil
+}
+
+// revokeRefreshTokenFamily revokes every refresh token descended from the same login
+func revokeRefreshTokenFamily(ctx context.Context, familyId string) error {
+	query := `UPDATE refresh_tokens SET revoked_at = CURRENT_TIMESTAMP WHERE family_id = ? AND revoked_at IS NULL`
+	_, err := db.ExecContext(ctx, query, familyId)
+	return err
+}
+
+// RefreshToken exchanges a refresh token for a new access token and a new refresh token
+// refresh tokens are single use, presenting one twice revokes its whole family
+func RefreshToken(c *gin.Context) {
+	var request RefreshRequest
+	err := c.BindJSON(&request)
+	if err != nil {
+		panic(fmt.Sprintf("(RefreshToken) c.BindJSON %s", err))
+	}
+
+	id, ok := token.RefreshTokenId(request.Refresh_token)
+	if !ok {
+		metrics.AuthFailures.Inc("invalid_refresh_token")
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "invalid refresh token"})
+		return
+	}
+
+	tx, err := db.BeginTx(c.Request.Context(), nil)
+	if err != nil {
+		panic(fmt.Sprintf("(RefreshToken) db.BeginTx %s", err))
+	}
+	defer tx.Rollback()
+
+	// the row stays locked until the token is marked used, so concurrent refreshes are seen as reuse
+	var familyId, login, grantData, hash string
+	var used, revoked, expired bool
+	query := `SELECT family_id, login, token_grant, token_hash, used_at IS NOT NULL, revoked_at IS NOT NULL, expires_at <= CURRENT_TIMESTAMP
+		FROM refresh_tokens WHERE id = ? FOR UPDATE`
+	err = tx.QueryRowContext(c.Request.Context(), query, id).Scan(&familyId, &login, &grantData, &hash, &used, &revoked, &expired)
+	if err == sql.ErrNoRows || (err == nil && !token.RefreshTokenMatches(request.Refresh_token, hash)) {
+		metrics.AuthFailures.Inc("invalid_refresh_token")
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "invalid refresh token"})
+		return
+	}
+	if err != nil {
+		panic(fmt.Sprintf("(RefreshToken) tx.QueryRow %s", err))
+	}
+
+	if revoked || expired {
+		metrics.AuthFailures.Inc("expired_refresh_token")
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "refresh token is no longer valid"})
+		return
+	}
+
+	var grant token.Grant
+	if err := json.Unmarshal([]byte(grantData), &grant); err != nil {
+		panic(fmt.Sprintf("(RefreshToken) json.Unmarshal %s", err))
+	}
+
+	// authorize the api key before touching the token, so a key for another org can't spend it
+	key := c.MustGet(apikeys.ContextKey).(*apikeys.Key)
+	for _, org := range grant.Orgs {
+		if !key.AllowsOrg(org) {
+			metrics.AuthFailures.Inc("api_key_org")
+			c.JSON(http.StatusForbidden, gin.H{"message": fmt.Sprintf("api key can't mint tokens for the %s org", org)})
+			return
+		}
+	}
+
+	if !used {
+		// mark the token as used, losing this race to a concurrent refresh also counts as reuse
+		query = `UPDATE refresh_tokens SET used_at = CURRENT_TIMESTAMP WHERE id = ? AND used_at IS NULL`
+		result, err := tx.ExecContext(c.Request.Context(), query, id)
+		if err != nil {
+			panic(fmt.Sprintf("(RefreshToken) tx.Exec %s", err))
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			panic(fmt.Sprintf("(RefreshToken) result.RowsAffected %s", err))
+		}
+		used = rowsAffected == 0
+	}
+
+	if err := tx.Commit(); err != nil {
+		panic(fmt.Sprintf("(RefreshToken) tx.Commit %s", err))
+	}
+
+	if used {
+		if err := revokeRefreshTokenFamily(c.Request.Context(), familyId); err != nil {
+			panic(fmt.Sprintf("(RefreshToken) revokeRefreshTokenFamily %s", err))
+		}
+		logging.FromContext(c.Request.Context()).Warn("refresh token reuse detected, revoked refresh token family", "login", login, "family_id", familyId)
+		metrics.AuthFailures.Inc("refresh_token_reuse")
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "refresh token reuse detected, every refresh token from this login has been revoked"})
+		return
+	}
+
+	tokens, err := issueTokens(c.Request.Context(), login, grant, familyId)
+	if err != nil {
+		panic(fmt.Sprintf("(RefreshToken) issueTokens %s", err))
+	}
+
+	c.JSON(http.StatusOK, tokens)
+}
+
+// RevokeTokens revokes every token issued to a login, or a single access token by jti
+// logins and jtis aren't tied to an org, so only API keys without one (such as the bootstrap key) can use it
+func RevokeTokens(c *gin.Context) {
+	key := c.MustGet(apikeys.ContextKey).(*apikeys.Key)
+	if key.Organization != "" {
+		metrics.AuthFailures.Inc("api_key_org")
+		c.JSON(http.StatusForbidden, gin.H{"error": "only an api key without an organization can revoke tokens across organizations"})
+		return
+	}
+
+	var request RevokeRequest
+	err := c.BindJSON(&request)
+	if err != nil {
+		panic(fmt.Sprintf("(RevokeTokens) c.BindJSON %s", err))
+	}
+
+	if (request.Login == "") == (request.Jti == "") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "exactly one of login or jti is required"})
+		return
+	}
+
+	if request.Login != "" {
+		if err := revocations.RevokeLogin(c.Request.Context(), request.Login); err != nil {
+			panic(fmt.Sprintf("(RevokeTokens) revocations.RevokeLogin %s", err))
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "ok"})
+		return
+	}
+
+	// no access token outlives the current lifespan, so the revocation can be dropped after it
+	lifespan := token.AccessTokenLifespan()
+	if err := revocations.RevokeToken(c.Request.Context(), request.Jti, time.Now().Add(lifespan)); err != nil {
+		panic(fmt.Sprintf("(RevokeTokens) revocations.RevokeToken %s", err))
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "ok"})
+}
+
+// RevokeOrgToken revokes a single access token granted on the route's org
+// the token must not be granted on any org outside the caller's own grant
+func RevokeOrgToken(c *gin.Context) {
+	var request RevokeOrgTokenRequest
+	err := c.BindJSON(&request)
+	if err != nil {
+		panic(fmt.Sprintf("(RevokeOrgToken) c.BindJSON %s", err))
+	}
+
+	org := c.Param("org")
+	org = strings.ReplaceAll(org, "/", "")
+
+	if request.Token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "token is required"})
+		return
+	}
+
+	caller, err := token.ExtractClaims(c)
+	if err != nil {
+		c.String(http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	target, err := token.VerifyToken(request.Token, revocations, oidcVerifier)
+	if errors.Is(err, token.ErrRevoked) {
+		c.JSON(http.StatusOK, gin.H{"message": "ok"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "token is invalid or has expired"})
+		return
+	}
+
+	if !target.GrantsOrg(org) {
+		c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("token is not granted on the %s org", org)})
+		return
+	}
+	for _, targetOrg := range target.Orgs {
+		if !caller.GrantsOrg(targetOrg) {
+			metrics.AuthFailures.Inc("missing_scope")
+			c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("token is also granted on the %s org, which you can't act on", targetOrg)})
+			return
+		}
+	}
+
+	expiresAt := time.Now().Add(token.AccessTokenLifespan())
+	if target.ExpiresAt != nil {
+		expiresAt = target.ExpiresAt.Time
+	}
+	if err := revocations.RevokeToken(c.Request.Context(), target.ID, expiresAt); err != nil {
+		panic(fmt.Sprintf("(RevokeOrgToken) revocations.RevokeToken %s", err))
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "ok"})
+}
+
+// JWKS publishes the public keys tokens are signed with so other services can verify them
+func JWKS(c *gin.Context) {
+	c.JSON(http.StatusOK, token.PublicJWKS())
+}
+
+type WhoamiResponse struct {
+	Login      string   `json:"login"`
+	Orgs       []string `json:"orgs"`
+	Repos      []string `json:"repos"`
+	Scopes     []string `json:"scopes"`
+	Issuer     string   `json:"issuer,omitempty"`
+	Jti        string   `json:"jti,omitempty"`
+	Issued_at  *string  `json:"issued_at"`
+	Expires_at *string  `json:"expires_at"`
+}
+
+// IntrospectionResponse follows RFC 7662, inactive tokens only report "active": false
+type IntrospectionResponse struct {
+	Active     bool   `json:"active"`
+	Scope      string `json:"scope,omitempty"`
+	Username   string `json:"username,omitempty"`
+	Sub        string `json:"sub,omitempty"`
+	Token_type string `json:"token_type,omitempty"`
+	Iss        string `json:"iss,omitempty"`
+	Jti        string `json:"jti,omitempty"`
+	Exp        int64  `json:"exp,omitempty"`
+	Iat        int64  `json:"iat,omitempty"`
+	// runway specific extensions describing the token's grant
+	Orgs  []string `json:"orgs,omitempty"`
+	Repos []string `json:"repos,omitempty"`
+}
+
+// formatNumericDate renders an optional JWT date as RFC 3339
+func formatNumericDate(date *jwt.NumericDate) *string {
+	if date == nil {
+		return nil
+	}
+	formatted := date.UTC().Format(time.RFC3339)
+	return &formatted
+}
+
+// Whoami describes the bearer token the request was made with
+func Whoami(c *gin.Context) {
+	claims, err := token.ExtractClaims(c)
+	if err != nil {
+		c.String(http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	repos := claims.Repos
+	if repos == nil {
+		repos = []string{}
+	}
+
+	c.JSON(http.StatusOK, WhoamiResponse{
+		Login:      claims.Login,
+		Orgs:       claims.Orgs,
+		Repos:      repos,
+		Scopes:     claims.Scopes,
+		Issuer:     claims.Issuer,
+		Jti:        claims.ID,
+		Issued_at:  formatNumericDate(claims.IssuedAt),
+		Expires_at: formatNumericDate(claims.ExpiresAt),
+	})
+}
+
+// IntrospectToken implements RFC 7662 token introspection for the form parameter "token"
+// org owned API keys only see tokens granted on their own org, anything else is reported inactive
+func IntrospectToken(c *gin.Context) {
+	tokenString := c.PostForm("token")
+	if tokenString == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request", "error_description": "token is required"})
+		return
+	}
+
+	claims, err := token.VerifyToken(tokenString, revocations, oidcVerifier)
+	if err != nil {
+		c.JSON(http.StatusOK, IntrospectionResponse{Active: false})
+		return
+	}
+
+	key := c.MustGet(apikeys.ContextKey).(*apikeys.Key)
+	visible := key.Organization == ""
+	for _, org := range claims.Orgs {
+		visible = visible || key.AllowsOrg(org)
+	}
+	if !visible {
+		c.JSON(http.StatusOK, IntrospectionResponse{Active: false})
+		return
+	}
+
+	response := IntrospectionResponse{
+		Active:     true,
+		Scope:      strings.Join(claims.Scopes, " "),
+		Username:   claims.Login,
+		Sub:        claims.Login,
+		Token_type: "access_token",
+		Iss:        claims.Issuer,
+		Jti:        claims.ID,
+		Orgs:       claims.Orgs,
+		Repos:      claims.Repos,
+	}
+	if claims.ExpiresAt != nil {
+		response.Exp = claims.ExpiresAt.Unix()
+	}
+	if claims.IssuedAt != nil {
+		response.Iat = claims.IssuedAt.Unix()
+	}
+
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/runwayapp/air-traffic-control/internal/apikeys"
+	"github.com/runwayapp/air-traffic-control/internal/database/dbtest"
+	"github.com/runwayapp/air-traffic-control/internal/revocation"
+	token "github.com/runwayapp/air-traffic-control/internal/utils"
+)
+
+// fakeRevocations points the revocation list at a fake database that accepts every write
+func fakeRevocations(t *testing.T) *dbtest.Fake {
+	if err := token.Configure(token.Options{Secret: "test-secret", AccessTokenLifespan: time.Hour, RefreshTokenLifespan: 24 * time.Hour}); err != nil {
+		t.Fatal(err)
+	}
+	fake := &dbtest.Fake{
+		Exec: func(query string, args []driver.Value) (driver.Result, error) {
+			return dbtest.Result(1), nil
+		},
+	}
+	db = dbtest.Open(t, fake)
+	revocations = revocation.New(db)
+	return fake
+}
+
+// revocationWrites returns the statements that revoked a token or a login
+func revocationWrites(fake *dbtest.Fake) []string {
+	writes := []string{}
+	for _, statement := range fake.Statements() {
+		if strings.Contains(statement.Query, "revoked_tokens") || strings.Contains(statement.Query, "login_revocations") {
+			writes = append(writes, statement.Query)
+		}
+	}
+	return writes
+}
+
+func TestRevokeTokensRequiresUnboundKey(t *testing.T) {
+	cases := []struct {
+		name   string
+		key    *apikeys.Key
+		status int
+	}{
+		{name: "org key", key: &apikeys.Key{Id: "key-1", Organization: "runwayapp", Scopes: apikeys.Scopes}, status: http.StatusForbidden},
+		{name: "bootstrap key", key: &apikeys.Key{Id: "bootstrap", Scopes: apikeys.Scopes}, status: http.StatusOK},
+	}
+	for _, tc := range cases {
+		fake := fakeRevocations(t)
+
+		gin.SetMode(gin.TestMode)
+		router := gin.New()
+		router.Use(func(c *gin.Context) { c.Set(apikeys.ContextKey, tc.key) })
+		router.POST("/api/v1/auth/revoke", RevokeTokens)
+
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, "/api/v1/auth/revoke", strings.NewReader(`{"login": "maverick"}`))
+		request.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(recorder, request)
+
+		if recorder.Code != tc.status {
+			t.Errorf("%s: expected %d, got %d: %s", tc.name, tc.status, recorder.Code, recorder.Body)
+		}
+		if revoked := len(revocationWrites(fake)) > 0; revoked != (tc.status == http.StatusOK) {
+			t.Errorf("%s: unexpected revocations %v", tc.name, revocationWrites(fake))
+		}
+	}
+}
+
+func TestRevokeOrgToken(t *testing.T) {
+	cases := []struct {
+		name   string
+		caller []string
+		target []string
+		status int
+	}{
+		{name: "granted org", caller: []string{"runwayapp"}, target: []string{"runwayapp"}, status: http.StatusOK},
+		{name: "other tenant", caller: []string{"runwayapp"}, target: []string{"monalisa"}, status: http.StatusForbidden},
+		{name: "spans another tenant", caller: []string{"runwayapp"}, target: []string{"runwayapp", "monalisa"}, status: http.StatusForbidden},
+		{name: "caller holds both", caller: []string{"runwayapp", "monalisa"}, target: []string{"runwayapp", "monalisa"}, status: http.StatusOK},
+	}
+	for _, tc := range cases {
+		fake := fakeRevocations(t)
+		target, err := token.GenerateToken("goose", token.Grant{Orgs: tc.target, Scopes: []string{token.ScopeCommandsRead}})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		gin.SetMode(gin.TestMode)
+		router := gin.New()
+		router.Use(func(c *gin.Context) {
+			c.Set(token.ClaimsKey, &token.Claims{Authorized: true, Login: "maverick", Grant: token.Grant{Orgs: tc.caller, Scopes: []string{token.ScopeAdmin}}})
+		})
+		router.POST("/api/v1/org_tokens/:org/revoke", RevokeOrgToken)
+
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, "/api/v1/org_tokens/runwayapp/revoke", strings.NewReader(`{"token": "`+target+`"}`))
+		request.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(recorder, request)
+
+		if recorder.Code != tc.status {
+			t.Errorf("%s: expected %d, got %d: %s", tc.name, tc.status, recorder.Code, recorder.Body)
+		}
+		writes := revocationWrites(fake)
+		if tc.status == http.StatusOK && (len(writes) != 1 || !strings.Contains(writes[0], "revoked_tokens")) {
+			t.Errorf("%s: expected the token's jti to be revoked, got %v", tc.name, writes)
+		}
+		if tc.status != http.StatusOK && len(writes) != 0 {
+			t.Errorf("%s: expected nothing to be revoked, got %v", tc.name, writes)
+		}
+	}
+}
+
+// refreshRow is a refresh_tokens row held by fakeRefreshTokens
+type refreshRow struct {
+	family, login, hash string
+	used, revoked       bool
+}
+
+// fakeRefreshTokens keeps refresh_tokens in memory behind the fake database
+type fakeRefreshTokens struct {
+	rows map[string]*refreshRow
+	// lose the race to mark a token used, as if a concurrent refresh got there first
+	raceUsed bool
+}
+
+func (f *fakeRefreshTokens) open(t *testing.T) {
+	fakeRevocations(t)
+	fake := &dbtest.Fake{
+		Query: func(query string, args []driver.Value) (dbtest.Rows, error) {
+			if !strings.Contains(query, "FROM refresh_tokens WHERE id = ?") {
+				t.Fatalf("unexpected query %s", query)
+			}
+			row, ok := f.rows[args[0].(string)]
+			if !ok {
+				return dbtest.Rows{}, nil
+			}
+			columns := []string{"family_id", "login", "token_grant", "token_hash", "used", "revoked", "expired"}
+			values := []driver.Value{row.family, row.login, `{"orgs": ["runwayapp"], "scopes": ["commands:read"]}`, row.hash, row.used, row.revoked, false}
+			return dbtest.Rows{Columns: columns, Values: [][]driver.Value{values}}, nil
+		},
+		Exec: func(query string, args []driver.Value) (driver.Result, error) {
+			switch {
+			case strings.HasPrefix(query, "INSERT INTO refresh_tokens"):
+				f.rows[args[0].(string)] = &refreshRow{family: args[1].(string), login: args[2].(string), hash: args[4].(string)}
+			case strings.HasPrefix(query, "UPDATE refresh_tokens SET used_at"):
+				row := f.rows[args[0].(string)]
+				if row.used || f.raceUsed {
+					return dbtest.Result(0), nil
+				}
+				row.used = true
+			case strings.HasPrefix(query, "UPDATE refresh_tokens SET revoked_at") && strings.Contains(query, "family_id = ?"):
+				for _, row := range f.rows {
+					if row.family == args[0] {
+						row.revoked = true
+					}
+				}
+			}
+			return dbtest.Result(1), nil
+		},
+	}
+	db = dbtest.Open(t, fake)
+}
+
+// add stores a new unused refresh token in family and returns it
+func (f *fakeRefreshTokens) add(t *testing.T, family string) string {
+	id, refreshToken, hash, err := token.GenerateRefreshToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.rows[id] = &refreshRow{family: family, login: "maverick", hash: hash}
+	return refreshToken
+}
+
+func refresh(refreshToken string) *httptest.ResponseRecorder {
+	return refreshWithKey(&apikeys.Key{Id: "key-1", Organization: "runwayapp", Scopes: []string{apikeys.ScopeAuth}}, refreshToken)
+}
+
+// refreshWithKey sends a refresh request authenticated by key
+func refreshWithKey(key *apikeys.Key, refreshToken string) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) { c.Set(apikeys.ContextKey, key) })
+	router.POST("/api/v1/auth/refresh", RefreshToken)
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodPost, "/api/v1/auth/refresh", strings.NewReader(`{"refresh_token": "`+refreshToken+`"}`))
+	request.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestRefreshTokenReuseRevokesFamily(t *testing.T) {
+	store := &fakeRefreshTokens{rows: map[string]*refreshRow{}}
+	store.open(t)
+	first := store.add(t, "family-1")
+	other := store.add(t, "family-2")
+
+	recorder := refresh(first)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected the first refresh to succeed, got %d: %s", recorder.Code, recorder.Body)
+	}
+	var tokens TokenResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &tokens); err != nil {
+		t.Fatal(err)
+	}
+	if tokens.Token == "" || tokens.Refresh_token == "" || tokens.Refresh_token == first {
+		t.Fatalf("expected new tokens, got %+v", tokens)
+	}
+
+	// presenting the used token again is reuse, it revokes the whole family
+	recorder = refresh(first)
+	if recorder.Code != http.StatusUnauthorized || !strings.Contains(recorder.Body.String(), "reuse detected") {
+		t.Fatalf("expected reuse to be detected, got %d: %s", recorder.Code, recorder.Body)
+	}
+	for id, row := range store.rows {
+		if row.revoked != (row.family == "family-1") {
+			t.Errorf("refresh token %s of %s: revoked is %t", id, row.family, row.revoked)
+		}
+	}
+
+	// the token issued by the first refresh went with its family
+	recorder = refresh(tokens.Refresh_token)
+	if recorder.Code != http.StatusUnauthorized || strings.Contains(recorder.Body.String(), "reuse detected") {
+		t.Errorf("expected the descendant token to be rejected as revoked, got %d: %s", recorder.Code, recorder.Body)
+	}
+	if recorder := refresh(other); recorder.Code != http.StatusOK {
+		t.Errorf("expected another family to be unaffected, got %d: %s", recorder.Code, recorder.Body)
+	}
+}
+
+func TestRefreshTokenConcurrentUseIsReuse(t *testing.T) {
+	store := &fakeRefreshTokens{rows: map[string]*refreshRow{}, raceUsed: true}
+	store.open(t)
+	refreshToken := store.add(t, "family-1")
+
+	recorder := refresh(refreshToken)
+	if recorder.Code != http.StatusUnauthorized || !strings.Contains(recorder.Body.String(), "reuse detected") {
+		t.Fatalf("expected losing the race to count as reuse, got %d: %s", recorder.Code, recorder.Body)
+	}
+	for _, row := range store.rows {
+		if !row.revoked {
+			t.Errorf("expected the family to be revoked")
+		}
+	}
+}
+
+func TestRefreshTokenWrongOrgKeyLeavesTokenUsable(t *testing.T) {
+	store := &fakeRefreshTokens{rows: map[string]*refreshRow{}}
+	store.open(t)
+	refreshToken := store.add(t, "family-1")
+
+	otherOrg := &apikeys.Key{Id: "key-2", Organization: "monalisa", Scopes: []string{apikeys.ScopeAuth}}
+	if recorder := refreshWithKey(otherOrg, refreshToken); recorder.Code != http.StatusForbidden {
+		t.Fatalf("expected a key for another org to be rejected, got %d: %s", recorder.Code, recorder.Body)
+	}
+	for _, row := range store.rows {
+		if row.used || row.revoked {
+			t.Fatalf("expected the rejected refresh not to spend the token")
+		}
+	}
+
+	if recorder := refresh(refreshToken); recorder.Code != http.StatusOK {
+		t.Errorf("expected the token to still refresh for its own org, got %d: %s", recorder.Code, recorder.Body)
+	}
+}
+
+func TestRefreshTokenInvalid(t *testing.T) {
+	store := &fakeRefreshTokens{rows: map[string]*refreshRow{}}
+	store.open(t)
+	refreshToken := store.add(t, "family-1")
+
+	for _, presented := range []string{"", "not-a-refresh-token", refreshToken + "x"} {
+		if recorder := refresh(presented); recorder.Code != http.StatusUnauthorized {
+			t.Errorf("%q: expected 401, got %d", presented, recorder.Code)
+		}
+	}
+	for _, row := range store.rows {
+		if row.used || row.revoked {
+			t.Errorf("expected an invalid refresh token not to touch stored ones")
+		}
+	}
+}
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/runwayapp/air-traffic-control/internal/apikeys"
+	"github.com/runwayapp/air-traffic-control/internal/config"
+	"github.com/runwayapp/air-traffic-control/internal/database"
+	"github.com/runwayapp/air-traffic-control/internal/logging"
+	"github.com/runwayapp/air-traffic-control/internal/migrations"
+	token "github.com/runwayapp/air-traffic-control/internal/utils"
+)
+
+const usage = `usage: air-traffic-control [command] [flags]
+
+commands:
+  serve    run the API server, the default when no command is given
+  migrate  apply pending database migrations
+  seed     load the fixture organizations, repositories, commands and users
+  token    issue an access token for ops use
+  apikey   create or revoke an organization's API keys
+  keys     generate, rotate and list JWT signing keys
+
+every command reads the same settings as the server, from example.env, a -config file,
+or the -env, -dsn and -port flags`
+
+const migrateUsage = `usage: air-traffic-control migrate [-status] [flags]
+
+flags:
+  -status  print the current and latest schema versions instead of migrating`
+
+const tokenUsage = `usage: air-traffic-control token issue -login <login> -orgs <orgs> -scopes <scopes> [flags]
+
+flags:
+  -login     login the token acts as
+  -orgs      comma separated organizations the token can act on
+  -repos     comma separated org/repo pairs the token is limited to
+  -scopes    comma separated scopes, such as commands:read,commands:write
+  -lifespan  how long the token is valid (default $ACCESS_TOKEN_MINUTE_LIFESPAN)`
+
+const apikeyUsage = `usage: air-traffic-control apikey <command> -org <org> [flags]
+
+commands:
+  create  create a key and print it, it can't be shown again
+  revoke  revoke a key immediately
+
+flags:
+  -org         organization owning the key
+  -name        name of the key to create
+  -scopes      comma separated scopes of the key to create, such as auth,api_keys
+  -expires-in  lifetime of the key to create, such as 720h, keys without one never expire
+  -id          id of the key to revoke`
+
+// runCommand runs the subcommand named by args[0], or the server when the first argument is a flag or missing
+func runCommand(args []string) error {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return runServeCommand(args)
+	}
+
+	switch args[0] {
+	case "serve":
+		return runServeCommand(args[1:])
+	case "migrate":
+		return runMigrateCommand(args[1:])
+	case "seed":
+		return runSeedCommand(args[1:])
+	case "token":
+		return runTokenCommand(args[1:])
+	case "apikey":
+		return runApiKeyCommand(args[1:])
+	case "keys":
+		return runKeysCommand(args[1:])
+	case "help":
+		fmt.Println(usage)
+		return nil
+	default:
+		return fmt.Errorf("unknown command %q\n\n%s", args[0], usage)
+	}
+}
+
+// loadConfig parses a subcommand's flags along with the shared config flags, then loads and validates the config
+// and sets up logging, so every command reads the same settings the server does
+func loadConfig(set *flag.FlagSet, args []string) (*config.Config, error) {
+	return loadConfigWith(set, args, (*config.Config).Validate)
+}
+
+// loadConfigWith is loadConfig with the validation a command needs, such as only the JWT settings
+func loadConfigWith(set *flag.FlagSet, args []string, validate func(*config.Config) error) (*config.Config, error) {
+	flags := config.AddFlags(set)
+	if err := set.Parse(args); err != nil {
+		return nil, err
+	}
+	if set.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected arguments %v", set.Args())
+	}
+
+	cfg, err := flags.Load()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config: %w", err)
+	}
+	if err := validate(cfg); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
+	// Write structured logs, the log package is routed through the same logger
+	logger := logging.New(os.Stderr, cfg.Log.Level, cfg.Log.Format)
+	logging.SetDefault(logger)
+	log.SetFlags(0)
+	log.SetOutput(logger.StdWriter())
+
+	return cfg, nil
+}
+
+// configureTokens loads the keys used to sign and verify tokens
+func configureTokens(cfg *config.Config) error {
+	return token.Configure(token.Options{
+		Secret:               cfg.JWT.Secret,
+		SigningAlg:           cfg.JWT.SigningAlg,
+		KeysDir:              cfg.JWT.KeysDir,
+		SigningKid:           cfg.JWT.SigningKid,
+		AcceptHS256:          cfg.JWT.AcceptHS256,
+		AccessTokenLifespan:  cfg.JWT.AccessTokenLifespan,
+		RefreshTokenLifespan: cfg.JWT.RefreshTokenLifespan,
+	})
+}
+
+// openDatabase connects the db pool, waiting for the database to come up
+func openDatabase(cfg *config.Config) error {
+	var err error
+	db, err = database.Open(context.Background(), cfg.DSN, database.Options{
+		MaxOpenConns:    cfg.Database.MaxOpenConns,
+		MaxIdleConns:    cfg.Database.MaxIdleConns,
+		ConnMaxLifetime: cfg.Database.ConnMaxLifetime,
+		ConnectAttempts: cfg.Database.ConnectAttempts,
+		InitialBackoff:  cfg.Database.InitialBackoff,
+		MaxBackoff:      cfg.Database.MaxBackoff,
+		QueryTimeout:    cfg.Database.QueryTimeout,
+	})
+	return err
+}
+
+// splitList splits a comma separated flag value, ignoring empty items
+func splitList(value string) []string {
+	items := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func runServeCommand(args []string) error {
+	cfg, err := loadConfig(flag.NewFlagSet("serve", flag.ContinueOnError), args)
+	if err != nil {
+		return err
+	}
+	serve(cfg)
+	return nil
+}
+
+// runMigrateCommand applies the migrations newer than the database's schema version
+func runMigrateCommand(args []string) error {
+	set := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	set.Usage = func() { fmt.Fprintln(set.Output(), migrateUsage) }
+	status := set.Bool("status", false, "print the current and latest schema versions instead of migrating")
+	cfg, err := loadConfig(set, args)
+	if err != nil {
+		return err
+	}
+
+	if err := openDatabase(cfg); err != nil {
+		return err
+	}
+	defer db.Close()
+	ctx := context.Background()
+
+	if *status {
+		current, err := migrations.Current(ctx, db.DB)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("schema version %d, latest migration %d\n", current, migrations.Latest())
+		return nil
+	}
+
+	applied, err := migrations.Apply(ctx, db.DB)
+	for _, migration := range applied {
+		fmt.Printf("applied migration %d_%s\n", migration.Version, migration.Name)
+	}
+	if err != nil {
+		return err
+	}
+	if len(applied) == 0 {
+		fmt.Printf("schema is up to date at version %d\n", migrations.Latest())
+	}
+	return nil
+}
+
+// runSeedCommand loads the fixtures from internal/migrations/seed into a migrated database, leaving existing rows alone
+func runSeedCommand(args []string) error {
+	cfg, err := loadConfig(flag.NewFlagSet("seed", flag.ContinueOnError), args)
+	if err != nil {
+		return err
+	}
+
+	if err := openDatabase(cfg); err != nil {
+		return err
+	}
+	defer db.Close()
+	ctx := context.Background()
+
+	current, err := migrations.Current(ctx, db.DB)
+	if err != nil {
+		return err
+	}
+	if current < migrations.Latest() {
+		return fmt.Errorf("schema is at version %d of %d, run migrate first", current, migrations.Latest())
+	}
+
+	if err := migrations.Seed(ctx, db.DB); err != nil {
+		return err
+	}
+	fmt.Println("loaded fixture organizations, repositories, commands and users")
+	return nil
+}
+
+// runTokenCommand mints an access token without going through an API key, for ops use
+// the token can't be refreshed and is revoked like any other, by jti or login
+func runTokenCommand(args []string) error {
+	if len(args) == 0 || args[0] != "issue" {
+		return errors.New(tokenUsage)
+	}
+
+	set := flag.NewFlagSet("token issue", flag.ContinueOnError)
+	set.Usage = func() { fmt.Fprintln(set.Output(), tokenUsage) }
+	login := set.String("login", "", "login the token acts as")
+	orgs := set.String("orgs", "", "comma separated organizations")
+	repos := set.String("repos", "", "comma separated org/repo pairs")
+	scopes := set.String("scopes", "", "comma separated scopes")
+	lifespan := set.Duration("lifespan", 0, "how long the token is valid")
+	cfg, err := loadConfig(set, args[1:])
+	if err != nil {
+		return err
+	}
+
+	if *login == "" {
+		return errors.New("-login is required")
+	}
+	grant := token.Grant{Orgs: splitList(*orgs), Repos: splitList(*repos), Scopes: splitList(*scopes)}
+	if err := token.ValidateGrant(grant); err != nil {
+		return err
+	}
+
+	if *lifespan < 0 {
+		return errors.New("-lifespan must be positive")
+	}
+	if *lifespan > 0 {
+		cfg.JWT.AccessTokenLifespan = *lifespan
+	}
+	if err := configureTokens(cfg); err != nil {
+		return err
+	}
+
+	accessToken, err := token.GenerateToken(*login, grant)
+	if err != nil {
+		return err
+	}
+	fmt.Println(accessToken)
+	return nil
+}
+
+// runApiKeyCommand creates and revokes API keys, such as an org's first key
+func runApiKeyCommand(args []string) error {
+	if len(args) == 0 || (args[0] != "create" && args[0] != "revoke") {
+		return errors.New(apikeyUsage)
+	}
+
+	set := flag.NewFlagSet("apikey "+args[0], flag.ContinueOnError)
+	set.Usage = func() { fmt.Fprintln(set.Output(), apikeyUsage) }
+	org := set.String("org", "", "organization owning the key")
+	name := set.String("name", "", "name of the key")
+	scopes := set.String("scopes", "", "comma separated scopes")
+	expiresIn := set.Duration("expires-in", 0, "lifetime of the key")
+	id := set.String("id", "", "id of the key to revoke")
+	cfg, err := loadConfig(set, args[1:])
+	if err != nil {
+		return err
+	}
+	if *org == "" {
+		return errors.New("-org is required")
+	}
+
+	if err := openDatabase(cfg); err != nil {
+		return err
+	}
+	defer db.Close()
+	ctx := context.Background()
+
+	if args[0] == "revoke" {
+		if *id == "" {
+			return errors.New("-id is required")
+		}
+		revoked, err := revokeApiKey(ctx, *org, *id)
+		if err != nil {
+			return err
+		}
+		if !revoked {
+			return fmt.Errorf("%s has no active api key %s", *org, *id)
+		}
+		fmt.Printf("revoked api key %s\n", *id)
+		return nil
+	}
+
+	keyScopes := splitList(*scopes)
+	if *name == "" || len(keyScopes) == 0 {
+		return errors.New("-name and -scopes are required")
+	}
+	for _, scope := range keyScopes {
+		if !apikeys.ValidScope(scope) {
+			return fmt.Errorf("unknown scope %q, valid scopes are %s", scope, strings.Join(apikeys.Scopes, ", "))
+		}
+	}
+	if *expiresIn < 0 {
+		return errors.New("-expires-in must be positive")
+	}
+
+	var exists bool
+	query := `SELECT EXISTS(SELECT 1 FROM organizations WHERE name = ?)`
+	if err := db.QueryRowContext(ctx, query, *org).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("organization %s not found", *org)
+	}
+
+	keyId, key, err := insertApiKey(ctx, *org, *name, keyScopes, *expiresIn)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("created api key %s for %s, it won't be shown again:\n%s\n", keyId, *org, key)
+	return nil
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/runwayapp/air-traffic-control/internal/github"
+)
+
+// where a repository keeps its commands manifest
+const manifestPath = ".github/runway.yml"
+
+// drift statuses
+const (
+	DriftMissing = "missing"
+	DriftExtra   = "extra"
+	DriftChanged = "changed"
+)
+
+type DriftEntry struct {
+	Name   string   `json:"name"`
+	Status string   `json:"status"`
+	Fields []string `json:"fields,omitempty"`
+}
+
+type DriftReport struct {
+	Source   string       `json:"source"`
+	Ref      string       `json:"ref,omitempty"`
+	In_sync  bool         `json:"in_sync"`
+	Commands []DriftEntry `json:"commands"`
+}
+
+type ReconciliationResponse struct {
+	Reconciled bool          `json:"reconciled"`
+	Reason     string        `json:"reason,omitempty"`
+	Plan       *ManifestPlan `json:"plan,omitempty"`
+}
+
+// PushEvent holds the fields of a GitHub push webhook payload used for reconciliation
+type PushEvent struct {
+	Ref        string `json:"ref"`
+	After      string `json:"after"`
+	Repository struct {
+		Name           string `json:"name"`
+		Default_branch string `json:"default_branch"`
+		Owner          struct {
+			Login string `json:"login"`
+			Name  string `json:"name"`
+		} `json:"owner"`
+	} `json:"repository"`
+}
+
+// buildDriftReport turns a pruning manifest plan into per-command drift
+// commands the plan would create are missing from MySQL, and ones it would delete are extra
+func buildDriftReport(plan ManifestPlan) DriftReport {
+	report := DriftReport{Commands: []DriftEntry{}}
+	for _, name := range plan.Create {
+		report.Commands = append(report.Commands, DriftEntry{Name: name, Status: DriftMissing})
+	}
+	for _, name := range plan.Delete {
+		report.Commands = append(report.Commands, DriftEntry{Name: name, Status: DriftExtra})
+	}
+	for _, change := range plan.Update {
+		report.Commands = append(report.Commands, DriftEntry{Name: change.Name, Status: DriftChanged, Fields: change.Fields})
+	}
+	report.In_sync = len(report.Commands) == 0
+	return report
+}
+
+// DetectDrift compares stored commands against a manifest from the request body
+// or, when the body is empty, against .github/runway.yml fetched from GitHub at ?ref=
+func DetectDrift(c *gin.Context) {
+	org := c.Param("org")
+	org = strings.ReplaceAll(org, "/", "")
+	repo := c.Param("repo")
+	repo = strings.ReplaceAll(repo, "/", "")
+	ref := c.Query("ref")
+
+	body, ok := readManifestBody(c)
+	if !ok {
+		return
+	}
+
+	source := "request"
+	contentType := c.ContentType()
+	if len(bytes.TrimSpace(body)) == 0 {
+		var err error
+		body, err = githubClient.GetFileContents(c.Request.Context(), org, repo, manifestPath, ref)
+		if errors.Is(err, github.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("%s was not found in %s/%s", manifestPath, org, repo)})
+			return
+		}
+		if errors.Is(err, github.ErrTooLarge) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": fmt.Sprintf("%s must be at most %d bytes", manifestPath, maxManifestBytes)})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusBadGateway, gin.H{"error": fmt.Sprintf("failed to fetch %s from GitHub: %s", manifestPath, err)})
+			return
+		}
+		source = manifestPath
+		contentType = "application/yaml"
+	}
+
+	manifest, err := parseManifest(body, contentType)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	stored, err := loadRepoCommands(c.Request.Context(), db, org, repo)
+	if err != nil {
+		panic(fmt.Sprintf("(DetectDrift) loadRepoCommands %s", err))
+	}
+
+	plan, _, err := planManifest(manifest, stored, true)
+	if err != nil {
+		panic(fmt.Sprintf("(DetectDrift) planManifest %s", err))
+	}
+
+	report := buildDriftReport(plan)
+	report.Source = source
+	if source == manifestPath {
+		report.Ref = ref
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// HandleGitHubWebhook receives signed webhooks from GitHub
+// pushes to a repository's default branch reconcile its commands with .github/runway.yml when reconcile_on_push is enabled
+func HandleGitHubWebhook(c *gin.Context) {
+	switch c.GetHeader("X-GitHub-Event") {
+	case "ping":
+		c.JSON(http.StatusOK, gin.H{"message": "pong"})
+	case "push":
+		reconcilePush(c)
+	default:
+		c.JSON(http.StatusAccepted, gin.H{"message": "event ignored"})
+	}
+}
+
+// reconcilePush applies .github/runway.yml at the pushed commit, pruning commands it does not define
+func reconcilePush(c *gin.Context) {
+	var event PushEvent
+	err := c.BindJSON(&event)
+	if err != nil {
+		panic(fmt.Sprintf("(reconcilePush) c.BindJSON %s", err))
+	}
+
+	org := event.Repository.Owner.Login
+	if org == "" {
+		org = event.Repository.Owner.Name
+	}
+	repo := event.Repository.Name
+
+	if org == "" || repo == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "push event is missing the repository"})
+		return
+	}
+
+	if event.Ref != "refs/heads/"+event.Repository.Default_branch {
+		c.JSON(http.StatusOK, ReconciliationResponse{Reason: "push was not to the default branch"})
+		return
+	}
+
+	settings, err := loadRepositorySettings(c.Request.Context(), org, repo)
+	if err != nil {
+		panic(fmt.Sprintf("(reconcilePush) loadRepositorySettings %s", err))
+	}
+
+	if !settings.Reconcile_on_push {
+		c.JSON(http.StatusOK, ReconciliationResponse{Reason: "reconcile_on_push is disabled for this repository"})
+		return
+	}
+
+	body, err := githubClient.GetFileContents(c.Request.Context(), org, repo, manifestPath, event.After)
+	if errors.Is(err, github.ErrNotFound) {
+		c.JSON(http.StatusOK, ReconciliationResponse{Reason: fmt.Sprintf("%s does not exist at %s", manifestPath, event.After)})
+		return
+	}
+	// never apply, and prune against, part of a manifest
+	if errors.Is(err, github.ErrTooLarge) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": fmt.Sprintf("%s must be at most %d bytes", manifestPath, maxManifestBytes)})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": fmt.Sprintf("failed to fetch %s from GitHub: %s", manifestPath, err)})
+		return
+	}
+
+	manifest, err := parseManifest(body, "application/yaml")
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+
+	plan, err := applyManifest(c.Request.Context(), org, repo, manifest, true, false)
+	var quotaError *QuotaError
+	if errors.As(err, &quotaError) {
+		c.JSON(http.StatusOK, ReconciliationResponse{Reason: quotaError.Message})
+		return
+	}
+	if err != nil {
+		panic(fmt.Sprintf("(reconcilePush) applyManifest %s", err))
+	}
+
+	c.JSON(http.StatusOK, ReconciliationResponse{Reconciled: true, Plan: &plan})
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/runwayapp/air-traffic-control/internal/database/dbtest"
+	"github.com/runwayapp/air-traffic-control/internal/github"
+	"github.com/runwayapp/air-traffic-control/internal/plans"
+)
+
+// fakeCommandStore keeps one repository's commands in memory behind the fake database
+type fakeCommandStore struct {
+	plan            string
+	reconcileOnPush bool
+	// command name to data
+	commands map[string]string
+	// names of commands created, updated and deleted by statements
+	created, updated, deleted []string
+}
+
+func (f *fakeCommandStore) open(t *testing.T) *dbtest.Fake {
+	ids := map[string]string{}
+	for name := range f.commands {
+		ids["id-"+name] = name
+	}
+
+	fake := &dbtest.Fake{
+		Query: func(query string, args []driver.Value) (dbtest.Rows, error) {
+			switch {
+			case strings.Contains(query, "SELECT plan FROM organizations"):
+				return dbtest.Rows{Columns: []string{"plan"}, Values: [][]driver.Value{{f.plan}}}, nil
+			case strings.Contains(query, "FROM repositories WHERE organization"):
+				columns := []string{"trigger_prefix", "case_sensitive", "default_reaction", "allowed_branches", "ignore_closed_prs", "disabled_inherited_commands", "reconcile_on_push"}
+				row := []driver.Value{".", false, "", "[]", false, "[]", f.reconcileOnPush}
+				return dbtest.Rows{Columns: columns, Values: [][]driver.Value{row}}, nil
+			case strings.Contains(query, "SELECT repository, COUNT(*) FROM commands"):
+				if len(f.commands) == 0 {
+					return dbtest.Rows{Columns: []string{"repository", "count"}}, nil
+				}
+				return dbtest.Rows{Columns: []string{"repository", "count"}, Values: [][]driver.Value{{"test-flight", int64(len(f.commands))}}}, nil
+			case strings.Contains(query, "FROM commands WHERE organization = ? AND repository = ?"):
+				names := []string{}
+				for name := range f.commands {
+					names = append(names, name)
+				}
+				sort.Strings(names)
+				values := [][]driver.Value{}
+				for _, name := range names {
+					values = append(values, []driver.Value{"id-" + name, "runwayapp", "test-flight", name, f.commands[name], "2026-10-19 09:00:00", "2026-10-19 09:00:00"})
+				}
+				return dbtest.Rows{Columns: []string{"id", "organization", "repository", "name", "data", "created_at", "updated_at"}, Values: values}, nil
+			}
+			t.Fatalf("unexpected query %s", query)
+			return dbtest.Rows{}, nil
+		},
+		Exec: func(query string, args []driver.Value) (driver.Result, error) {
+			switch {
+			case strings.HasPrefix(query, "INSERT INTO commands"):
+				f.created = append(f.created, args[3].(string))
+			case strings.HasPrefix(query, "UPDATE commands"):
+				f.updated = append(f.updated, args[3].(string))
+			case strings.HasPrefix(query, "DELETE FROM commands"):
+				f.deleted = append(f.deleted, ids[args[0].(string)])
+			}
+			return dbtest.Result(1), nil
+		},
+	}
+	db = dbtest.Open(t, fake)
+	planCache = plans.NewCache(db)
+	return fake
+}
+
+// stubGitHub serves manifest as .github/runway.yml of runwayapp/test-flight and records the refs it was fetched at
+func stubGitHub(t *testing.T, manifest string) *[]string {
+	refs := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/repos/runwayapp/test-flight/contents/.github/runway.yml" || manifest == "" {
+			http.NotFound(w, r)
+			return
+		}
+		refs = append(refs, r.URL.Query().Get("ref"))
+		w.Write([]byte(manifest))
+	}))
+	t.Cleanup(server.Close)
+	githubClient = github.NewClient(server.URL, "")
+	return &refs
+}
+
+const driftManifest = `commands:
+  - name: linter
+    data:
+      command: .lint
+      actions: [{type: comment, text: lint}]
+  - name: deploy
+    data:
+      command: .deploy
+      actions: []
+`
+
+func storedCommands() map[string]string {
+	return map[string]string{
+		"linter": `{"command": ".lint", "actions": []}`,
+		"old":    `{"command": ".old", "actions": []}`,
+	}
+}
+
+func TestDetectDriftFromGitHub(t *testing.T) {
+	store := &fakeCommandStore{plan: plans.Enterprise, commands: storedCommands()}
+	store.open(t)
+	refs := stubGitHub(t, driftManifest)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/api/v1/:org/:repo/drift", DetectDrift)
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/api/v1/runwayapp/test-flight/drift?ref=main", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", recorder.Code, recorder.Body)
+	}
+	if strings.Join(*refs, ",") != "main" {
+		t.Errorf("expected the manifest to be fetched at main, got %v", *refs)
+	}
+
+	var report DriftReport
+	if err := json.Unmarshal(recorder.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Source != manifestPath || report.Ref != "main" || report.In_sync {
+		t.Errorf("unexpected report %+v", report)
+	}
+	statuses := map[string]string{}
+	for _, entry := range report.Commands {
+		statuses[entry.Name] = entry.Status
+	}
+	expected := map[string]string{"deploy": DriftMissing, "old": DriftExtra, "linter": DriftChanged}
+	for name, status := range expected {
+		if statuses[name] != status {
+			t.Errorf("expected %s to be %s, got %q", name, status, statuses[name])
+		}
+	}
+}
+
+func TestDetectDriftManifestNotFound(t *testing.T) {
+	store := &fakeCommandStore{plan: plans.Enterprise, commands: storedCommands()}
+	store.open(t)
+	stubGitHub(t, "")
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/api/v1/:org/:repo/drift", DetectDrift)
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/api/v1/runwayapp/test-flight/drift", nil))
+	if recorder.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d: %s", recorder.Code, recorder.Body)
+	}
+}
+
+func sendPush(ref string) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/api/v1/webhooks/github", HandleGitHubWebhook)
+
+	event := `{"ref": "` + ref + `", "after": "abc123", "repository": {"name": "test-flight", "default_branch": "main", "owner": {"login": "runwayapp"}}}`
+	request := httptest.NewRequest(http.MethodPost, "/api/v1/webhooks/github", strings.NewReader(event))
+	request.Header.Set("X-GitHub-Event", "push")
+	request.Header.Set("Content-Type", "application/json")
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestReconcilePush(t *testing.T) {
+	store := &fakeCommandStore{plan: plans.Enterprise, reconcileOnPush: true, commands: storedCommands()}
+	fake := store.open(t)
+	refs := stubGitHub(t, driftManifest)
+
+	recorder := sendPush("refs/heads/main")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", recorder.Code, recorder.Body)
+	}
+	if strings.Join(*refs, ",") != "abc123" {
+		t.Errorf("expected the manifest to be fetched at the pushed commit, got %v", *refs)
+	}
+
+	var response ReconciliationResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if !response.Reconciled {
+		t.Fatalf("expected the push to be reconciled: %+v", response)
+	}
+	if strings.Join(store.created, ",") != "deploy" || strings.Join(store.updated, ",") != "linter" || strings.Join(store.deleted, ",") != "old" {
+		t.Errorf("unexpected changes, created %v updated %v deleted %v", store.created, store.updated, store.deleted)
+	}
+	if fake.Commits() != 1 {
+		t.Errorf("expected the changes to be committed once, got %d", fake.Commits())
+	}
+}
+
+func TestReconcilePushIgnored(t *testing.T) {
+	cases := map[string]struct {
+		ref             string
+		reconcileOnPush bool
+	}{
+		"other branch": {ref: "refs/heads/feature", reconcileOnPush: true},
+		"disabled":     {ref: "refs/heads/main", reconcileOnPush: false},
+	}
+	for name, tc := range cases {
+		store := &fakeCommandStore{plan: plans.Enterprise, reconcileOnPush: tc.reconcileOnPush, commands: storedCommands()}
+		store.open(t)
+		refs := stubGitHub(t, driftManifest)
+
+		recorder := sendPush(tc.ref)
+		if recorder.Code != http.StatusOK || strings.Contains(recorder.Body.String(), `"reconciled":true`) {
+			t.Errorf("%s: expected the push to be ignored, got %d: %s", name, recorder.Code, recorder.Body)
+		}
+		if len(*refs) != 0 || len(store.deleted) != 0 {
+			t.Errorf("%s: expected nothing to be fetched or deleted", name)
+		}
+	}
+}
+
+// a manifest over the size limit must never be truncated, parsed and then used to prune commands
+func TestReconcilePushManifestTooLarge(t *testing.T) {
+	store := &fakeCommandStore{plan: plans.Enterprise, reconcileOnPush: true, commands: storedCommands()}
+	store.open(t)
+	stubGitHub(t, driftManifest+"#"+strings.Repeat("a", maxManifestBytes))
+
+	recorder := sendPush("refs/heads/main")
+	if recorder.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422, got %d: %s", recorder.Code, recorder.Body)
+	}
+	if len(store.created)+len(store.updated)+len(store.deleted) != 0 {
+		t.Errorf("expected no changes, created %v updated %v deleted %v", store.created, store.updated, store.deleted)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/runwayapp/air-traffic-control/internal/health"
+	"github.com/runwayapp/air-traffic-control/internal/jobs"
+	"github.com/runwayapp/air-traffic-control/internal/logging"
+	"github.com/runwayapp/air-traffic-control/internal/migrations"
+)
+
+// workers started by main, checked for readiness
+var workers []*jobs.Worker
+
+var healthChecker *health.Checker
+
+// checkDatabase pings the database
+func checkDatabase(ctx context.Context) error {
+	return db.PingContext(ctx)
+}
+
+// checkMigrations fails until the schema is at the version this build expects
+func checkMigrations(ctx context.Context) error {
+	current, err := migrations.Current(ctx, db.DB)
+	if err != nil {
+		return err
+	}
+	if latest := migrations.Latest(); current != latest {
+		return fmt.Errorf("schema is at version %d, expected %d", current, latest)
+	}
+	return nil
+}
+
+// checkWorkers fails if any background worker has stopped
+func checkWorkers(ctx context.Context) error {
+	for _, worker := range workers {
+		if !worker.Running() {
+			return fmt.Errorf("worker %s is not running", worker.Name)
+		}
+	}
+	return nil
+}
+
+// logFailures logs why each failing check failed, the errors are not returned to callers
+func logFailures(c *gin.Context, report health.Report) {
+	for _, result := range report.Checks {
+		if result.Status != health.StatusOk {
+			logging.FromContext(c.Request.Context()).Warn("health check failed", "check", result.Name, "error", result.Error)
+		}
+	}
+}
+
+// Healthz reports that the process is alive, it does not check dependencies
+func Healthz(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": health.StatusOk})
+}
+
+// Readyz reports whether the instance can serve traffic, it fails while shutting down
+func Readyz(c *gin.Context) {
+	if healthChecker.ShuttingDown() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "shutting_down"})
+		return
+	}
+
+	report := healthChecker.Run(c.Request.Context())
+	if report.Status != health.StatusOk {
+		logFailures(c, report)
+		failing := []string{}
+		for _, result := range report.Checks {
+			if result.Status != health.StatusOk {
+				failing = append(failing, result.Name)
+			}
+		}
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": report.Status, "failing": failing})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": report.Status})
+}
+
+// Health returns the status and latency of every dependency
+func Health(c *gin.Context) {
+	report := healthChecker.Run(c.Request.Context())
+	logFailures(c, report)
+	if healthChecker.ShuttingDown() {
+		report.Status = "shutting_down"
+	}
+
+	status := http.StatusOK
+	if report.Status != health.StatusOk {
+		status = http.StatusServiceUnavailable
+	}
+	c.JSON(status, report)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/runwayapp/air-traffic-control/internal/health"
+)
+
+func TestHealthHidesCheckErrors(t *testing.T) {
+	healthChecker = &health.Checker{}
+	healthChecker.Add("database", func(ctx context.Context) error {
+		return errors.New("dial tcp db.internal:3306: access denied for user 'atc'")
+	})
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/health", Health)
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/health", nil))
+
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503, got %d", recorder.Code)
+	}
+	body := recorder.Body.String()
+	if !strings.Contains(body, `"status":"failing"`) || !strings.Contains(body, "latency_ms") {
+		t.Errorf("expected the check's status and latency, got %s", body)
+	}
+	if strings.Contains(body, "db.internal") || strings.Contains(body, "error") {
+		t.Errorf("expected the check's error not to be returned, got %s", body)
+	}
+}
@@ -0,0 +1,153 @@
+package apikeys
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/runwayapp/air-traffic-control/internal/database"
+)
+
+// every key starts with this prefix so leaked keys are easy to spot
+const keyPrefix = "atc_"
+
+// ContextKey is where the authenticated *Key is stored on a gin.Context
+const ContextKey = "apiKey"
+
+// API key scopes
+const (
+	// ScopeAuth allows exchanging the key for a JWT via /api/v1/auth
+	ScopeAuth = "auth"
+	// ScopeApiKeys allows creating, listing and revoking the keys of the key's organization
+	ScopeApiKeys = "api_keys"
+)
+
+// Scopes lists every valid API key scope
+var Scopes = []string{ScopeAuth, ScopeApiKeys}
+
+// ErrInvalidKey is returned for unknown, malformed, revoked or expired keys
+var ErrInvalidKey = errors.New("invalid api key")
+
+// Key is an API key without its secret
+// an empty Organization means the key is not bound to an organization
+type Key struct {
+	Id           string
+	Organization string
+	Name         string
+	Scopes       []string
+}
+
+// Generate returns a new key id, the plaintext key to hand to the caller once, and the hash to store
+func Generate() (id string, key string, hash string, err error) {
+	idBytes := make([]byte, 8)
+	if _, err := rand.Read(idBytes); err != nil {
+		return "", "", "", err
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", "", err
+	}
+
+	id = hex.EncodeToString(idBytes)
+	key = keyPrefix + id + "_" + base64.RawURLEncoding.EncodeToString(secret)
+	return id, key, Hash(key), nil
+}
+
+// Hash returns the hex encoded SHA-256 of a key, which is what gets stored
+func Hash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// parseId extracts the public id from a key in the form atc_<id>_<secret>
+func parseId(key string) (string, bool) {
+	rest := strings.TrimPrefix(key, keyPrefix)
+	if rest == key {
+		return "", false
+	}
+	id, secret, ok := strings.Cut(rest, "_")
+	if !ok || id == "" || secret == "" {
+		return "", false
+	}
+	return id, true
+}
+
+// Equal compares two secrets in constant time
+func Equal(a string, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// Authenticate looks up a presented key, verifies it in constant time and records that it was used
+func Authenticate(ctx context.Context, db *database.DB, key string) (*Key, error) {
+	id, ok := parseId(key)
+	if !ok {
+		return nil, ErrInvalidKey
+	}
+
+	var found Key
+	var keyHash string
+	var scopes sql.NullString
+	var active bool
+	query := `SELECT id, organization, name, key_hash, scopes,
+		(revoked_at IS NULL AND (expires_at IS NULL OR expires_at > CURRENT_TIMESTAMP)) AS active
+		FROM api_keys WHERE id = ?`
+	err := db.QueryRowContext(ctx, query, id).Scan(&found.Id, &found.Organization, &found.Name, &keyHash, &scopes, &active)
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalidKey
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !Equal(Hash(key), keyHash) || !active {
+		return nil, ErrInvalidKey
+	}
+
+	found.Scopes = []string{}
+	if scopes.Valid {
+		if err := json.Unmarshal([]byte(scopes.String), &found.Scopes); err != nil {
+			return nil, err
+		}
+	}
+
+	// only write last_used_at once a minute per key to keep authentication cheap
+	query = `UPDATE api_keys SET last_used_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND (last_used_at IS NULL OR last_used_at < DATE_SUB(CURRENT_TIMESTAMP, INTERVAL 1 MINUTE))`
+	if _, err := db.ExecContext(ctx, query, found.Id); err != nil {
+		return nil, err
+	}
+
+	return &found, nil
+}
+
+// HasScope reports whether the key was granted scope
+func (k *Key) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsOrg reports whether the key may act on behalf of org
+func (k *Key) AllowsOrg(org string) bool {
+	return k.Organization == "" || k.Organization == org
+}
+
+// ValidScope reports whether scope is a known API key scope
+func ValidScope(scope string) bool {
+	for _, s := range Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,143 @@
+package apikeys
+
+import (
+	"context"
+	"database/sql/driver"
+	"strings"
+	"testing"
+
+	"github.com/runwayapp/air-traffic-control/internal/database/dbtest"
+)
+
+func TestGenerate(t *testing.T) {
+	id, key, hash, err := Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(key, keyPrefix+id+"_") {
+		t.Errorf("expected %q to start with its prefix and id %q", key, id)
+	}
+	if hash != Hash(key) || hash == key {
+		t.Errorf("expected the stored hash to be the key's SHA-256")
+	}
+
+	parsed, ok := parseId(key)
+	if !ok || parsed != id {
+		t.Errorf("parseId(%q) = %q, %t", key, parsed, ok)
+	}
+}
+
+func TestParseId(t *testing.T) {
+	invalid := []string{"", "atc_", "atc__secret", "atc_id_", "atc_id", "xyz_id_secret", "id_secret"}
+	for _, key := range invalid {
+		if _, ok := parseId(key); ok {
+			t.Errorf("expected %q to be rejected", key)
+		}
+	}
+}
+
+// fakeKeys answers the api_keys lookup with a single stored key
+func fakeKeys(id string, hash string, active bool) *dbtest.Fake {
+	return &dbtest.Fake{
+		Query: func(query string, args []driver.Value) (dbtest.Rows, error) {
+			if args[0] != id {
+				return dbtest.Rows{}, nil
+			}
+			columns := []string{"id", "organization", "name", "key_hash", "scopes", "active"}
+			row := []driver.Value{id, "runwayapp", "ci", hash, `["auth"]`, active}
+			return dbtest.Rows{Columns: columns, Values: [][]driver.Value{row}}, nil
+		},
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	id, key, hash, err := Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherKey, _, _ := Generate()
+	// same id, different secret
+	forged := keyPrefix + id + "_" + strings.SplitN(otherKey, "_", 3)[2]
+
+	cases := []struct {
+		name   string
+		key    string
+		active bool
+		valid  bool
+	}{
+		{name: "valid", key: key, active: true, valid: true},
+		{name: "wrong secret", key: forged, active: true},
+		// revoked_at and expires_at are folded into the active column by the query
+		{name: "revoked or expired", key: key, active: false},
+		{name: "unknown id", key: otherKey, active: true},
+		{name: "malformed", key: "not-a-key", active: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := fakeKeys(id, hash, tc.active)
+			found, err := Authenticate(context.Background(), dbtest.Open(t, fake), tc.key)
+
+			updates := 0
+			for _, statement := range fake.Statements() {
+				if strings.HasPrefix(statement.Query, "UPDATE api_keys SET last_used_at") {
+					updates++
+				}
+			}
+
+			if !tc.valid {
+				if err != ErrInvalidKey || found != nil {
+					t.Errorf("expected ErrInvalidKey, got %v, %v", found, err)
+				}
+				if updates != 0 {
+					t.Errorf("expected last_used_at not to be touched for an invalid key")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			if found.Id != id || found.Organization != "runwayapp" || !found.HasScope(ScopeAuth) || found.HasScope(ScopeApiKeys) {
+				t.Errorf("unexpected key %+v", found)
+			}
+			if updates != 1 {
+				t.Errorf("expected last_used_at to be updated once, got %d", updates)
+			}
+		})
+	}
+}
+
+func TestAuthenticateThrottlesLastUsed(t *testing.T) {
+	id, key, hash, _ := Generate()
+	fake := fakeKeys(id, hash, true)
+	if _, err := Authenticate(context.Background(), dbtest.Open(t, fake), key); err != nil {
+		t.Fatal(err)
+	}
+
+	// the write only happens when the stored value is more than a minute old
+	for _, statement := range fake.Statements() {
+		if strings.HasPrefix(statement.Query, "UPDATE api_keys SET last_used_at") {
+			if !strings.Contains(statement.Query, "last_used_at IS NULL OR last_used_at < DATE_SUB(CURRENT_TIMESTAMP, INTERVAL 1 MINUTE)") {
+				t.Errorf("expected the update to be throttled: %s", statement.Query)
+			}
+			if len(statement.Args) != 1 || statement.Args[0] != id {
+				t.Errorf("expected the update to be limited to key %s, got %v", id, statement.Args)
+			}
+		}
+	}
+}
+
+func TestKeyScopes(t *testing.T) {
+	key := &Key{Organization: "runwayapp", Scopes: []string{ScopeAuth}}
+	if !key.HasScope(ScopeAuth) || key.HasScope(ScopeApiKeys) {
+		t.Errorf("unexpected scopes for %+v", key)
+	}
+	if !key.AllowsOrg("runwayapp") || key.AllowsOrg("monalisa") {
+		t.Errorf("expected the key to be bound to runwayapp")
+	}
+
+	unbound := &Key{Scopes: Scopes}
+	if !unbound.AllowsOrg("monalisa") {
+		t.Errorf("expected a key without an org to act on any org")
+	}
+}
@@ -0,0 +1,543 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/runwayapp/air-traffic-control/internal/logging"
+	"github.com/runwayapp/air-traffic-control/internal/ratelimit"
+	"github.com/runwayapp/air-traffic-control/internal/tracing"
+	token "github.com/runwayapp/air-traffic-control/internal/utils"
+	"gopkg.in/yaml.v3"
+)
+
+// environments with special behaviour
+const (
+	EnvDevelopment = "development"
+	EnvProduction  = "production"
+)
+
+// rate limit stores
+const (
+	RateLimitStoreMemory = "memory"
+	RateLimitStoreMySQL  = "mysql"
+)
+
+// shortest JWT_SECRET accepted in production, HS256 keys should be at least 256 bits
+const minProductionSecretLength = 32
+
+// Config is every setting the server reads at startup
+type Config struct {
+	Env  string
+	Port string
+	DSN  string
+
+	Log         Log
+	Server      Server
+	Database    Database
+	JWT         JWT
+	DevIdentity DevIdentity
+	GitHub      GitHub
+	OIDC        OIDC
+	RateLimit   RateLimit
+	Metrics     Metrics
+	Tracing     Tracing
+}
+
+type Log struct {
+	Level  logging.Level
+	Format string
+}
+
+type Server struct {
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	IdleTimeout  time.Duration
+	// how long readiness fails before the server stops accepting connections, so load balancers can stop routing to it
+	ShutdownDelay time.Duration
+	// deadline for draining in-flight requests and background workers
+	ShutdownTimeout time.Duration
+}
+
+type Database struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnectAttempts int
+	InitialBackoff  time.Duration
+	MaxBackoff      time.Duration
+	QueryTimeout    time.Duration
+}
+
+type JWT struct {
+	Secret               string
+	SigningAlg           string
+	KeysDir              string
+	SigningKid           string
+	AccessTokenLifespan  time.Duration
+	RefreshTokenLifespan time.Duration
+	// keep verifying HS256 tokens with Secret after switching to RS256 or EdDSA, until they have expired
+	AcceptHS256 bool
+	// route patterns that also accept ?token=
+	QueryTokenRoutes []string
+}
+
+// DevIdentity is the fake identity requests without a token act as in development, disabled when Login is empty
+type DevIdentity struct {
+	Login  string
+	Orgs   []string
+	Repos  []string
+	Scopes []string
+}
+
+type GitHub struct {
+	ApiURL        string
+	Token         string
+	WebhookSecret string
+	// unscoped bootstrap API key accepted alongside the keys in the api_keys table
+	AppApiKey string
+}
+
+// OIDC configures external tokens from GitHub Actions, disabled when Issuer is empty
+type OIDC struct {
+	Issuer   string
+	JWKSURL  string
+	Audience string
+	Scopes   []string
+}
+
+type RateLimit struct {
+	Store  string
+	Limits map[string]ratelimit.Limit
+}
+
+// Tracing exports spans to stdout or an OTLP/HTTP collector, disabled with ExporterNone
+type Tracing struct {
+	Exporter    string
+	Endpoint    string
+	Headers     map[string]string
+	ServiceName string
+	// fraction of new traces recorded, traces continued from a traceparent header follow the caller
+	SampleRatio float64
+}
+
+// Metrics are served on Addr when it is set, otherwise on the API port behind Token, and not at all without either
+type Metrics struct {
+	Addr string
+	// admin credential required as a bearer token to scrape /metrics
+	Token string
+}
+
+// source looks settings up in flags, then the environment, then the config file
+// empty env vars are treated as unset so an .env file with blank entries doesn't hide the config file
+// every setting can also be read from the file named by <NAME>_FILE so secrets can be mounted as files
+type source struct {
+	flags map[string]string
+	file  map[string]string
+	err   error
+}
+
+func (s *source) lookup(name string) (string, bool) {
+	if value, ok := s.flags[name]; ok {
+		return value, true
+	}
+	if value := os.Getenv(name); value != "" {
+		return value, true
+	}
+	if path, ok := os.LookupEnv(name + "_FILE"); ok && path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			s.fail(fmt.Errorf("%s_FILE: %w", name, err))
+			return "", false
+		}
+		return strings.TrimSpace(string(data)), true
+	}
+	if value, ok := s.file[name]; ok {
+		return value, true
+	}
+	return "", false
+}
+
+// fail keeps the first error so Load can report it after reading every setting
+func (s *source) fail(err error) {
+	if s.err == nil {
+		s.err = err
+	}
+}
+
+func (s *source) string(name string, fallback string) string {
+	if value, ok := s.lookup(name); ok && value != "" {
+		return value
+	}
+	return fallback
+}
+
+func (s *source) list(name string, fallback []string) []string {
+	value, ok := s.lookup(name)
+	if !ok || strings.TrimSpace(value) == "" {
+		return fallback
+	}
+	values := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			values = append(values, item)
+		}
+	}
+	return values
+}
+
+// duration reads a whole number of unit, as in ACCESS_TOKEN_MINUTE_LIFESPAN=15
+func (s *source) duration(name string, unit time.Duration, fallback time.Duration) time.Duration {
+	value, ok := s.lookup(name)
+	if !ok || value == "" {
+		return fallback
+	}
+	count, err := strconv.Atoi(value)
+	if err != nil {
+		s.fail(fmt.Errorf("%s must be a whole number, got %q", name, value))
+		return fallback
+	}
+	return unit * time.Duration(count)
+}
+
+func (s *source) int(name string, fallback int) int {
+	value, ok := s.lookup(name)
+	if !ok || value == "" {
+		return fallback
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		s.fail(fmt.Errorf("%s must be a whole number, got %q", name, value))
+		return fallback
+	}
+	return number
+}
+
+func (s *source) bool(name string, fallback bool) bool {
+	value, ok := s.lookup(name)
+	if !ok || value == "" {
+		return fallback
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		s.fail(fmt.Errorf("%s must be true or false, got %q", name, value))
+		return fallback
+	}
+	return parsed
+}
+
+// goDuration reads a Go duration such as 5m or 30s
+func (s *source) goDuration(name string, fallback time.Duration) time.Duration {
+	value, ok := s.lookup(name)
+	if !ok || value == "" {
+		return fallback
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		s.fail(fmt.Errorf("%s must be a duration such as 5m, got %q", name, value))
+		return fallback
+	}
+	return duration
+}
+
+// readFile loads a YAML config file of setting names to values, such as "PORT: 8080"
+func readFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var document map[string]interface{}
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	values := map[string]string{}
+	for name, value := range document {
+		switch value := value.(type) {
+		case nil:
+			values[name] = ""
+		case []interface{}:
+			items := []string{}
+			for _, item := range value {
+				items = append(items, fmt.Sprint(item))
+			}
+			values[name] = strings.Join(items, ",")
+		default:
+			values[name] = fmt.Sprint(value)
+		}
+	}
+	return values, nil
+}
+
+// Flags are the settings that can be given on the command line, shared by every subcommand
+type Flags struct {
+	set        *flag.FlagSet
+	configFile *string
+	values     map[string]*string
+}
+
+// AddFlags registers -config, -env, -port and -dsn on a subcommand's flag set
+func AddFlags(set *flag.FlagSet) *Flags {
+	return &Flags{
+		set:        set,
+		configFile: set.String("config", os.Getenv("CONFIG_FILE"), "YAML file of settings, such as PORT: 8080"),
+		values: map[string]*string{
+			"ENV":  set.String("env", "", "environment, development or production"),
+			"PORT": set.String("port", "", "port to listen on"),
+			"DSN":  set.String("dsn", "", "MySQL data source name"),
+		},
+	}
+}
+
+// Load reads the configuration from command line flags, the environment and an optional config file, in that order of precedence
+// the config file is set with -config or CONFIG_FILE
+func Load(args []string) (*Config, error) {
+	set := flag.NewFlagSet("air-traffic-control", flag.ContinueOnError)
+	flags := AddFlags(set)
+	if err := set.Parse(args); err != nil {
+		return nil, err
+	}
+	return flags.Load()
+}
+
+// Load reads the configuration once the flag set has been parsed
+func (f *Flags) Load() (*Config, error) {
+	s := &source{flags: map[string]string{}, file: map[string]string{}}
+	f.set.Visit(func(flag *flag.Flag) {
+		name := strings.ToUpper(flag.Name)
+		if _, ok := f.values[name]; ok {
+			s.flags[name] = flag.Value.String()
+		}
+	})
+
+	if *f.configFile != "" {
+		file, err := readFile(*f.configFile)
+		if err != nil {
+			return nil, err
+		}
+		s.file = file
+	}
+
+	cfg := &Config{
+		Env:  s.string("ENV", ""),
+		Port: s.string("PORT", "8080"),
+		DSN:  s.string("DSN", ""),
+		Log: Log{
+			Format: s.string("LOG_FORMAT", logging.FormatJSON),
+		},
+		Server: Server{
+			ReadTimeout:     s.goDuration("SERVER_READ_TIMEOUT", 15*time.Second),
+			WriteTimeout:    s.goDuration("SERVER_WRITE_TIMEOUT", 30*time.Second),
+			IdleTimeout:     s.goDuration("SERVER_IDLE_TIMEOUT", 60*time.Second),
+			ShutdownDelay:   s.goDuration("SHUTDOWN_DELAY", 0),
+			ShutdownTimeout: s.goDuration("SHUTDOWN_TIMEOUT", 30*time.Second),
+		},
+		Database: Database{
+			MaxOpenConns:    s.int("DB_MAX_OPEN_CONNS", 25),
+			MaxIdleConns:    s.int("DB_MAX_IDLE_CONNS", 25),
+			ConnMaxLifetime: s.goDuration("DB_CONN_MAX_LIFETIME", 5*time.Minute),
+			ConnectAttempts: s.int("DB_CONNECT_ATTEMPTS", 8),
+			InitialBackoff:  s.goDuration("DB_CONNECT_INITIAL_BACKOFF", 500*time.Millisecond),
+			MaxBackoff:      s.goDuration("DB_CONNECT_MAX_BACKOFF", 10*time.Second),
+			QueryTimeout:    s.goDuration("DB_QUERY_TIMEOUT", 5*time.Second),
+		},
+		JWT: JWT{
+			Secret:               s.string("JWT_SECRET", ""),
+			SigningAlg:           s.string("JWT_SIGNING_ALG", token.AlgHS256),
+			KeysDir:              s.string("JWT_KEYS_DIR", ""),
+			SigningKid:           s.string("JWT_SIGNING_KID", ""),
+			AcceptHS256:          s.bool("JWT_ACCEPT_HS256", false),
+			AccessTokenLifespan:  s.duration("ACCESS_TOKEN_MINUTE_LIFESPAN", time.Minute, 15*time.Minute),
+			RefreshTokenLifespan: s.duration("REFRESH_TOKEN_HOUR_LIFESPAN", time.Hour, 720*time.Hour),
+			QueryTokenRoutes:     s.list("TOKEN_QUERY_ROUTES", []string{}),
+		},
+		DevIdentity: DevIdentity{
+			Login:  s.string("DEV_IDENTITY_LOGIN", ""),
+			Orgs:   s.list("DEV_IDENTITY_ORGS", []string{}),
+			Repos:  s.list("DEV_IDENTITY_REPOS", []string{}),
+			Scopes: s.list("DEV_IDENTITY_SCOPES", []string{token.ScopeCommandsRead, token.ScopeCommandsWrite}),
+		},
+		GitHub: GitHub{
+			ApiURL:        s.string("GITHUB_API_URL", ""),
+			Token:         s.string("GITHUB_TOKEN", ""),
+			WebhookSecret: s.string("GITHUB_WEBHOOK_SECRET", ""),
+			AppApiKey:     s.string("GITHUB_APP_API_KEY", ""),
+		},
+		OIDC: OIDC{
+			Issuer:   s.string("OIDC_ISSUER", ""),
+			JWKSURL:  s.string("OIDC_JWKS_URL", ""),
+			Audience: s.string("OIDC_AUDIENCE", ""),
+			Scopes:   s.list("OIDC_SCOPES", []string{token.ScopeCommandsRead, token.ScopeLocksWrite}),
+		},
+		RateLimit: RateLimit{
+			Store: s.string("RATE_LIMIT_STORE", RateLimitStoreMemory),
+		},
+		Metrics: Metrics{
+			Addr:  s.string("METRICS_ADDR", ""),
+			Token: s.string("METRICS_TOKEN", ""),
+		},
+		Tracing: Tracing{
+			Exporter:    s.string("TRACING_EXPORTER", tracing.ExporterNone),
+			Endpoint:    s.string("OTEL_EXPORTER_OTLP_ENDPOINT", "http://localhost:4318"),
+			Headers:     map[string]string{},
+			ServiceName: s.string("OTEL_SERVICE_NAME", "air-traffic-control"),
+		},
+	}
+
+	for _, header := range s.list("OTEL_EXPORTER_OTLP_HEADERS", []string{}) {
+		name, value, ok := strings.Cut(header, "=")
+		if !ok {
+			s.fail(fmt.Errorf("OTEL_EXPORTER_OTLP_HEADERS must be name=value pairs, got %q", header))
+			continue
+		}
+		cfg.Tracing.Headers[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+
+	ratio, err := strconv.ParseFloat(s.string("TRACING_SAMPLE_RATIO", "1"), 64)
+	if err != nil {
+		s.fail(fmt.Errorf("TRACING_SAMPLE_RATIO: %w", err))
+	}
+	cfg.Tracing.SampleRatio = ratio
+
+	level, err := logging.ParseLevel(s.string("LOG_LEVEL", "info"))
+	if err != nil {
+		s.fail(fmt.Errorf("LOG_LEVEL: %w", err))
+	}
+	cfg.Log.Level = level
+
+	limits, err := ratelimit.ParseLimits(s.string("RATE_LIMITS", ""))
+	if err != nil {
+		s.fail(err)
+	}
+	cfg.RateLimit.Limits = limits
+
+	if s.err != nil {
+		return nil, s.err
+	}
+	return cfg, nil
+}
+
+// Validate checks the settings the server needs are present and consistent
+func (c *Config) Validate() error {
+	if c.DSN == "" {
+		return errors.New("DSN is required")
+	}
+
+	if c.Log.Format != logging.FormatJSON && c.Log.Format != logging.FormatText {
+		return fmt.Errorf("LOG_FORMAT must be %s or %s", logging.FormatJSON, logging.FormatText)
+	}
+
+	if c.Server.ReadTimeout <= 0 || c.Server.WriteTimeout <= 0 || c.Server.IdleTimeout <= 0 {
+		return errors.New("SERVER_READ_TIMEOUT, SERVER_WRITE_TIMEOUT and SERVER_IDLE_TIMEOUT must be positive")
+	}
+	if c.Server.ShutdownDelay < 0 || c.Server.ShutdownTimeout <= 0 {
+		return errors.New("SHUTDOWN_DELAY must not be negative and SHUTDOWN_TIMEOUT must be positive")
+	}
+
+	if c.Database.MaxOpenConns <= 0 {
+		return errors.New("DB_MAX_OPEN_CONNS must be positive")
+	}
+	if c.Database.MaxIdleConns < 0 || c.Database.MaxIdleConns > c.Database.MaxOpenConns {
+		return errors.New("DB_MAX_IDLE_CONNS must be between 0 and DB_MAX_OPEN_CONNS")
+	}
+	if c.Database.ConnMaxLifetime < 0 {
+		return errors.New("DB_CONN_MAX_LIFETIME must not be negative")
+	}
+	if c.Database.ConnectAttempts <= 0 {
+		return errors.New("DB_CONNECT_ATTEMPTS must be positive")
+	}
+	if c.Database.InitialBackoff <= 0 || c.Database.MaxBackoff < c.Database.InitialBackoff {
+		return errors.New("DB_CONNECT_INITIAL_BACKOFF must be positive and at most DB_CONNECT_MAX_BACKOFF")
+	}
+
+	if c.Database.QueryTimeout <= 0 {
+		return errors.New("DB_QUERY_TIMEOUT must be positive")
+	}
+
+	port, err := strconv.Atoi(c.Port)
+	if err != nil || port <= 0 || port > 65535 {
+		return fmt.Errorf("PORT must be a port number, got %q", c.Port)
+	}
+
+	if err := c.ValidateJWT(); err != nil {
+		return err
+	}
+
+	if c.DevIdentity.Login != "" && c.Env != EnvDevelopment {
+		return fmt.Errorf("DEV_IDENTITY_LOGIN is only allowed with ENV=%s, ENV is %q", EnvDevelopment, c.Env)
+	}
+
+	// without an audience, a token GitHub minted for any other service would be accepted here
+	if c.OIDC.Issuer != "" && c.OIDC.Audience == "" {
+		return errors.New("OIDC_AUDIENCE is required when OIDC_ISSUER is set")
+	}
+	for _, scope := range c.OIDC.Scopes {
+		if !token.ValidScope(scope) {
+			return fmt.Errorf("OIDC_SCOPES contains an unknown scope %q", scope)
+		}
+	}
+
+	if c.RateLimit.Store != RateLimitStoreMemory && c.RateLimit.Store != RateLimitStoreMySQL {
+		return fmt.Errorf("RATE_LIMIT_STORE must be %s or %s", RateLimitStoreMemory, RateLimitStoreMySQL)
+	}
+
+	switch c.Tracing.Exporter {
+	case tracing.ExporterNone, tracing.ExporterStdout:
+	case tracing.ExporterOTLP:
+		if c.Tracing.Endpoint == "" {
+			return fmt.Errorf("OTEL_EXPORTER_OTLP_ENDPOINT is required when TRACING_EXPORTER is %s", tracing.ExporterOTLP)
+		}
+	default:
+		return fmt.Errorf("TRACING_EXPORTER must be %s, %s or %s", tracing.ExporterNone, tracing.ExporterStdout, tracing.ExporterOTLP)
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		return errors.New("TRACING_SAMPLE_RATIO must be between 0 and 1")
+	}
+
+	if c.Metrics.Addr != "" {
+		if _, _, err := net.SplitHostPort(c.Metrics.Addr); err != nil {
+			return fmt.Errorf("METRICS_ADDR must be host:port such as :9090, got %q", c.Metrics.Addr)
+		}
+	}
+
+	return nil
+}
+
+// ValidateJWT checks only the token signing settings, for commands that manage keys without a database
+func (c *Config) ValidateJWT() error {
+	switch c.JWT.SigningAlg {
+	case token.AlgHS256:
+		if c.JWT.Secret == "" {
+			return fmt.Errorf("JWT_SECRET is required when JWT_SIGNING_ALG is %s", token.AlgHS256)
+		}
+	case token.AlgRS256, token.AlgEdDSA:
+		if c.JWT.KeysDir == "" {
+			return fmt.Errorf("JWT_KEYS_DIR is required when JWT_SIGNING_ALG is %s", c.JWT.SigningAlg)
+		}
+		if c.JWT.AcceptHS256 && c.JWT.Secret == "" {
+			return errors.New("JWT_SECRET is required to verify HS256 tokens when JWT_ACCEPT_HS256 is set")
+		}
+	default:
+		return fmt.Errorf("JWT_SIGNING_ALG must be one of %s, %s or %s", token.AlgHS256, token.AlgRS256, token.AlgEdDSA)
+	}
+
+	if c.Env == EnvProduction && c.JWT.Secret != "" && len(c.JWT.Secret) < minProductionSecretLength {
+		return fmt.Errorf("JWT_SECRET must be at least %d characters in production", minProductionSecretLength)
+	}
+
+	if c.JWT.AccessTokenLifespan <= 0 {
+		return errors.New("ACCESS_TOKEN_MINUTE_LIFESPAN must be positive")
+	}
+	if c.JWT.RefreshTokenLifespan <= 0 {
+		return errors.New("REFRESH_TOKEN_HOUR_LIFESPAN must be positive")
+	}
+
+	return nil
+}
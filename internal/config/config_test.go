@@ -0,0 +1,222 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/runwayapp/air-traffic-control/internal/ratelimit"
+	"github.com/runwayapp/air-traffic-control/internal/tracing"
+	token "github.com/runwayapp/air-traffic-control/internal/utils"
+)
+
+const testSecret = "a-secret-of-at-least-32-characters"
+
+// writeFile writes contents to name in a temporary directory and returns its path
+func writeFile(t *testing.T, name string, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// setEnv sets every name to its value for the rest of the test, an empty value unsets it
+func setEnv(t *testing.T, env map[string]string) {
+	for name, value := range env {
+		t.Setenv(name, value)
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	configFile := writeFile(t, "config.yml", "PORT: 4000\nDSN: file-dsn\n")
+	portFile := writeFile(t, "port", "3000\n")
+
+	cases := []struct {
+		name string
+		args []string
+		env  map[string]string
+		port string
+	}{
+		{name: "flag", args: []string{"-port", "1000"}, env: map[string]string{"PORT": "2000", "PORT_FILE": portFile}, port: "1000"},
+		{name: "env", env: map[string]string{"PORT": "2000", "PORT_FILE": portFile}, port: "2000"},
+		{name: "env file", env: map[string]string{"PORT": "", "PORT_FILE": portFile}, port: "3000"},
+		{name: "config file", env: map[string]string{"PORT": "", "PORT_FILE": ""}, port: "4000"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			setEnv(t, tc.env)
+			cfg, err := Load(append([]string{"-config", configFile}, tc.args...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Port != tc.port {
+				t.Errorf("expected PORT %s, got %s", tc.port, cfg.Port)
+			}
+			// settings only in the config file are still read from it
+			if cfg.DSN != "file-dsn" {
+				t.Errorf("expected DSN from the config file, got %q", cfg.DSN)
+			}
+		})
+	}
+}
+
+func TestLoadEnvFile(t *testing.T) {
+	t.Run("trailing newline", func(t *testing.T) {
+		t.Setenv("JWT_SECRET", "")
+		t.Setenv("JWT_SECRET_FILE", writeFile(t, "secret", testSecret+"\n"))
+		cfg, err := Load(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cfg.JWT.Secret != testSecret {
+			t.Errorf("expected the trailing newline to be trimmed, got %q", cfg.JWT.Secret)
+		}
+	})
+
+	t.Run("unreadable", func(t *testing.T) {
+		t.Setenv("JWT_SECRET", "")
+		t.Setenv("JWT_SECRET_FILE", filepath.Join(t.TempDir(), "missing"))
+		if _, err := Load(nil); err == nil || !strings.Contains(err.Error(), "JWT_SECRET_FILE") {
+			t.Errorf("expected an error naming JWT_SECRET_FILE, got %v", err)
+		}
+	})
+}
+
+func TestLoadParsing(t *testing.T) {
+	cases := []struct {
+		name  string
+		env   map[string]string
+		error string
+		check func(cfg *Config) bool
+	}{
+		{name: "bool", env: map[string]string{"JWT_ACCEPT_HS256": "true"}, check: func(cfg *Config) bool { return cfg.JWT.AcceptHS256 }},
+		{name: "bool default", env: map[string]string{"JWT_ACCEPT_HS256": ""}, check: func(cfg *Config) bool { return !cfg.JWT.AcceptHS256 }},
+		{name: "invalid bool", env: map[string]string{"JWT_ACCEPT_HS256": "yes please"}, error: "JWT_ACCEPT_HS256"},
+		{name: "whole number", env: map[string]string{"ACCESS_TOKEN_MINUTE_LIFESPAN": "5"}, check: func(cfg *Config) bool { return cfg.JWT.AccessTokenLifespan == 5*time.Minute }},
+		{name: "invalid whole number", env: map[string]string{"DB_MAX_OPEN_CONNS": "many"}, error: "DB_MAX_OPEN_CONNS"},
+		{name: "invalid duration", env: map[string]string{"DB_QUERY_TIMEOUT": "5"}, error: "DB_QUERY_TIMEOUT"},
+		{name: "list", env: map[string]string{"OIDC_SCOPES": " commands:read, ,locks:write"}, check: func(cfg *Config) bool { return strings.Join(cfg.OIDC.Scopes, ",") == "commands:read,locks:write" }},
+		{name: "rate limits", env: map[string]string{"RATE_LIMITS": "team=100/10"}, check: func(cfg *Config) bool {
+			return cfg.RateLimit.Limits["team"] == ratelimit.Limit{Requests: 100, Burst: 10}
+		}},
+		{name: "invalid rate limits", env: map[string]string{"RATE_LIMITS": "team=100"}, error: "team=100"},
+		{name: "tracing headers", env: map[string]string{"OTEL_EXPORTER_OTLP_HEADERS": "authorization=Bearer abc"}, check: func(cfg *Config) bool { return cfg.Tracing.Headers["authorization"] == "Bearer abc" }},
+		{name: "invalid tracing headers", env: map[string]string{"OTEL_EXPORTER_OTLP_HEADERS": "authorization"}, error: "OTEL_EXPORTER_OTLP_HEADERS"},
+		{name: "invalid sample ratio", env: map[string]string{"TRACING_SAMPLE_RATIO": "half"}, error: "TRACING_SAMPLE_RATIO"},
+		{name: "invalid log level", env: map[string]string{"LOG_LEVEL": "loud"}, error: "LOG_LEVEL"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			setEnv(t, tc.env)
+			cfg, err := Load(nil)
+			if tc.error != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.error) {
+					t.Errorf("expected an error mentioning %s, got %v", tc.error, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tc.check(cfg) {
+				t.Errorf("unexpected config %+v", cfg)
+			}
+		})
+	}
+}
+
+// validConfig loads the defaults with the settings Validate requires
+func validConfig(t *testing.T) *Config {
+	t.Helper()
+	setEnv(t, map[string]string{"DSN": "user:password@/atc", "JWT_SECRET": testSecret, "ENV": EnvProduction})
+	cfg, err := Load(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected the defaults to be valid: %s", err)
+	}
+	return cfg
+}
+
+func TestValidate(t *testing.T) {
+	cases := []struct {
+		name  string
+		edit  func(cfg *Config)
+		error string
+	}{
+		{name: "short production secret", edit: func(cfg *Config) { cfg.JWT.Secret = "short" }, error: "JWT_SECRET must be at least"},
+		{name: "short development secret", edit: func(cfg *Config) { cfg.Env = EnvDevelopment; cfg.JWT.Secret = "short" }},
+		{name: "no secret", edit: func(cfg *Config) { cfg.JWT.Secret = "" }, error: "JWT_SECRET is required"},
+		{name: "dev identity in production", edit: func(cfg *Config) { cfg.DevIdentity.Login = "maverick" }, error: "DEV_IDENTITY_LOGIN"},
+		{name: "dev identity without an env", edit: func(cfg *Config) { cfg.Env = ""; cfg.DevIdentity.Login = "maverick" }, error: "DEV_IDENTITY_LOGIN"},
+		{name: "dev identity in development", edit: func(cfg *Config) { cfg.Env = EnvDevelopment; cfg.DevIdentity.Login = "maverick" }},
+		{name: "zero access lifespan", edit: func(cfg *Config) { cfg.JWT.AccessTokenLifespan = 0 }, error: "ACCESS_TOKEN_MINUTE_LIFESPAN"},
+		{name: "negative refresh lifespan", edit: func(cfg *Config) { cfg.JWT.RefreshTokenLifespan = -time.Hour }, error: "REFRESH_TOKEN_HOUR_LIFESPAN"},
+		{name: "no open conns", edit: func(cfg *Config) { cfg.Database.MaxOpenConns = 0 }, error: "DB_MAX_OPEN_CONNS"},
+		{name: "more idle than open conns", edit: func(cfg *Config) { cfg.Database.MaxIdleConns = cfg.Database.MaxOpenConns + 1 }, error: "DB_MAX_IDLE_CONNS"},
+		{name: "negative idle conns", edit: func(cfg *Config) { cfg.Database.MaxIdleConns = -1 }, error: "DB_MAX_IDLE_CONNS"},
+		{name: "backoff above its max", edit: func(cfg *Config) { cfg.Database.InitialBackoff = time.Minute }, error: "DB_CONNECT_INITIAL_BACKOFF"},
+		{name: "no query timeout", edit: func(cfg *Config) { cfg.Database.QueryTimeout = 0 }, error: "DB_QUERY_TIMEOUT"},
+		{name: "unknown tracing exporter", edit: func(cfg *Config) { cfg.Tracing.Exporter = "jaeger" }, error: "TRACING_EXPORTER"},
+		{name: "otlp without an endpoint", edit: func(cfg *Config) { cfg.Tracing.Exporter = tracing.ExporterOTLP; cfg.Tracing.Endpoint = "" }, error: "OTEL_EXPORTER_OTLP_ENDPOINT"},
+		{name: "sample ratio above 1", edit: func(cfg *Config) { cfg.Tracing.SampleRatio = 1.5 }, error: "TRACING_SAMPLE_RATIO"},
+		{name: "unknown rate limit store", edit: func(cfg *Config) { cfg.RateLimit.Store = "redis" }, error: "RATE_LIMIT_STORE"},
+		{name: "oidc without an audience", edit: func(cfg *Config) { cfg.OIDC.Issuer = "https://token.actions.githubusercontent.com" }, error: "OIDC_AUDIENCE"},
+		{name: "oidc with an audience", edit: func(cfg *Config) {
+			cfg.OIDC.Issuer = "https://token.actions.githubusercontent.com"
+			cfg.OIDC.Audience = "air-traffic-control"
+		}},
+		{name: "unknown oidc scope", edit: func(cfg *Config) { cfg.OIDC.Scopes = []string{"admin:everything"} }, error: "OIDC_SCOPES"},
+		{name: "key set without a keys dir", edit: func(cfg *Config) { cfg.JWT.SigningAlg = token.AlgRS256 }, error: "JWT_KEYS_DIR"},
+		{name: "hs256 transition without a secret", edit: func(cfg *Config) {
+			cfg.JWT.SigningAlg = token.AlgEdDSA
+			cfg.JWT.KeysDir = "/keys"
+			cfg.JWT.Secret = ""
+			cfg.JWT.AcceptHS256 = true
+		}, error: "JWT_ACCEPT_HS256"},
+		{name: "key set without the transition", edit: func(cfg *Config) {
+			cfg.JWT.SigningAlg = token.AlgEdDSA
+			cfg.JWT.KeysDir = "/keys"
+			cfg.JWT.Secret = ""
+		}},
+		{name: "invalid port", edit: func(cfg *Config) { cfg.Port = "70000" }, error: "PORT"},
+		{name: "invalid metrics addr", edit: func(cfg *Config) { cfg.Metrics.Addr = "9090" }, error: "METRICS_ADDR"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := validConfig(t)
+			tc.edit(cfg)
+			err := cfg.Validate()
+			if tc.error == "" {
+				if err != nil {
+					t.Errorf("expected the config to be valid, got %s", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.error) {
+				t.Errorf("expected an error mentioning %s, got %v", tc.error, err)
+			}
+		})
+	}
+}
+
+func TestValidateJWT(t *testing.T) {
+	cfg := validConfig(t)
+	cfg.DSN = ""
+	if err := cfg.ValidateJWT(); err != nil {
+		t.Errorf("expected the JWT settings to be valid without a DSN, got %s", err)
+	}
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "DSN") {
+		t.Errorf("expected an error mentioning DSN, got %v", err)
+	}
+
+	cfg.JWT.SigningAlg = token.AlgEdDSA
+	if err := cfg.ValidateJWT(); err == nil || !strings.Contains(err.Error(), "JWT_KEYS_DIR") {
+		t.Errorf("expected an error mentioning JWT_KEYS_DIR, got %v", err)
+	}
+}
@@ -0,0 +1,72 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/runwayapp/air-traffic-control/internal/logging"
+)
+
+// Options tunes the connection pool and how long startup waits for MySQL
+type Options struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	// ConnectAttempts is how many times the first ping is tried before giving up
+	ConnectAttempts int
+	InitialBackoff  time.Duration
+	MaxBackoff      time.Duration
+	QueryTimeout    time.Duration
+}
+
+// Open opens a MySQL pool and pings it until it responds, doubling the wait between attempts up to MaxBackoff
+// it returns an error if the database is still unreachable after ConnectAttempts pings
+func Open(ctx context.Context, dsn string, options Options) (*DB, error) {
+	pool, err := sql.Open("mysql", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	db := &DB{DB: pool, QueryTimeout: options.QueryTimeout}
+	if db.QueryTimeout <= 0 {
+		db.QueryTimeout = DefaultQueryTimeout
+	}
+
+	db.SetMaxOpenConns(options.MaxOpenConns)
+	db.SetMaxIdleConns(options.MaxIdleConns)
+	db.SetConnMaxLifetime(options.ConnMaxLifetime)
+
+	backoff := options.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		err = db.PingContext(ctx)
+		if err == nil {
+			return db, nil
+		}
+		if attempt >= options.ConnectAttempts {
+			db.Close()
+			return nil, fmt.Errorf("database is unreachable after %d attempts: %w", attempt, err)
+		}
+
+		logging.Default().Error("failed to ping / connect to database, retrying", "attempt", attempt, "attempts", options.ConnectAttempts, "backoff", backoff.String(), "error", err)
+		select {
+		case <-ctx.Done():
+			db.Close()
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > options.MaxBackoff {
+			backoff = options.MaxBackoff
+		}
+	}
+}
+
+// LogStats logs the pool's limits and current connections
+func LogStats(db *DB) {
+	stats := db.Stats()
+	logging.Default().Info("database pool", "max_open", stats.MaxOpenConnections, "open", stats.OpenConnections, "in_use", stats.InUse, "idle", stats.Idle)
+}
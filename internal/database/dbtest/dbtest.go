@@ -0,0 +1,221 @@
+// Package dbtest provides an in-memory database/sql driver so handlers can be tested without MySQL
+package dbtest
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/runwayapp/air-traffic-control/internal/database"
+)
+
+// Rows is the result of a query
+type Rows struct {
+	Columns []string
+	Values  [][]driver.Value
+}
+
+// Fake answers every statement with its funcs, which usually switch on the query text
+// a nil func answers queries with no rows and execs with no rows affected
+type Fake struct {
+	// Query answers statements that return rows
+	Query func(query string, args []driver.Value) (Rows, error)
+	// Exec answers statements that don't
+	Exec func(query string, args []driver.Value) (driver.Result, error)
+
+	mu sync.Mutex
+	// every statement in the order it ran
+	statements []Statement
+	commits    int
+	rollbacks  int
+}
+
+// Statement is a query or exec the fake received
+type Statement struct {
+	Query string
+	Args  []driver.Value
+}
+
+// Result reports rowsAffected rows as changed by an exec, with a last insert id of 0
+func Result(rowsAffected int64) driver.Result {
+	return result(rowsAffected)
+}
+
+type result int64
+
+func (r result) LastInsertId() (int64, error) {
+	return 0, nil
+}
+
+func (r result) RowsAffected() (int64, error) {
+	return int64(r), nil
+}
+
+// Open returns a pool backed by fake, closed when the test ends
+func Open(t *testing.T, fake *Fake) *database.DB {
+	t.Helper()
+	pool := sql.OpenDB(&connector{fake: fake})
+	t.Cleanup(func() { pool.Close() })
+	return &database.DB{DB: pool, QueryTimeout: time.Second}
+}
+
+// Statements returns every statement the fake received
+func (f *Fake) Statements() []Statement {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Statement(nil), f.statements...)
+}
+
+// Commits returns how many transactions were committed
+func (f *Fake) Commits() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.commits
+}
+
+// Rollbacks returns how many transactions were rolled back
+func (f *Fake) Rollbacks() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.rollbacks
+}
+
+func (f *Fake) record(query string, args []driver.NamedValue) []driver.Value {
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		values[i] = arg.Value
+	}
+	f.mu.Lock()
+	f.statements = append(f.statements, Statement{Query: query, Args: values})
+	f.mu.Unlock()
+	return values
+}
+
+type connector struct {
+	fake *Fake
+}
+
+func (c *connector) Connect(context.Context) (driver.Conn, error) {
+	return &conn{fake: c.fake}, nil
+}
+
+func (c *connector) Driver() driver.Driver {
+	return fakeDriver{}
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(string) (driver.Conn, error) {
+	return nil, errors.New("dbtest: open the pool with dbtest.Open")
+}
+
+type conn struct {
+	fake *Fake
+}
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	return &stmt{conn: c, query: query}, nil
+}
+
+func (c *conn) Close() error {
+	return nil
+}
+
+func (c *conn) Begin() (driver.Tx, error) {
+	return &tx{fake: c.fake}, nil
+}
+
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	values := c.fake.record(query, args)
+	if c.fake.Query == nil {
+		return &rows{}, nil
+	}
+	result, err := c.fake.Query(query, values)
+	if err != nil {
+		return nil, err
+	}
+	return &rows{columns: result.Columns, values: result.Values}, nil
+}
+
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	values := c.fake.record(query, args)
+	if c.fake.Exec == nil {
+		return Result(0), nil
+	}
+	return c.fake.Exec(query, values)
+}
+
+type stmt struct {
+	conn  *conn
+	query string
+}
+
+func (s *stmt) Close() error {
+	return nil
+}
+
+func (s *stmt) NumInput() int {
+	return -1
+}
+
+func (s *stmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.conn.ExecContext(context.Background(), s.query, named(args))
+}
+
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.conn.QueryContext(context.Background(), s.query, named(args))
+}
+
+func named(args []driver.Value) []driver.NamedValue {
+	values := make([]driver.NamedValue, len(args))
+	for i, arg := range args {
+		values[i] = driver.NamedValue{Ordinal: i + 1, Value: arg}
+	}
+	return values
+}
+
+type tx struct {
+	fake *Fake
+}
+
+func (t *tx) Commit() error {
+	t.fake.mu.Lock()
+	t.fake.commits++
+	t.fake.mu.Unlock()
+	return nil
+}
+
+func (t *tx) Rollback() error {
+	t.fake.mu.Lock()
+	t.fake.rollbacks++
+	t.fake.mu.Unlock()
+	return nil
+}
+
+type rows struct {
+	columns []string
+	values  [][]driver.Value
+	next    int
+}
+
+func (r *rows) Columns() []string {
+	return r.columns
+}
+
+func (r *rows) Close() error {
+	return nil
+}
+
+func (r *rows) Next(dest []driver.Value) error {
+	if r.next >= len(r.values) {
+		return io.EOF
+	}
+	copy(dest, r.values[r.next])
+	r.next++
+	return nil
+}
@@ -0,0 +1,253 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// DefaultQueryTimeout is used when Options.QueryTimeout is not set
+const DefaultQueryTimeout = 5 * time.Second
+
+// MySQL error number when the server has no free connections
+const errTooManyConnections = 1040
+
+// ErrUnavailable wraps errors that mean the database can't be reached, as opposed to a query failing
+var ErrUnavailable = errors.New("database unavailable")
+
+// DB is a MySQL pool that bounds every query by QueryTimeout on top of the caller's context
+// the caller's context is usually the request's, so queries also stop when the client disconnects
+type DB struct {
+	*sql.DB
+	QueryTimeout time.Duration
+	// told about every statement, set before the pool is used
+	Observers []Observer
+}
+
+// Observer is told about every statement the pool runs, for metrics and tracing
+type Observer interface {
+	// StartQuery is called before a statement runs with operation query, query_row, exec or commit
+	// it returns the context to run the statement with and a func called with its error once it finishes
+	StartQuery(ctx context.Context, operation string, query string) (context.Context, func(err error))
+}
+
+// observe starts every observer for a statement and returns a func that finishes them in reverse order
+func (db *DB) observe(ctx context.Context, operation string, query string) (context.Context, func(err error)) {
+	if len(db.Observers) == 0 {
+		return ctx, func(error) {}
+	}
+
+	finishes := make([]func(error), len(db.Observers))
+	for i, observer := range db.Observers {
+		ctx, finishes[i] = observer.StartQuery(ctx, operation, query)
+	}
+	return ctx, func(err error) {
+		for i := len(finishes) - 1; i >= 0; i-- {
+			finishes[i](err)
+		}
+	}
+}
+
+// Timeout returns ctx limited to the query timeout
+func (db *DB) Timeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, db.QueryTimeout)
+}
+
+// Rows cancels its query's timeout when closed
+type Rows struct {
+	*sql.Rows
+	ctx    context.Context
+	cancel context.CancelFunc
+	finish func(error)
+	once   sync.Once
+}
+
+func (r *Rows) Close() error {
+	defer r.cancel()
+	err := classify(r.ctx, r.Rows.Close())
+	r.once.Do(func() {
+		if err != nil {
+			r.finish(err)
+			return
+		}
+		r.finish(classify(r.ctx, r.Rows.Err()))
+	})
+	return err
+}
+
+func (r *Rows) Err() error {
+	return classify(r.ctx, r.Rows.Err())
+}
+
+// Row cancels its query's timeout once it has been scanned
+type Row struct {
+	row    *sql.Row
+	ctx    context.Context
+	cancel context.CancelFunc
+	finish func(error)
+}
+
+func (r *Row) Scan(dest ...interface{}) error {
+	defer r.cancel()
+	err := classify(r.ctx, r.row.Scan(dest...))
+	r.finish(err)
+	return err
+}
+
+func (db *DB) QueryContext(ctx context.Context, query string, args ...interface{}) (*Rows, error) {
+	ctx, finish := db.observe(ctx, "query", query)
+	queryCtx, cancel := db.Timeout(ctx)
+	rows, err := db.DB.QueryContext(queryCtx, query, args...)
+	if err != nil {
+		cancel()
+		err = classify(ctx, err)
+		finish(err)
+		return nil, err
+	}
+	return &Rows{Rows: rows, ctx: ctx, cancel: cancel, finish: finish}, nil
+}
+
+func (db *DB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *Row {
+	ctx, finish := db.observe(ctx, "query_row", query)
+	queryCtx, cancel := db.Timeout(ctx)
+	return &Row{row: db.DB.QueryRowContext(queryCtx, query, args...), ctx: ctx, cancel: cancel, finish: finish}
+}
+
+func (db *DB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	ctx, finish := db.observe(ctx, "exec", query)
+	queryCtx, cancel := db.Timeout(ctx)
+	defer cancel()
+	result, err := db.DB.ExecContext(queryCtx, query, args...)
+	err = classify(ctx, err)
+	finish(err)
+	return result, err
+}
+
+// Tx is a transaction whose statements are each bounded by the query timeout
+type Tx struct {
+	*sql.Tx
+	db  *DB
+	ctx context.Context
+}
+
+// BeginTx starts a transaction that is rolled back if ctx is cancelled before it commits
+func (db *DB) BeginTx(ctx context.Context, opts *sql.TxOptions) (*Tx, error) {
+	tx, err := db.DB.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, classify(ctx, err)
+	}
+	return &Tx{Tx: tx, db: db, ctx: ctx}, nil
+}
+
+func (tx *Tx) QueryContext(ctx context.Context, query string, args ...interface{}) (*Rows, error) {
+	ctx, finish := tx.db.observe(ctx, "query", query)
+	queryCtx, cancel := tx.db.Timeout(ctx)
+	rows, err := tx.Tx.QueryContext(queryCtx, query, args...)
+	if err != nil {
+		cancel()
+		err = classify(ctx, err)
+		finish(err)
+		return nil, err
+	}
+	return &Rows{Rows: rows, ctx: ctx, cancel: cancel, finish: finish}, nil
+}
+
+func (tx *Tx) QueryRowContext(ctx context.Context, query string, args ...interface{}) *Row {
+	ctx, finish := tx.db.observe(ctx, "query_row", query)
+	queryCtx, cancel := tx.db.Timeout(ctx)
+	return &Row{row: tx.Tx.QueryRowContext(queryCtx, query, args...), ctx: ctx, cancel: cancel, finish: finish}
+}
+
+func (tx *Tx) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	ctx, finish := tx.db.observe(ctx, "exec", query)
+	queryCtx, cancel := tx.db.Timeout(ctx)
+	defer cancel()
+	result, err := tx.Tx.ExecContext(queryCtx, query, args...)
+	err = classify(ctx, err)
+	finish(err)
+	return result, err
+}
+
+func (tx *Tx) Commit() error {
+	_, finish := tx.db.observe(tx.ctx, "commit", "COMMIT")
+	err := classify(tx.ctx, tx.Tx.Commit())
+	finish(err)
+	return err
+}
+
+type failureKey struct{}
+
+// Failure records why a query run with a context from WithFailure could not complete,
+// so a request that failed on a timeout or an unreachable database can be answered with a 504 or 503
+type Failure struct {
+	mu  sync.Mutex
+	err error
+}
+
+// WithFailure returns a context that records timeouts and connection failures of the queries run with it
+func WithFailure(ctx context.Context) (context.Context, *Failure) {
+	failure := &Failure{}
+	return context.WithValue(ctx, failureKey{}, failure), failure
+}
+
+// Err returns the first timeout or connection failure recorded, nil if there was none
+func (f *Failure) Err() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.err
+}
+
+func (f *Failure) record(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err == nil {
+		f.err = err
+	}
+}
+
+// classify wraps connection failures in ErrUnavailable, and records them and timeouts on ctx's Failure
+func classify(ctx context.Context, err error) error {
+	err = classifyError(err)
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, ErrUnavailable) {
+		if failure, ok := ctx.Value(failureKey{}).(*Failure); ok {
+			failure.record(err)
+		}
+	}
+	return err
+}
+
+func classifyError(err error) error {
+	if err == nil || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return err
+	}
+
+	var mysqlError *mysql.MySQLError
+	var netError net.Error
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, mysql.ErrInvalidConn) || errors.Is(err, sql.ErrConnDone) ||
+		(errors.As(err, &mysqlError) && mysqlError.Number == errTooManyConnections) || errors.As(err, &netError) {
+		return &unavailableError{err: err}
+	}
+	return err
+}
+
+type unavailableError struct {
+	err error
+}
+
+func (e *unavailableError) Error() string {
+	return ErrUnavailable.Error() + ": " + e.err.Error()
+}
+
+func (e *unavailableError) Is(target error) bool {
+	return target == ErrUnavailable
+}
+
+func (e *unavailableError) Unwrap() error {
+	return e.err
+}
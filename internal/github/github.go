@@ -0,0 +1,89 @@
+package github
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/runwayapp/air-traffic-control/internal/tracing"
+)
+
+// DefaultBaseURL is the public GitHub REST API
+const DefaultBaseURL = "https://api.github.com"
+
+// the largest file the client will download
+const maxContentBytes = 1 << 20
+
+// ErrNotFound is returned when the requested file or repository does not exist
+var ErrNotFound = errors.New("github: not found")
+
+// ErrTooLarge is returned instead of a truncated file when a file is larger than the client will download
+var ErrTooLarge = fmt.Errorf("github: file is larger than %d bytes", maxContentBytes)
+
+// Client is a minimal GitHub REST API client
+// BaseURL is configurable so a local stand-in can be used instead of api.github.com
+type Client struct {
+	BaseURL    string
+	Token      string
+	HTTPClient *http.Client
+}
+
+// NewClient returns a client for baseURL, falling back to DefaultBaseURL when it is empty
+func NewClient(baseURL string, token string) *Client {
+	if baseURL == "" {
+		baseURL = DefaultBaseURL
+	}
+
+	return &Client{
+		BaseURL:    strings.TrimSuffix(baseURL, "/"),
+		Token:      token,
+		HTTPClient: &http.Client{Timeout: 10 * time.Second, Transport: &tracing.Transport{}},
+	}
+}
+
+// GetFileContents returns the raw contents of a file in a repository at ref
+// an empty ref uses the repository's default branch
+func (c *Client) GetFileContents(ctx context.Context, owner string, repo string, path string, ref string) ([]byte, error) {
+	endpoint := fmt.Sprintf("%s/repos/%s/%s/contents/%s", c.BaseURL, url.PathEscape(owner), url.PathEscape(repo), strings.TrimPrefix(path, "/"))
+	if ref != "" {
+		endpoint += "?ref=" + url.QueryEscape(ref)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.github.raw")
+	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	res, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("github: GET %s returned %s", endpoint, res.Status)
+	}
+
+	// read one byte past the limit to tell a file at the limit from a larger one
+	content, err := io.ReadAll(io.LimitReader(res.Body, maxContentBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(content) > maxContentBytes {
+		return nil, ErrTooLarge
+	}
+	return content, nil
+}
@@ -0,0 +1,79 @@
+package github
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGetFileContents(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/repos/runwayapp/test-flight/contents/.github/runway.yml" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Header.Get("Accept") != "application/vnd.github.raw" {
+			t.Errorf("expected the raw media type, got %q", r.Header.Get("Accept"))
+		}
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			t.Errorf("expected the token to be sent, got %q", r.Header.Get("Authorization"))
+		}
+		if r.URL.Query().Get("ref") != "main" {
+			t.Errorf("expected ref main, got %q", r.URL.Query().Get("ref"))
+		}
+		w.Write([]byte("commands: []\n"))
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL+"/", "secret")
+
+	content, err := client.GetFileContents(context.Background(), "runwayapp", "test-flight", ".github/runway.yml", "main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "commands: []\n" {
+		t.Errorf("unexpected content %q", content)
+	}
+
+	_, err = client.GetFileContents(context.Background(), "runwayapp", "missing", ".github/runway.yml", "main")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestGetFileContentsSize(t *testing.T) {
+	cases := map[int]error{
+		maxContentBytes:     nil,
+		maxContentBytes + 1: ErrTooLarge,
+	}
+	for size, expected := range cases {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(strings.Repeat("a", size)))
+		}))
+
+		content, err := NewClient(server.URL, "").GetFileContents(context.Background(), "runwayapp", "test-flight", "runway.yml", "")
+		server.Close()
+		if !errors.Is(err, expected) {
+			t.Errorf("%d bytes: expected %v, got %v", size, expected, err)
+		}
+		// a file over the limit must never come back truncated
+		if err == nil && len(content) != size {
+			t.Errorf("%d bytes: got %d bytes back", size, len(content))
+		}
+	}
+}
+
+func TestGetFileContentsError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	_, err := NewClient(server.URL, "").GetFileContents(context.Background(), "runwayapp", "test-flight", "runway.yml", "")
+	if err == nil || errors.Is(err, ErrNotFound) {
+		t.Errorf("expected an error for a 502, got %v", err)
+	}
+}
@@ -0,0 +1,89 @@
+package health
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// check statuses
+const (
+	StatusOk      = "ok"
+	StatusFailing = "failing"
+)
+
+// CheckTimeout bounds how long a single check may take
+const CheckTimeout = 2 * time.Second
+
+type check struct {
+	name string
+	run  func(ctx context.Context) error
+}
+
+// Result is the outcome of a single dependency check
+type Result struct {
+	Name       string  `json:"name"`
+	Status     string  `json:"status"`
+	Latency_ms float64 `json:"latency_ms"`
+	// logged rather than reported, it can name hosts and users of the dependency
+	Error string `json:"-"`
+}
+
+type Report struct {
+	Status string   `json:"status"`
+	Checks []Result `json:"checks"`
+}
+
+// Checker runs dependency checks and tracks whether the process is shutting down
+type Checker struct {
+	checks       []check
+	shuttingDown atomic.Bool
+}
+
+// Add registers a check that is run for readiness and the health report
+func (h *Checker) Add(name string, run func(ctx context.Context) error) {
+	h.checks = append(h.checks, check{name: name, run: run})
+}
+
+// ShutDown marks the process as shutting down so readiness fails and load balancers stop routing to it
+func (h *Checker) ShutDown() {
+	h.shuttingDown.Store(true)
+}
+
+func (h *Checker) ShuttingDown() bool {
+	return h.shuttingDown.Load()
+}
+
+// Run runs every check concurrently, each bounded by CheckTimeout
+func (h *Checker) Run(ctx context.Context) Report {
+	results := make([]Result, len(h.checks))
+
+	var wg sync.WaitGroup
+	for i, c := range h.checks {
+		wg.Add(1)
+		go func(i int, c check) {
+			defer wg.Done()
+
+			checkCtx, cancel := context.WithTimeout(ctx, CheckTimeout)
+			defer cancel()
+
+			start := time.Now()
+			err := c.run(checkCtx)
+			results[i] = Result{Name: c.name, Status: StatusOk, Latency_ms: float64(time.Since(start).Microseconds()) / 1000}
+			if err != nil {
+				results[i].Status = StatusFailing
+				results[i].Error = err.Error()
+			}
+		}(i, c)
+	}
+	wg.Wait()
+
+	report := Report{Status: StatusOk, Checks: results}
+	for _, result := range results {
+		if result.Status != StatusOk {
+			report.Status = StatusFailing
+		}
+	}
+	return report
+}
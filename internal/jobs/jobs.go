@@ -0,0 +1,91 @@
+package jobs
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/runwayapp/air-traffic-control/internal/logging"
+	"github.com/runwayapp/air-traffic-control/internal/metrics"
+	"github.com/runwayapp/air-traffic-control/internal/tracing"
+)
+
+// Worker runs a function on a fixed interval in the background until it is stopped or its context is cancelled
+type Worker struct {
+	Name     string
+	Interval time.Duration
+	Run      func(ctx context.Context) error
+
+	running  atomic.Bool
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+// Start launches the worker in a new goroutine and returns immediately
+func (w *Worker) Start(ctx context.Context) {
+	w.stop = make(chan struct{})
+	w.done = make(chan struct{})
+	w.running.Store(true)
+	metrics.JobRunning.Set(1, w.Name)
+	go func() {
+		defer close(w.done)
+		defer w.running.Store(false)
+		defer metrics.JobRunning.Set(0, w.Name)
+
+		ticker := time.NewTicker(w.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-w.stop:
+				return
+			case <-ticker.C:
+				w.run(ctx)
+			}
+		}
+	}()
+}
+
+// run runs the worker once in its own trace, recording its outcome and latency
+func (w *Worker) run(ctx context.Context) {
+	ctx, span := tracing.Default().Start(ctx, "job "+w.Name, tracing.KindInternal)
+	span.SetAttribute("job.name", w.Name)
+	defer span.Finish()
+
+	start := time.Now()
+	err := w.Run(ctx)
+	metrics.JobRunDuration.ObserveSince(start, w.Name)
+	span.RecordError(err)
+
+	if err != nil {
+		metrics.JobRuns.Inc(w.Name, "error")
+		logging.Default().Error("worker run failed", "worker", w.Name, "error", err)
+		return
+	}
+	metrics.JobRuns.Inc(w.Name, "ok")
+	metrics.JobLastSuccess.Set(float64(time.Now().Unix()), w.Name)
+}
+
+// Stop asks the worker to exit once its current run, if any, finishes
+func (w *Worker) Stop() {
+	w.stopOnce.Do(func() { close(w.stop) })
+}
+
+// Wait blocks until the worker has exited or ctx is done
+func (w *Worker) Wait(ctx context.Context) error {
+	select {
+	case <-w.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Running reports whether the worker has been started and has not stopped
+func (w *Worker) Running() bool {
+	return w.running.Load()
+}
@@ -0,0 +1,18 @@
+package logging
+
+import "context"
+
+type contextKey struct{}
+
+// NewContext returns a context carrying logger, such as one with the request id attached
+func NewContext(ctx context.Context, logger *Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, logger)
+}
+
+// FromContext returns the logger stored by NewContext, or the default logger
+func FromContext(ctx context.Context) *Logger {
+	if logger, ok := ctx.Value(contextKey{}).(*Logger); ok {
+		return logger
+	}
+	return Default()
+}
@@ -0,0 +1,232 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = map[Level]string{LevelDebug: "debug", LevelInfo: "info", LevelWarn: "warn", LevelError: "error"}
+
+func (l Level) String() string {
+	return levelNames[l]
+}
+
+// ParseLevel parses debug, info, warn or error
+func ParseLevel(value string) (Level, error) {
+	for level, name := range levelNames {
+		if strings.EqualFold(value, name) {
+			return level, nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("unknown log level %q, must be debug, info, warn or error", value)
+}
+
+// output formats
+const (
+	FormatJSON = "json"
+	FormatText = "text"
+)
+
+// field names whose values are never logged
+var redactedFields = map[string]bool{
+	"token":         true,
+	"access_token":  true,
+	"refresh_token": true,
+	"authorization": true,
+	"api_key":       true,
+	"x-api-key":     true,
+	"secret":        true,
+	"password":      true,
+}
+
+// credentials that are redacted wherever they appear in a message or value:
+// JWTs, API keys (atc_<id>_<secret>) and refresh tokens (atcr_...)
+var credentialPattern = regexp.MustCompile(`eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*|\batcr?_[A-Za-z0-9_-]+`)
+
+// Redact replaces credentials in a string
+func Redact(value string) string {
+	return credentialPattern.ReplaceAllString(value, "REDACTED")
+}
+
+type field struct {
+	key   string
+	value interface{}
+}
+
+// Logger writes leveled, structured log lines, it is safe for concurrent use
+type Logger struct {
+	out    io.Writer
+	mu     *sync.Mutex
+	level  Level
+	format string
+	fields []field
+}
+
+func New(out io.Writer, level Level, format string) *Logger {
+	return &Logger{out: out, mu: &sync.Mutex{}, level: level, format: format}
+}
+
+var defaultLogger = New(os.Stderr, LevelInfo, FormatJSON)
+
+// Default returns the logger set by SetDefault
+func Default() *Logger {
+	return defaultLogger
+}
+
+func SetDefault(logger *Logger) {
+	defaultLogger = logger
+}
+
+// pairs turns alternating keys and values into fields
+func pairs(args []interface{}) []field {
+	fields := []field{}
+	for i := 0; i < len(args); i += 2 {
+		key := fmt.Sprint(args[i])
+		if i+1 == len(args) {
+			fields = append(fields, field{key: "!BADKEY", value: key})
+			break
+		}
+		fields = append(fields, field{key: key, value: args[i+1]})
+	}
+	return fields
+}
+
+// With returns a logger that adds the given key value pairs to every line
+func (l *Logger) With(args ...interface{}) *Logger {
+	child := *l
+	child.fields = append(append([]field{}, l.fields...), pairs(args)...)
+	return &child
+}
+
+// Enabled reports whether lines at level are written
+func (l *Logger) Enabled(level Level) bool {
+	return level >= l.level
+}
+
+func (l *Logger) Debug(msg string, args ...interface{}) { l.Log(LevelDebug, msg, args...) }
+func (l *Logger) Info(msg string, args ...interface{})  { l.Log(LevelInfo, msg, args...) }
+func (l *Logger) Warn(msg string, args ...interface{})  { l.Log(LevelWarn, msg, args...) }
+func (l *Logger) Error(msg string, args ...interface{}) { l.Log(LevelError, msg, args...) }
+
+// Log writes msg and key value pairs at level
+func (l *Logger) Log(level Level, msg string, args ...interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+
+	fields := append([]field{
+		{key: "time", value: time.Now().UTC().Format(time.RFC3339Nano)},
+		{key: "level", value: level.String()},
+		{key: "msg", value: Redact(msg)},
+	}, l.fields...)
+	fields = append(fields, pairs(args)...)
+
+	var line bytes.Buffer
+	if l.format == FormatText {
+		writeText(&line, fields)
+	} else {
+		writeJSON(&line, fields)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.out.Write(line.Bytes())
+}
+
+// value returns what is logged for a field, errors as their message, durations in milliseconds and credentials removed
+func value(f field) interface{} {
+	if redactedFields[strings.ToLower(f.key)] {
+		return "REDACTED"
+	}
+	switch v := f.value.(type) {
+	case error:
+		return Redact(v.Error())
+	case string:
+		return Redact(v)
+	case time.Duration:
+		return float64(v.Microseconds()) / 1000
+	case fmt.Stringer:
+		return Redact(v.String())
+	}
+	return f.value
+}
+
+func writeJSON(line *bytes.Buffer, fields []field) {
+	line.WriteByte('{')
+	for i, f := range fields {
+		if i > 0 {
+			line.WriteByte(',')
+		}
+		key, _ := json.Marshal(f.key)
+		data, err := json.Marshal(value(f))
+		if err != nil {
+			data, _ = json.Marshal(fmt.Sprint(f.value))
+		}
+		line.Write(key)
+		line.WriteByte(':')
+		line.Write(data)
+	}
+	line.WriteString("}\n")
+}
+
+func writeText(line *bytes.Buffer, fields []field) {
+	for i, f := range fields {
+		if i > 0 {
+			line.WriteByte(' ')
+		}
+		fmt.Fprintf(line, "%s=%v", f.key, value(f))
+	}
+	line.WriteByte('\n')
+}
+
+// StdWriter adapts the standard library's log package to the logger, use it with log.SetOutput and log.SetFlags(0)
+// lines starting with "ERROR: " are logged at error level and "WARNING: " at warn level
+func (l *Logger) StdWriter() io.Writer {
+	return stdWriter{logger: l}
+}
+
+type stdWriter struct {
+	logger *Logger
+}
+
+func (w stdWriter) Write(p []byte) (int, error) {
+	msg := strings.TrimRight(string(p), "\n")
+	level := LevelInfo
+	if rest, ok := cutPrefix(msg, "ERROR: "); ok {
+		level, msg = LevelError, rest
+	} else if rest, ok := cutPrefix(msg, "WARNING: "); ok {
+		level, msg = LevelWarn, rest
+	}
+	w.logger.Log(level, msg)
+	return len(p), nil
+}
+
+// cutPrefix is strings.CutPrefix, which needs Go 1.20
+func cutPrefix(s string, prefix string) (string, bool) {
+	if !strings.HasPrefix(s, prefix) {
+		return s, false
+	}
+	return s[len(prefix):], true
+}
+
+// Fatal logs msg at error level and exits, like log.Fatal
+func (l *Logger) Fatal(msg string, args ...interface{}) {
+	l.Log(LevelError, msg, args...)
+	os.Exit(1)
+}
@@ -0,0 +1,63 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLoggerJSON(t *testing.T) {
+	var out bytes.Buffer
+	logger := New(&out, LevelInfo, FormatJSON).With("request_id", "abc")
+
+	logger.Debug("hidden")
+	logger.Warn("slow request", "latency_ms", 1500*time.Microsecond, "error", errors.New("boom"), "token", "secret")
+
+	var line map[string]interface{}
+	if err := json.Unmarshal(out.Bytes(), &line); err != nil {
+		t.Fatalf("expected a single JSON line, got %q: %v", out.String(), err)
+	}
+	expected := map[string]interface{}{
+		"level":      "warn",
+		"msg":        "slow request",
+		"request_id": "abc",
+		"latency_ms": 1.5,
+		"error":      "boom",
+		"token":      "REDACTED",
+	}
+	for key, value := range expected {
+		if line[key] != value {
+			t.Errorf("%s = %v, expected %v", key, line[key], value)
+		}
+	}
+}
+
+func TestRedact(t *testing.T) {
+	cases := map[string]string{
+		"no credentials here":                       "no credentials here",
+		"bearer eyJhbGciOi.eyJzdWIiOi.c2lnbmF0dXJl": "bearer REDACTED",
+		"key atc_0123abcd_s3cr3t rejected":          "key REDACTED rejected",
+		"refresh atcr_abcdef reused":                "refresh REDACTED reused",
+	}
+	for value, expected := range cases {
+		if got := Redact(value); got != expected {
+			t.Errorf("Redact(%q) = %q, expected %q", value, got, expected)
+		}
+	}
+}
+
+func TestStdWriter(t *testing.T) {
+	var out bytes.Buffer
+	writer := New(&out, LevelInfo, FormatJSON).StdWriter()
+	writer.Write([]byte("ERROR: failed to connect\n"))
+
+	var line map[string]interface{}
+	if err := json.Unmarshal(out.Bytes(), &line); err != nil {
+		t.Fatal(err)
+	}
+	if line["level"] != "error" || line["msg"] != "failed to connect" {
+		t.Errorf("unexpected line %v", line)
+	}
+}
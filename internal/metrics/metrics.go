@@ -0,0 +1,294 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ContentType is the Prometheus text exposition format version written by WriteTo
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefBuckets are latency buckets in seconds, from 5ms to 10s
+var DefBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// collector writes the samples of one metric family
+type collector interface {
+	write(w *bufio.Writer)
+}
+
+// Registry holds metric families and writes them in the Prometheus text format
+type Registry struct {
+	mu         sync.Mutex
+	names      map[string]bool
+	collectors []collector
+}
+
+func NewRegistry() *Registry {
+	return &Registry{names: map[string]bool{}}
+}
+
+// Default is the registry the service's metrics are defined in
+var Default = NewRegistry()
+
+func (r *Registry) register(name string, c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.names[name] {
+		panic(fmt.Sprintf("metric %s is already registered", name))
+	}
+	r.names[name] = true
+	r.collectors = append(r.collectors, c)
+}
+
+// ServeHTTP serves the registry to Prometheus scrapes
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", ContentType)
+	r.WriteTo(w)
+}
+
+// WriteTo writes every metric family in the Prometheus text exposition format
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	collectors := append([]collector{}, r.collectors...)
+	r.mu.Unlock()
+
+	counter := &countingWriter{w: w}
+	buffered := bufio.NewWriter(counter)
+	for _, c := range collectors {
+		c.write(buffered)
+	}
+	err := buffered.Flush()
+	return counter.n, err
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// family is the name, help and labels shared by the series of a metric
+type family struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+}
+
+func (f family) header(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", f.name, strings.ReplaceAll(f.help, "\n", " "), f.name, f.kind)
+}
+
+// key joins label values into a map key
+func (f family) key(values []string) string {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metric %s has labels %v, got values %v", f.name, f.labels, values))
+	}
+	return strings.Join(values, "\xff")
+}
+
+// series formats name{label="value",...} with extra appended as one more label pair
+func (f family) series(name string, key string, extra ...string) string {
+	pairs := []string{}
+	if len(f.labels) > 0 {
+		for i, value := range strings.Split(key, "\xff") {
+			pairs = append(pairs, fmt.Sprintf(`%s="%s"`, f.labels[i], labelEscaper.Replace(value)))
+		}
+	}
+	if len(extra) == 2 {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, extra[0], extra[1]))
+	}
+	if len(pairs) == 0 {
+		return name
+	}
+	return name + "{" + strings.Join(pairs, ",") + "}"
+}
+
+// labelEscaper escapes label values as the exposition format expects
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func sortedKeys(values map[string]float64) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Counter is a family of monotonically increasing values, one per set of label values
+type Counter struct {
+	family
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+// Counter registers a counter, names should end in _total
+func (r *Registry) Counter(name string, help string, labels ...string) *Counter {
+	c := &Counter{family: family{name: name, help: help, kind: "counter", labels: labels}, values: map[string]float64{}}
+	r.register(name, c)
+	return c
+}
+
+// Inc adds one to the series with the given label values
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+func (c *Counter) Add(delta float64, values ...string) {
+	if delta < 0 {
+		panic(fmt.Sprintf("counter %s can't decrease", c.name))
+	}
+	key := c.key(values)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[key] += delta
+}
+
+func (c *Counter) write(w *bufio.Writer) {
+	writeValues(w, c.family, &c.mu, c.values)
+}
+
+// Gauge is a family of values that go up and down, one per set of label values
+type Gauge struct {
+	family
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+func (r *Registry) Gauge(name string, help string, labels ...string) *Gauge {
+	g := &Gauge{family: family{name: name, help: help, kind: "gauge", labels: labels}, values: map[string]float64{}}
+	r.register(name, g)
+	return g
+}
+
+func (g *Gauge) Set(value float64, values ...string) {
+	key := g.key(values)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.values[key] = value
+}
+
+func (g *Gauge) write(w *bufio.Writer) {
+	writeValues(w, g.family, &g.mu, g.values)
+}
+
+func writeValues(w *bufio.Writer, f family, mu *sync.Mutex, values map[string]float64) {
+	mu.Lock()
+	defer mu.Unlock()
+	f.header(w)
+	for _, key := range sortedKeys(values) {
+		fmt.Fprintf(w, "%s %s\n", f.series(f.name, key), formatFloat(values[key]))
+	}
+}
+
+// valueFunc is a metric without labels read when it is scraped, such as a connection pool statistic
+type valueFunc struct {
+	family
+	read func() float64
+}
+
+// GaugeFunc registers a gauge whose value is read from fn on every scrape
+func (r *Registry) GaugeFunc(name string, help string, fn func() float64) {
+	r.register(name, &valueFunc{family: family{name: name, help: help, kind: "gauge"}, read: fn})
+}
+
+// CounterFunc registers a counter whose value is read from fn on every scrape
+func (r *Registry) CounterFunc(name string, help string, fn func() float64) {
+	r.register(name, &valueFunc{family: family{name: name, help: help, kind: "counter"}, read: fn})
+}
+
+func (v *valueFunc) write(w *bufio.Writer) {
+	v.header(w)
+	fmt.Fprintf(w, "%s %s\n", v.name, formatFloat(v.read()))
+}
+
+// Histogram is a family of observation distributions, one per set of label values
+type Histogram struct {
+	family
+	buckets []float64
+	mu      sync.Mutex
+	series  map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// Histogram registers a histogram with upper bounds buckets, which must be sorted
+func (r *Registry) Histogram(name string, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{family: family{name: name, help: help, kind: "histogram", labels: labels}, buckets: buckets, series: map[string]*histogramSeries{}}
+	r.register(name, h)
+	return h
+}
+
+func (h *Histogram) Observe(value float64, values ...string) {
+	key := h.key(values)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	for i, bound := range h.buckets {
+		if value <= bound {
+			s.counts[i]++
+		}
+	}
+	s.sum += value
+	s.count++
+}
+
+// ObserveSince observes the seconds elapsed since start
+func (h *Histogram) ObserveSince(start time.Time, values ...string) {
+	h.Observe(time.Since(start).Seconds(), values...)
+}
+
+func (h *Histogram) write(w *bufio.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.header(w)
+
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s := h.series[key]
+		for i, bound := range h.buckets {
+			fmt.Fprintf(w, "%s %d\n", h.family.series(h.name+"_bucket", key, "le", formatFloat(bound)), s.counts[i])
+		}
+		fmt.Fprintf(w, "%s %d\n", h.family.series(h.name+"_bucket", key, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s %s\n", h.family.series(h.name+"_sum", key), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s %d\n", h.family.series(h.name+"_count", key), s.count)
+	}
+}
@@ -0,0 +1,43 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWriteTo(t *testing.T) {
+	registry := NewRegistry()
+	requests := registry.Counter("test_requests_total", "Requests.", "route", "status")
+	latency := registry.Histogram("test_latency_seconds", "Latency.", []float64{0.1, 1}, "route")
+	registry.GaugeFunc("test_connections", "Connections.", func() float64 { return 3 })
+
+	requests.Inc("/orgs/:org", "200")
+	requests.Add(2, "/orgs/:org", "200")
+	requests.Inc(`/odd"route\`, "500")
+	latency.Observe(0.05, "/orgs/:org")
+	latency.Observe(0.5, "/orgs/:org")
+
+	var out strings.Builder
+	if _, err := registry.WriteTo(&out); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `# HELP test_requests_total Requests.
+# TYPE test_requests_total counter
+test_requests_total{route="/odd\"route\\",status="500"} 1
+test_requests_total{route="/orgs/:org",status="200"} 3
+# HELP test_latency_seconds Latency.
+# TYPE test_latency_seconds histogram
+test_latency_seconds_bucket{route="/orgs/:org",le="0.1"} 1
+test_latency_seconds_bucket{route="/orgs/:org",le="1"} 2
+test_latency_seconds_bucket{route="/orgs/:org",le="+Inf"} 2
+test_latency_seconds_sum{route="/orgs/:org"} 0.55
+test_latency_seconds_count{route="/orgs/:org"} 2
+# HELP test_connections Connections.
+# TYPE test_connections gauge
+test_connections 3
+`
+	if out.String() != expected {
+		t.Errorf("unexpected output:\n%s\nexpected:\n%s", out.String(), expected)
+	}
+}
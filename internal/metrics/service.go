@@ -0,0 +1,89 @@
+package metrics
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/runwayapp/air-traffic-control/internal/database"
+)
+
+// the service's metrics, all in the Default registry
+var (
+	HttpRequests = Default.Counter("atc_http_requests_total",
+		"HTTP requests by method, route pattern and status.", "method", "route", "status")
+	HttpRequestDuration = Default.Histogram("atc_http_request_duration_seconds",
+		"HTTP request latency by method, route pattern and status.", DefBuckets, "method", "route", "status")
+
+	DatabaseQueryDuration = Default.Histogram("atc_db_query_duration_seconds",
+		"Database statement latency by operation and outcome (ok, error, timeout, unavailable or canceled).", DefBuckets, "operation", "outcome")
+
+	AuthFailures = Default.Counter("atc_auth_failures_total",
+		"Rejected authentication or authorization attempts by reason.", "reason")
+
+	CommandResolutions = Default.Counter("atc_command_resolutions_total",
+		"Comment resolutions by outcome.", "outcome")
+
+	Invocations = Default.Counter("atc_invocations_total",
+		"Command invocations by the state they moved to, the results of actions gated by approvals.", "state")
+	InvocationsPending = Default.Gauge("atc_invocations_pending",
+		"Invocations waiting for approval, as of the last invocation-expiry run.")
+
+	JobRuns = Default.Counter("atc_job_runs_total",
+		"Background job runs by job and outcome (ok or error).", "job", "outcome")
+	JobRunDuration = Default.Histogram("atc_job_run_duration_seconds",
+		"Background job run latency by job.", DefBuckets, "job")
+	JobRunning = Default.Gauge("atc_job_running",
+		"1 while a background job's worker is running, 0 once it has stopped.", "job")
+	JobLastSuccess = Default.Gauge("atc_job_last_success_timestamp_seconds",
+		"Unix time of each background job's last successful run.", "job")
+)
+
+// DatabaseObserver records the latency of every statement in DatabaseQueryDuration
+type DatabaseObserver struct{}
+
+func (DatabaseObserver) StartQuery(ctx context.Context, operation string, query string) (context.Context, func(err error)) {
+	start := time.Now()
+	return ctx, func(err error) {
+		DatabaseQueryDuration.ObserveSince(start, operation, queryOutcome(err))
+	}
+}
+
+func queryOutcome(err error) string {
+	switch {
+	case err == nil || errors.Is(err, sql.ErrNoRows):
+		return "ok"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, database.ErrUnavailable):
+		return "unavailable"
+	}
+	return "error"
+}
+
+// RegisterDatabase observes db's statements and exposes its connection pool statistics
+func RegisterDatabase(db *database.DB) {
+	db.Observers = append(db.Observers, DatabaseObserver{})
+
+	Default.GaugeFunc("atc_db_max_open_connections", "Maximum number of open connections to the database.", func() float64 {
+		return float64(db.Stats().MaxOpenConnections)
+	})
+	Default.GaugeFunc("atc_db_open_connections", "Established connections, both in use and idle.", func() float64 {
+		return float64(db.Stats().OpenConnections)
+	})
+	Default.GaugeFunc("atc_db_in_use_connections", "Connections currently in use.", func() float64 {
+		return float64(db.Stats().InUse)
+	})
+	Default.GaugeFunc("atc_db_idle_connections", "Idle connections.", func() float64 {
+		return float64(db.Stats().Idle)
+	})
+	Default.CounterFunc("atc_db_wait_count_total", "Connections waited for because the pool was exhausted.", func() float64 {
+		return float64(db.Stats().WaitCount)
+	})
+	Default.CounterFunc("atc_db_wait_duration_seconds_total", "Time spent waiting for a connection.", func() float64 {
+		return db.Stats().WaitDuration.Seconds()
+	})
+}
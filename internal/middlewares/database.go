@@ -0,0 +1,48 @@
+package middlewares
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/runwayapp/air-traffic-control/internal/database"
+	"github.com/runwayapp/air-traffic-control/internal/logging"
+)
+
+// DatabaseErrors turns handler panics caused by the database into structured errors
+// a query that timed out is a 504, an unreachable database a 503, and nothing is written once the client has gone
+// any other panic is passed on to Recovery, so this must be registered after it
+func DatabaseErrors() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, failure := database.WithFailure(c.Request.Context())
+		c.Request = c.Request.WithContext(ctx)
+
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+
+			if errors.Is(c.Request.Context().Err(), context.Canceled) {
+				logging.FromContext(ctx).Info("client disconnected", "method", c.Request.Method, "route", c.FullPath())
+				c.Abort()
+				return
+			}
+
+			err := failure.Err()
+			switch {
+			case errors.Is(err, context.DeadlineExceeded):
+				c.AbortWithStatusJSON(http.StatusGatewayTimeout, gin.H{"error": "database query timed out", "code": "database_timeout"})
+			case errors.Is(err, database.ErrUnavailable):
+				c.Header("Retry-After", "5")
+				c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "database unavailable", "code": "database_unavailable"})
+			default:
+				panic(recovered)
+			}
+			logging.FromContext(ctx).Error("database failure", "method", c.Request.Method, "route", c.FullPath(), "error", err)
+		}()
+
+		c.Next()
+	}
+}
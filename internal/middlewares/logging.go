@@ -0,0 +1,210 @@
+package middlewares
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/runwayapp/air-traffic-control/internal/apikeys"
+	"github.com/runwayapp/air-traffic-control/internal/logging"
+	token "github.com/runwayapp/air-traffic-control/internal/utils"
+)
+
+const (
+	RequestIDHeader = "X-Request-ID"
+	// RequestIDKey is where the request id is stored on a gin.Context
+	RequestIDKey = "request_id"
+)
+
+// request ids accepted from clients, anything else is replaced so ids are safe to log and echo
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// query params whose values are replaced in access logs
+var redactedQueryParams = map[string]bool{
+	"token":         true,
+	"access_token":  true,
+	"refresh_token": true,
+}
+
+// RedactQuery replaces the values of credential query params in a request path such as /stream?token=abc
+func RedactQuery(path string) string {
+	base, query, ok := strings.Cut(path, "?")
+	if !ok {
+		return path
+	}
+
+	params := strings.Split(query, "&")
+	for i, param := range params {
+		name, _, hasValue := strings.Cut(param, "=")
+		if hasValue && redactedQueryParams[strings.ToLower(name)] {
+			params[i] = name + "=REDACTED"
+		}
+	}
+
+	return base + "?" + strings.Join(params, "&")
+}
+
+// RequestID accepts the client's X-Request-ID or generates one, echoes it in the response header and in JSON error bodies,
+// and attaches it to the request's logger, it must be registered first
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = uuid.New().String()
+		}
+
+		c.Set(RequestIDKey, id)
+		c.Header(RequestIDHeader, id)
+		c.Request = c.Request.WithContext(logging.NewContext(c.Request.Context(), logging.Default().With(RequestIDKey, id)))
+
+		writer := &errorBodyWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+
+		c.Next()
+
+		writer.flush(id)
+	}
+}
+
+// errorBodyWriter holds back JSON error bodies so the request id can be added to them
+type errorBodyWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *errorBodyWriter) holding() bool {
+	return w.body.Len() > 0 || (w.Status() >= http.StatusBadRequest && strings.HasPrefix(w.Header().Get("Content-Type"), "application/json"))
+}
+
+func (w *errorBodyWriter) Write(data []byte) (int, error) {
+	if w.holding() {
+		return w.body.Write(data)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *errorBodyWriter) WriteString(s string) (int, error) {
+	if w.holding() {
+		return w.body.WriteString(s)
+	}
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *errorBodyWriter) Size() int {
+	if w.body.Len() > 0 {
+		return w.body.Len()
+	}
+	return w.ResponseWriter.Size()
+}
+
+func (w *errorBodyWriter) Written() bool {
+	return w.body.Len() > 0 || w.ResponseWriter.Written()
+}
+
+// flush writes the held back error body with request_id added, bodies that aren't JSON objects are written unchanged
+func (w *errorBodyWriter) flush(id string) {
+	if w.body.Len() == 0 {
+		return
+	}
+
+	data := w.body.Bytes()
+	var body map[string]json.RawMessage
+	if json.Unmarshal(data, &body) == nil && body != nil {
+		if _, ok := body[RequestIDKey]; !ok {
+			body[RequestIDKey], _ = json.Marshal(id)
+			if withID, err := json.Marshal(body); err == nil {
+				data = withID
+			}
+		}
+	}
+	w.ResponseWriter.Write(data)
+}
+
+// RequestLogger writes one structured line per request, routes in quiet (such as health probes) are logged at debug level
+func RequestLogger(quiet ...string) gin.HandlerFunc {
+	quietRoutes := map[string]bool{}
+	for _, route := range quiet {
+		quietRoutes[route] = true
+	}
+
+	return func(c *gin.Context) {
+		start := time.Now()
+		path := c.Request.URL.RequestURI()
+
+		c.Next()
+
+		status := c.Writer.Status()
+		level := logging.LevelInfo
+		switch {
+		case status >= http.StatusInternalServerError:
+			level = logging.LevelError
+		case status >= http.StatusBadRequest:
+			level = logging.LevelWarn
+		case quietRoutes[c.FullPath()]:
+			level = logging.LevelDebug
+		}
+
+		fields := []interface{}{
+			"method", c.Request.Method,
+			"route", c.FullPath(),
+			"path", RedactQuery(path),
+			"status", status,
+			"latency_ms", time.Since(start),
+			"bytes", c.Writer.Size(),
+			"client_ip", c.ClientIP(),
+		}
+		if org := c.Param("org"); org != "" {
+			fields = append(fields, "org", strings.ReplaceAll(org, "/", ""))
+		}
+		if repo := c.Param("repo"); repo != "" {
+			fields = append(fields, "repo", strings.ReplaceAll(repo, "/", ""))
+		}
+		if value, ok := c.Get(token.ClaimsKey); ok {
+			fields = append(fields, "login", value.(*token.Claims).Login)
+		}
+		if value, ok := c.Get(apikeys.ContextKey); ok {
+			fields = append(fields, "api_key_id", value.(*apikeys.Key).Id)
+		}
+		if len(c.Errors) > 0 {
+			fields = append(fields, "errors", c.Errors.String())
+		}
+
+		logging.FromContext(c.Request.Context()).Log(level, "request", fields...)
+	}
+}
+
+// Recovery logs panics with their stack and responds with a 500, it replaces gin.Recovery
+func Recovery() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+			if recovered == http.ErrAbortHandler {
+				panic(recovered)
+			}
+
+			logging.FromContext(c.Request.Context()).Error("panic recovered",
+				"error", fmt.Sprint(recovered),
+				"method", c.Request.Method,
+				"route", c.FullPath(),
+				"stack", string(debug.Stack()),
+			)
+			if c.Writer.Written() {
+				c.Abort()
+				return
+			}
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		}()
+
+		c.Next()
+	}
+}
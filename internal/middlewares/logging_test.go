@@ -0,0 +1,55 @@
+package middlewares
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRedactQuery(t *testing.T) {
+	cases := map[string]string{
+		"/api/v1/ping":                         "/api/v1/ping",
+		"/api/v1/ping?page=2":                  "/api/v1/ping?page=2",
+		"/stream?token=abc":                    "/stream?token=REDACTED",
+		"/stream?page=2&token=abc&sort=name":   "/stream?page=2&token=REDACTED&sort=name",
+		"/stream?access_token=abc&Token=def":   "/stream?access_token=REDACTED&Token=REDACTED",
+		"/stream?refresh_token=abc&tokens=def": "/stream?refresh_token=REDACTED&tokens=def",
+	}
+	for path, expected := range cases {
+		if got := RedactQuery(path); got != expected {
+			t.Errorf("RedactQuery(%q) = %q, expected %q", path, got, expected)
+		}
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(RequestID())
+	router.GET("/missing", func(c *gin.Context) { c.JSON(http.StatusNotFound, gin.H{"error": "not found"}) })
+	router.GET("/ok", func(c *gin.Context) { c.JSON(http.StatusOK, gin.H{"message": "ok"}) })
+
+	req := httptest.NewRequest(http.MethodGet, "/missing", nil)
+	req.Header.Set(RequestIDHeader, "client-id-1")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if got := w.Header().Get(RequestIDHeader); got != "client-id-1" {
+		t.Errorf("expected the client's request id to be echoed, got %q", got)
+	}
+	if got := w.Body.String(); got != `{"error":"not found","request_id":"client-id-1"}` {
+		t.Errorf("expected the request id in the error body, got %s", got)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/ok", nil)
+	req.Header.Set(RequestIDHeader, "bad id\nwith newline")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if got := w.Header().Get(RequestIDHeader); got == "" || got == "bad id\nwith newline" {
+		t.Errorf("expected an invalid request id to be replaced, got %q", got)
+	}
+	if got := w.Body.String(); got != `{"message":"ok"}` {
+		t.Errorf("expected successful bodies to be unchanged, got %s", got)
+	}
+}
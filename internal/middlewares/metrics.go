@@ -0,0 +1,41 @@
+package middlewares
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/runwayapp/air-traffic-control/internal/apikeys"
+	"github.com/runwayapp/air-traffic-control/internal/metrics"
+	token "github.com/runwayapp/air-traffic-control/internal/utils"
+)
+
+// Metrics counts requests and records their latency by route pattern, so ids in paths don't create new series
+func Metrics() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		status := strconv.Itoa(c.Writer.Status())
+		metrics.HttpRequests.Inc(c.Request.Method, route, status)
+		metrics.HttpRequestDuration.ObserveSince(start, c.Request.Method, route, status)
+	}
+}
+
+// MetricsTokenAuth requires "Authorization: Bearer <adminToken>" (METRICS_TOKEN) for scraping metrics, an empty token rejects every request
+func MetricsTokenAuth(adminToken string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if adminToken == "" || !apikeys.Equal(token.ParseAuthorizationHeader(c.GetHeader("Authorization")), adminToken) {
+			metrics.AuthFailures.Inc("invalid_metrics_token")
+			c.String(http.StatusUnauthorized, "Unauthorized")
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
@@ -1,17 +1,30 @@
 package middlewares
 
 import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
-	"os"
+	"strings"
 
 	"github.com/gin-gonic/gin"
+	jwt "github.com/golang-jwt/jwt/v5"
+	"github.com/runwayapp/air-traffic-control/internal/apikeys"
+	"github.com/runwayapp/air-traffic-control/internal/database"
+	"github.com/runwayapp/air-traffic-control/internal/logging"
+	"github.com/runwayapp/air-traffic-control/internal/metrics"
 	token "github.com/runwayapp/air-traffic-control/internal/utils"
 )
 
-func JwtAuthMiddleware() gin.HandlerFunc {
+func JwtAuthMiddleware(revocations token.Revocations, external token.ExternalVerifier) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		err := token.TokenValid(c)
+		err := token.TokenValid(c, revocations, external)
 		if err != nil {
+			metrics.AuthFailures.Inc(tokenFailureReason(c, err))
 			c.String(http.StatusUnauthorized, "Unauthorized")
 			c.Abort()
 			return
@@ -20,17 +33,128 @@ func JwtAuthMiddleware() gin.HandlerFunc {
 	}
 }
 
-func ApiKeyAuthMiddleware() gin.HandlerFunc {
+// tokenFailureReason labels why TokenValid rejected a request in the auth failures metric
+func tokenFailureReason(c *gin.Context, err error) string {
+	switch {
+	case token.ExtractToken(c) == "":
+		return "missing_token"
+	case errors.Is(err, token.ErrRevoked):
+		return "revoked_token"
+	case errors.Is(err, jwt.ErrTokenExpired):
+		return "expired_token"
+	}
+	return "invalid_token"
+}
+
+// RequireScope rejects tokens that don't grant scope on the route's :org and :repo params
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		value, ok := c.Get(token.ClaimsKey)
+		if !ok {
+			metrics.AuthFailures.Inc("missing_token")
+			c.String(http.StatusUnauthorized, "Unauthorized")
+			c.Abort()
+			return
+		}
+
+		claims := value.(*token.Claims)
+		if missing := claims.Allows(scope, c.Param("org"), c.Param("repo")); missing != "" {
+			metrics.AuthFailures.Inc("missing_scope")
+			c.JSON(http.StatusForbidden, gin.H{"error": missing})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// ApiKeyAuthMiddleware authenticates the X-API-KEY header against the api_keys table
+// bootstrapKey (GITHUB_APP_API_KEY), if set, is still accepted as an unscoped bootstrap key for creating the first org keys
+func ApiKeyAuthMiddleware(db *database.DB, bootstrapKey string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		apiKey := c.Request.Header.Get("X-API-KEY")
 
 		if apiKey == "" {
+			metrics.AuthFailures.Inc("missing_api_key")
+			c.String(http.StatusUnauthorized, "Unauthorized")
+			c.Abort()
+			return
+		}
+
+		if bootstrapKey != "" && apikeys.Equal(apiKey, bootstrapKey) {
+			c.Set(apikeys.ContextKey, &apikeys.Key{Id: "bootstrap", Name: "GITHUB_APP_API_KEY", Scopes: apikeys.Scopes})
+			c.Next()
+			return
+		}
+
+		key, err := apikeys.Authenticate(c.Request.Context(), db, apiKey)
+		if err == apikeys.ErrInvalidKey {
+			metrics.AuthFailures.Inc("invalid_api_key")
 			c.String(http.StatusUnauthorized, "Unauthorized")
 			c.Abort()
 			return
 		}
+		if err != nil {
+			logging.FromContext(c.Request.Context()).Error("(ApiKeyAuthMiddleware) apikeys.Authenticate failed", "error", err)
+			c.String(http.StatusInternalServerError, "Internal Server Error")
+			c.Abort()
+			return
+		}
+
+		c.Set(apikeys.ContextKey, key)
+		c.Next()
+	}
+}
+
+// RequireApiKeyScope rejects API keys without scope, or that belong to a different org than the :org param
+func RequireApiKeyScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.MustGet(apikeys.ContextKey).(*apikeys.Key)
+
+		if !key.HasScope(scope) {
+			metrics.AuthFailures.Inc("api_key_scope")
+			c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("api key is missing the %s scope", scope)})
+			c.Abort()
+			return
+		}
+
+		if org := c.Param("org"); org != "" && !key.AllowsOrg(org) {
+			metrics.AuthFailures.Inc("api_key_org")
+			c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("api key does not belong to %s", org)})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// GitHubWebhookMiddleware verifies the X-Hub-Signature-256 header against secret (GITHUB_WEBHOOK_SECRET)
+func GitHubWebhookMiddleware(secret string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		signature := strings.TrimPrefix(c.Request.Header.Get("X-Hub-Signature-256"), "sha256=")
+
+		// webhooks are rejected entirely until a secret is configured
+		if secret == "" || signature == "" {
+			metrics.AuthFailures.Inc("missing_webhook_signature")
+			c.String(http.StatusUnauthorized, "Unauthorized")
+			c.Abort()
+			return
+		}
+
+		// GitHub caps webhook payloads at 25MB
+		body, err := io.ReadAll(io.LimitReader(c.Request.Body, 25<<20))
+		if err != nil {
+			c.String(http.StatusBadRequest, "Bad Request")
+			c.Abort()
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
 
-		if apiKey != os.Getenv("GITHUB_APP_API_KEY") {
+		expected, err := hex.DecodeString(signature)
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		if err != nil || !hmac.Equal(expected, mac.Sum(nil)) {
+			metrics.AuthFailures.Inc("invalid_webhook_signature")
 			c.String(http.StatusUnauthorized, "Unauthorized")
 			c.Abort()
 			return
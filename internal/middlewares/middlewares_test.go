@@ -0,0 +1,87 @@
+package middlewares
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/runwayapp/air-traffic-control/internal/apikeys"
+	"github.com/runwayapp/air-traffic-control/internal/database/dbtest"
+)
+
+func TestApiKeyAuthMiddleware(t *testing.T) {
+	id, key, hash, err := apikeys.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := &dbtest.Fake{
+		Query: func(query string, args []driver.Value) (dbtest.Rows, error) {
+			if args[0] != id {
+				return dbtest.Rows{}, nil
+			}
+			columns := []string{"id", "organization", "name", "key_hash", "scopes", "active"}
+			row := []driver.Value{id, "runwayapp", "ci", hash, `["auth"]`, true}
+			return dbtest.Rows{Columns: columns, Values: [][]driver.Value{row}}, nil
+		},
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(ApiKeyAuthMiddleware(dbtest.Open(t, fake), "bootstrap-secret"))
+	router.GET("/", func(c *gin.Context) {
+		c.String(http.StatusOK, c.MustGet(apikeys.ContextKey).(*apikeys.Key).Id)
+	})
+
+	cases := []struct {
+		key    string
+		status int
+		id     string
+	}{
+		{key: "", status: http.StatusUnauthorized},
+		{key: "atc_unknown_secret", status: http.StatusUnauthorized},
+		{key: key + "x", status: http.StatusUnauthorized},
+		{key: key, status: http.StatusOK, id: id},
+		{key: "bootstrap-secret", status: http.StatusOK, id: "bootstrap"},
+	}
+	for _, tc := range cases {
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.Header.Set("X-API-KEY", tc.key)
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+
+		if recorder.Code != tc.status {
+			t.Errorf("%q: expected %d, got %d", tc.key, tc.status, recorder.Code)
+		}
+		if tc.status == http.StatusOK && recorder.Body.String() != tc.id {
+			t.Errorf("%q: expected key %s, got %s", tc.key, tc.id, recorder.Body)
+		}
+	}
+}
+
+func TestRequireApiKeyScope(t *testing.T) {
+	cases := []struct {
+		name   string
+		key    *apikeys.Key
+		org    string
+		status int
+	}{
+		{name: "scope and org", key: &apikeys.Key{Organization: "runwayapp", Scopes: []string{apikeys.ScopeApiKeys}}, org: "runwayapp", status: http.StatusOK},
+		{name: "missing scope", key: &apikeys.Key{Organization: "runwayapp", Scopes: []string{apikeys.ScopeAuth}}, org: "runwayapp", status: http.StatusForbidden},
+		{name: "other org", key: &apikeys.Key{Organization: "runwayapp", Scopes: []string{apikeys.ScopeApiKeys}}, org: "monalisa", status: http.StatusForbidden},
+		{name: "unbound key", key: &apikeys.Key{Scopes: apikeys.Scopes}, org: "monalisa", status: http.StatusOK},
+	}
+	for _, tc := range cases {
+		gin.SetMode(gin.TestMode)
+		router := gin.New()
+		router.Use(func(c *gin.Context) { c.Set(apikeys.ContextKey, tc.key) })
+		router.GET("/orgs/:org", RequireApiKeyScope(apikeys.ScopeApiKeys), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/orgs/"+tc.org, nil))
+		if recorder.Code != tc.status {
+			t.Errorf("%s: expected %d, got %d", tc.name, tc.status, recorder.Code)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package middlewares
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/runwayapp/air-traffic-control/internal/apikeys"
+	"github.com/runwayapp/air-traffic-control/internal/logging"
+	"github.com/runwayapp/air-traffic-control/internal/ratelimit"
+	token "github.com/runwayapp/air-traffic-control/internal/utils"
+)
+
+// RateLimitKey returns the bucket a request takes from and the org whose plan sets its limit
+// a key func that rejects the request aborts the context
+type RateLimitKey func(c *gin.Context) (key string, org string)
+
+// RateLimit rejects requests with a 429 once their bucket is empty and sets the RateLimit-* headers
+// requests are let through if the store can't be reached so an outage doesn't take the API down with it
+func RateLimit(limiter *ratelimit.Limiter, keyFunc RateLimitKey) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key, org := keyFunc(c)
+		if c.IsAborted() {
+			return
+		}
+
+		result, limit, err := limiter.Take(c.Request.Context(), key, org)
+		if err != nil {
+			logging.FromContext(c.Request.Context()).Error("(RateLimit) limiter.Take failed, allowing the request", "error", err)
+			c.Next()
+			return
+		}
+
+		c.Header("RateLimit-Limit", strconv.Itoa(limit.Burst))
+		c.Header("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		c.Header("RateLimit-Reset", ceilSeconds(result.Reset))
+
+		if !result.Allowed {
+			c.Header("Retry-After", ceilSeconds(result.RetryAfter))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "rate limit exceeded"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+func ceilSeconds(duration time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(duration.Seconds())))
+}
+
+// OrgRateLimitKey limits requests per :org param when the caller's token or api key is granted that org,
+// anything else is limited per token login or api key, so a caller can't drain the bucket of an org it can't act on
+func OrgRateLimitKey(c *gin.Context) (string, string) {
+	org := c.Param("org")
+	if value, ok := c.Get(token.ClaimsKey); ok {
+		claims := value.(*token.Claims)
+		if org != "" && claims.GrantsOrg(org) {
+			return "org:" + org, org
+		}
+		tokenOrg := ""
+		if len(claims.Orgs) > 0 {
+			tokenOrg = claims.Orgs[0]
+		}
+		return "login:" + claims.Login, tokenOrg
+	}
+
+	key := c.MustGet(apikeys.ContextKey).(*apikeys.Key)
+	if org != "" && key.AllowsOrg(org) {
+		return "org:" + org, org
+	}
+	return ApiKeyRateLimitKey(c)
+}
+
+// ApiKeyRateLimitKey limits requests per api key, using the plan of the key's org
+func ApiKeyRateLimitKey(c *gin.Context) (string, string) {
+	key := c.MustGet(apikeys.ContextKey).(*apikeys.Key)
+	return "api_key:" + key.Id, key.Organization
+}
+
+// the largest token request body read before the caller is authorized
+const maxLoginBodyBytes = 64 << 10
+
+// LoginRateLimitKey limits token requests per login in the JSON body and the api key's org, using the plan of that org
+// the login is whatever the caller sent, so its bucket is kept apart from other orgs' requests for the same login
+// bodies over maxLoginBodyBytes are rejected with a 413
+func LoginRateLimitKey(c *gin.Context) (string, string) {
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxLoginBodyBytes))
+	var maxBytesError *http.MaxBytesError
+	if errors.As(err, &maxBytesError) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("request body must be at most %d bytes", maxLoginBodyBytes)})
+		c.Abort()
+		return "", ""
+	}
+	if err != nil {
+		return ApiKeyRateLimitKey(c)
+	}
+	// put the body back for the handler
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+	var request struct {
+		Login string `json:"login"`
+	}
+	if json.Unmarshal(body, &request) != nil || request.Login == "" {
+		return ApiKeyRateLimitKey(c)
+	}
+
+	key := c.MustGet(apikeys.ContextKey).(*apikeys.Key)
+	return "auth:" + key.Organization + ":" + request.Login, key.Organization
+}
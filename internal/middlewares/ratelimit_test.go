@@ -0,0 +1,86 @@
+package middlewares
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/runwayapp/air-traffic-control/internal/apikeys"
+	token "github.com/runwayapp/air-traffic-control/internal/utils"
+)
+
+// rateLimitContext builds a context for a request on org, authenticated by credential
+func rateLimitContext(org string, credential interface{}, body string) *gin.Context {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("POST", "/", strings.NewReader(body))
+	if org != "" {
+		c.Params = gin.Params{{Key: "org", Value: org}}
+	}
+	switch credential := credential.(type) {
+	case *token.Claims:
+		c.Set(token.ClaimsKey, credential)
+	case *apikeys.Key:
+		c.Set(apikeys.ContextKey, credential)
+	}
+	return c
+}
+
+func TestOrgRateLimitKey(t *testing.T) {
+	claims := &token.Claims{Login: "maverick", Grant: token.Grant{Orgs: []string{"runwayapp"}}}
+	orgKey := &apikeys.Key{Id: "key-1", Organization: "runwayapp"}
+
+	cases := []struct {
+		name       string
+		org        string
+		credential interface{}
+		key        string
+		planOrg    string
+	}{
+		{name: "token on its org", org: "runwayapp", credential: claims, key: "org:runwayapp", planOrg: "runwayapp"},
+		{name: "token on another org", org: "monalisa", credential: claims, key: "login:maverick", planOrg: "runwayapp"},
+		{name: "token without an org param", credential: claims, key: "login:maverick", planOrg: "runwayapp"},
+		{name: "api key on its org", org: "runwayapp", credential: orgKey, key: "org:runwayapp", planOrg: "runwayapp"},
+		{name: "api key on another org", org: "monalisa", credential: orgKey, key: "api_key:key-1", planOrg: "runwayapp"},
+		{name: "unbound api key", org: "monalisa", credential: &apikeys.Key{Id: "bootstrap"}, key: "org:monalisa", planOrg: "monalisa"},
+	}
+	for _, tc := range cases {
+		key, planOrg := OrgRateLimitKey(rateLimitContext(tc.org, tc.credential, ""))
+		if key != tc.key || planOrg != tc.planOrg {
+			t.Errorf("%s: expected %s on %q's plan, got %s on %q's", tc.name, tc.key, tc.planOrg, key, planOrg)
+		}
+	}
+}
+
+func TestLoginRateLimitKey(t *testing.T) {
+	key := &apikeys.Key{Id: "key-1", Organization: "runwayapp"}
+
+	c := rateLimitContext("", key, `{"login": "maverick"}`)
+	if bucket, org := LoginRateLimitKey(c); bucket != "auth:runwayapp:maverick" || org != "runwayapp" {
+		t.Errorf("expected the login's bucket of the key's org, got %s on %q's plan", bucket, org)
+	}
+	// the handler still reads the body
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil || string(body) != `{"login": "maverick"}` {
+		t.Errorf("expected the body to be put back, got %q", body)
+	}
+
+	if bucket, _ := LoginRateLimitKey(rateLimitContext("", key, `{}`)); bucket != "api_key:key-1" {
+		t.Errorf("expected requests without a login to be limited per api key, got %s", bucket)
+	}
+}
+
+func TestLoginRateLimitKeyBodyLimit(t *testing.T) {
+	key := &apikeys.Key{Id: "key-1", Organization: "runwayapp"}
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest("POST", "/", strings.NewReader(`{"login": "`+strings.Repeat("a", maxLoginBodyBytes)+`"}`))
+	c.Set(apikeys.ContextKey, key)
+
+	RateLimit(nil, LoginRateLimitKey)(c)
+	if !c.IsAborted() || recorder.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected an oversized body to be rejected with a 413, got %d", recorder.Code)
+	}
+}
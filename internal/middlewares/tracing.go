@@ -0,0 +1,48 @@
+package middlewares
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/runwayapp/air-traffic-control/internal/logging"
+	"github.com/runwayapp/air-traffic-control/internal/tracing"
+)
+
+// Tracing records a server span for every request, continuing the caller's trace from its traceparent header
+// it must be registered after RequestID so the trace id is added to the request's logger
+func Tracing() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		if parent, ok := tracing.ParseTraceparent(c.GetHeader(tracing.TraceparentHeader)); ok {
+			ctx = tracing.WithRemoteParent(ctx, parent)
+		}
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		ctx, span := tracing.Default().Start(ctx, c.Request.Method+" "+route, tracing.KindServer)
+		if span == nil {
+			c.Next()
+			return
+		}
+
+		span.SetAttribute("http.method", c.Request.Method)
+		span.SetAttribute("http.route", route)
+		span.SetAttribute("url.path", c.Request.URL.Path)
+		span.SetAttribute("http.request_id", c.GetString(RequestIDKey))
+		logger := logging.FromContext(ctx).With("trace_id", span.SpanContext.TraceID.String(), "span_id", span.SpanContext.SpanID.String())
+		c.Request = c.Request.WithContext(logging.NewContext(ctx, logger))
+
+		defer func() {
+			status := c.Writer.Status()
+			span.SetAttribute("http.status_code", status)
+			if status >= http.StatusInternalServerError {
+				span.RecordError(fmt.Errorf("%d %s", status, http.StatusText(status)))
+			}
+			span.Finish()
+		}()
+		c.Next()
+	}
+}
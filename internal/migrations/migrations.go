@@ -0,0 +1,146 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// files are named <version>_<name>.sql and applied in version order
+//
+//go:embed sql/*.sql
+var files embed.FS
+
+// MySQL error number for a missing table
+const errNoSuchTable = 1146
+
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// All returns every embedded migration in version order
+func All() ([]Migration, error) {
+	entries, err := files.ReadDir("sql")
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := []Migration{}
+	for _, entry := range entries {
+		prefix, name, ok := strings.Cut(strings.TrimSuffix(entry.Name(), ".sql"), "_")
+		version, err := strconv.Atoi(prefix)
+		if !ok || err != nil {
+			return nil, fmt.Errorf("migration %s must be named <version>_<name>.sql", entry.Name())
+		}
+
+		data, err := files.ReadFile(path.Join("sql", entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, Migration{Version: version, Name: name, SQL: string(data)})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Latest is the version the schema is at once every migration is applied
+func Latest() int {
+	migrations, err := All()
+	if err != nil || len(migrations) == 0 {
+		return 0
+	}
+	return migrations[len(migrations)-1].Version
+}
+
+// Current returns the highest applied version, 0 if no migrations have been applied
+func Current(ctx context.Context, db *sql.DB) (int, error) {
+	var version sql.NullInt64
+	query := `SELECT MAX(version) FROM schema_migrations`
+	err := db.QueryRowContext(ctx, query).Scan(&version)
+
+	var mysqlError *mysql.MySQLError
+	if errors.As(err, &mysqlError) && mysqlError.Number == errNoSuchTable {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return int(version.Int64), nil
+}
+
+// statements splits a migration into the statements it runs, the driver only runs one statement per Exec
+func statements(migrationSQL string) []string {
+	result := []string{}
+	for _, statement := range strings.Split(migrationSQL, ";\n") {
+		statement = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(statement), ";"))
+
+		// skip chunks that only hold comments
+		hasSQL := false
+		for _, line := range strings.Split(statement, "\n") {
+			line = strings.TrimSpace(line)
+			if line != "" && !strings.HasPrefix(line, "#") && !strings.HasPrefix(line, "--") {
+				hasSQL = true
+				break
+			}
+		}
+		if hasSQL {
+			result = append(result, statement)
+		}
+	}
+	return result
+}
+
+// Apply runs every migration newer than the current version and returns the ones it applied
+// MySQL commits DDL implicitly, so a failed migration may be partially applied and has to be fixed by hand
+func Apply(ctx context.Context, db *sql.DB) ([]Migration, error) {
+	query := `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INT NOT NULL PRIMARY KEY,
+		name VARCHAR(255) NOT NULL,
+		applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8`
+	if _, err := db.ExecContext(ctx, query); err != nil {
+		return nil, err
+	}
+
+	current, err := Current(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	migrations, err := All()
+	if err != nil {
+		return nil, err
+	}
+
+	applied := []Migration{}
+	for _, migration := range migrations {
+		if migration.Version <= current {
+			continue
+		}
+
+		for _, statement := range statements(migration.SQL) {
+			if _, err := db.ExecContext(ctx, statement); err != nil {
+				return applied, fmt.Errorf("migration %d_%s: %w", migration.Version, migration.Name, err)
+			}
+		}
+
+		query := `INSERT INTO schema_migrations (version, name) VALUES (?, ?)`
+		if _, err := db.ExecContext(ctx, query, migration.Version, migration.Name); err != nil {
+			return applied, err
+		}
+		applied = append(applied, migration)
+	}
+
+	return applied, nil
+}
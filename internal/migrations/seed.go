@@ -0,0 +1,21 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	_ "embed"
+	"fmt"
+)
+
+//go:embed seed/fixtures.sql
+var fixtures string
+
+// Seed loads the fixture organizations, repositories, commands and users into a migrated database
+func Seed(ctx context.Context, db *sql.DB) error {
+	for _, statement := range statements(fixtures) {
+		if _, err := db.ExecContext(ctx, statement); err != nil {
+			return fmt.Errorf("seed: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,25 @@
+# fixture organizations, repositories, commands and users, docker compose loads them with the seed command
+# rows that already exist are left alone so seeding can be run more than once
+
+INSERT IGNORE INTO organizations(name, plan) VALUES
+('runway', 'enterprise'),
+('runwayapp', 'enterprise'),
+('monalisa', 'free'),
+('lisamona', 'team');
+
+INSERT IGNORE INTO repositories(organization, name, trigger_prefix, case_sensitive, default_reaction, allowed_branches, ignore_closed_prs, disabled_inherited_commands) VALUES
+('runwayapp', 'test-flight', '.', FALSE, 'eyes', '[]', TRUE, '[]');
+
+INSERT IGNORE INTO commands(id, organization, repository, name, data) VALUES
+('5ecfdb3a-c229-4982-b5b0-5cc87b8a616a', 'runwayapp', 'test-flight', 'deploy command', '{"name": "deploy command", "state": "active", "description": "Deploy the application", "command": ".deploy", "approvals": {"required": 1, "approvers": ["maverick", "goose"], "timeout": "30m"}, "actions": [{"type": "reaction", "mode": "add", "reaction": "+1"}]}'),
+('8ff93daa-66dc-4398-9ad7-93a480ac8ad7', 'runwayapp', 'test-flight', 'linter', '{"name": "linter", "description": "it lints things", "command": ".lint", "state": "active", "actions": []}'),
+('33393daa-66dc-4398-9ad7-93a480ac8333', 'runwayapp', 'test-flight', 'test command', '{"name": "test command", "description": "triggers an Actions workflow and leaves a comment", "command": ".test", "state": "active", "actions": [{"type": "comment", "text": "I am starting the [.github/workflows/test.yml](https://github.com/runwayapp/test-flight/actions/workflows/test.yml) workflow via a dispatch"}, {"type": "workflow_dispatch", "path": "test.yml"}]}');
+
+INSERT IGNORE INTO organization_commands(id, organization, name, data) VALUES
+('58890287-9ff4-4ffa-b671-05ac33b9372e', 'runwayapp', 'help', '{"name": "help", "description": "a general help command", "command": ".help", "state": "active", "actions": []}'),
+('5a253c4d-ae3f-4b8d-aabd-f418c34f1d1f', 'monalisa', 'help', '{"name": "help", "description": "a general help command", "command": ".help", "state": "active", "actions": []}'),
+('e497b87c-7bc7-4565-8477-54c8f9441cd0', 'lisamona', 'help', '{"name": "help", "description": "a general help command", "command": ".help", "state": "active", "actions": []}');
+
+INSERT IGNORE INTO users(login) VALUES
+('maverick'),
+('goose');
@@ -0,0 +1,143 @@
+# 0001 baseline
+# the schema as it was when migrations were introduced, IF NOT EXISTS lets it run against databases created before then
+
+# the organizations table
+# an organization can either be a GitHub organization or a GitHub user
+CREATE TABLE IF NOT EXISTS organizations (
+    name VARCHAR(255) NOT NULL PRIMARY KEY,
+    plan VARCHAR(255) NOT NULL,
+    members JSON,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+# the repositories table
+# settings that control how comments in a repository are resolved to commands
+CREATE TABLE IF NOT EXISTS repositories (
+    organization VARCHAR(255) NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    trigger_prefix VARCHAR(16) NOT NULL DEFAULT '.',
+    case_sensitive BOOLEAN NOT NULL DEFAULT TRUE,
+    default_reaction VARCHAR(255) NOT NULL DEFAULT '',
+    allowed_branches JSON,
+    ignore_closed_prs BOOLEAN NOT NULL DEFAULT FALSE,
+    disabled_inherited_commands JSON,
+    reconcile_on_push BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    PRIMARY KEY (organization, name)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+# the commands table
+CREATE TABLE IF NOT EXISTS commands (
+    id VARCHAR(255) NOT NULL PRIMARY KEY,
+    organization VARCHAR(255) NOT NULL,
+    repository VARCHAR(255) NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    data JSON,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+# the organization_commands table
+# org commands are inherited by every repository in the org unless the repository overrides or disables them
+CREATE TABLE IF NOT EXISTS organization_commands (
+    id VARCHAR(255) NOT NULL PRIMARY KEY,
+    organization VARCHAR(255) NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    data JSON,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    INDEX organization_commands_organization (organization)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+# the invocations table
+# an invocation is a single run of a command and stays pending until it has enough approvals
+CREATE TABLE IF NOT EXISTS invocations (
+    id VARCHAR(255) NOT NULL PRIMARY KEY,
+    organization VARCHAR(255) NOT NULL,
+    repository VARCHAR(255) NOT NULL,
+    command_id VARCHAR(255) NOT NULL,
+    login VARCHAR(255) NOT NULL,
+    state VARCHAR(255) NOT NULL,
+    approvals_required INT NOT NULL DEFAULT 0,
+    approvers JSON,
+    expires_at TIMESTAMP NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    INDEX invocations_state_expires_at (state, expires_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+# the invocation_approvals table
+CREATE TABLE IF NOT EXISTS invocation_approvals (
+    invocation_id VARCHAR(255) NOT NULL,
+    login VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (invocation_id, login)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+# the api_keys table
+# keys are owned by an organization and only their SHA-256 hash is stored
+CREATE TABLE IF NOT EXISTS api_keys (
+    id VARCHAR(255) NOT NULL PRIMARY KEY,
+    organization VARCHAR(255) NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    key_hash CHAR(64) NOT NULL,
+    scopes JSON,
+    expires_at TIMESTAMP NULL,
+    last_used_at TIMESTAMP NULL,
+    revoked_at TIMESTAMP NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    INDEX api_keys_organization (organization)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+# the refresh_tokens table
+# refresh tokens are single use, each refresh adds a token to the same family
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+    id VARCHAR(255) NOT NULL PRIMARY KEY,
+    family_id VARCHAR(255) NOT NULL,
+    login VARCHAR(255) NOT NULL,
+    token_grant JSON NOT NULL,
+    token_hash CHAR(64) NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP NULL,
+    revoked_at TIMESTAMP NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    INDEX refresh_tokens_family_id (family_id),
+    INDEX refresh_tokens_login (login)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+# the revoked_tokens table
+# access tokens revoked by jti, rows can be deleted once the token would have expired
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+    jti VARCHAR(255) NOT NULL PRIMARY KEY,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+# the login_revocations table
+# every token issued to a login at or before revoked_before is rejected
+CREATE TABLE IF NOT EXISTS login_revocations (
+    login VARCHAR(255) NOT NULL PRIMARY KEY,
+    revoked_before TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+# the users table
+CREATE TABLE IF NOT EXISTS users (
+    login VARCHAR(255) NOT NULL PRIMARY KEY,
+    email VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+# the rate_limit_buckets table
+# token buckets shared by every instance when RATE_LIMIT_STORE is mysql, updated_at is unix milliseconds
+CREATE TABLE IF NOT EXISTS rate_limit_buckets (
+    bucket_key VARCHAR(255) NOT NULL PRIMARY KEY,
+    tokens DOUBLE NOT NULL,
+    updated_at BIGINT NOT NULL,
+    INDEX (updated_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
@@ -0,0 +1,2 @@
+# keep login revocations to the millisecond, tokens issued in the same second after a revocation are valid
+ALTER TABLE login_revocations MODIFY revoked_before TIMESTAMP(3) NOT NULL;
@@ -0,0 +1,5 @@
+# the quota_locks table
+# writes checked against an org's command quotas lock its row, so concurrent writes are counted one at a time
+CREATE TABLE IF NOT EXISTS quota_locks (
+    organization VARCHAR(255) NOT NULL PRIMARY KEY
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
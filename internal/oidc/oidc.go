@@ -0,0 +1,220 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v5"
+	"github.com/runwayapp/air-traffic-control/internal/logging"
+	token "github.com/runwayapp/air-traffic-control/internal/utils"
+)
+
+// GitHubActionsIssuer is the issuer of GitHub Actions OIDC tokens
+const GitHubActionsIssuer = "https://token.actions.githubusercontent.com"
+
+// how long fetched keys are trusted before they are fetched again
+const keysTTL = time.Hour
+
+// the minimum time between fetches triggered by an unknown kid
+const minRefetchInterval = time.Minute
+
+// Claims are the GitHub Actions specific claims of an OIDC token
+type Claims struct {
+	Repository      string `json:"repository"`
+	RepositoryOwner string `json:"repository_owner"`
+	Actor           string `json:"actor"`
+	Ref             string `json:"ref"`
+	jwt.RegisteredClaims
+}
+
+// Verifier verifies OIDC tokens against the JWKS of a single issuer
+// and maps them to tokens scoped to the repository that requested them
+type Verifier struct {
+	Issuer   string
+	JWKSURL  string
+	Audience string
+	// scopes granted to every OIDC token on its own repository
+	Scopes     []string
+	HTTPClient *http.Client
+
+	mu        sync.Mutex
+	keys      map[string]*rsa.PublicKey
+	fetchedAt time.Time
+	// when the last fetch started, successful or not, so a failing JWKS isn't fetched on every request
+	attemptedAt time.Time
+	// closed when the fetch in flight finishes, nil when there is none
+	fetching chan struct{}
+	fetchErr error
+}
+
+// NewVerifier returns a verifier for issuer, jwksURL defaults to the issuer's /.well-known/jwks
+func NewVerifier(issuer string, jwksURL string, audience string, scopes []string) *Verifier {
+	issuer = strings.TrimSuffix(issuer, "/")
+	if jwksURL == "" {
+		jwksURL = issuer + "/.well-known/jwks"
+	}
+
+	return &Verifier{
+		Issuer:     issuer,
+		JWKSURL:    jwksURL,
+		Audience:   audience,
+		Scopes:     scopes,
+		HTTPClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Handles reports whether a token was issued by the verifier's issuer, without verifying it
+func (v *Verifier) Handles(tokenString string) bool {
+	if v == nil || tokenString == "" {
+		return false
+	}
+
+	var claims jwt.RegisteredClaims
+	if _, _, err := jwt.NewParser().ParseUnverified(tokenString, &claims); err != nil {
+		return false
+	}
+	return claims.Issuer == v.Issuer
+}
+
+// Verify validates an OIDC token and returns claims restricted to the repository it was issued for
+func (v *Verifier) Verify(tokenString string) (*token.Claims, error) {
+	options := []jwt.ParserOption{jwt.WithValidMethods([]string{"RS256"}), jwt.WithIssuer(v.Issuer), jwt.WithAudience(v.Audience), jwt.WithIssuedAt()}
+
+	var claims Claims
+	if _, err := jwt.ParseWithClaims(tokenString, &claims, v.keyFunc, options...); err != nil {
+		return nil, err
+	}
+
+	owner, _, ok := strings.Cut(claims.Repository, "/")
+	if !ok || owner == "" || owner != claims.RepositoryOwner {
+		return nil, errors.New("oidc token is missing the repository and repository_owner claims")
+	}
+
+	return &token.Claims{
+		Authorized: true,
+		Login:      claims.Subject,
+		Grant: token.Grant{
+			Orgs:   []string{claims.RepositoryOwner},
+			Repos:  []string{claims.Repository},
+			Scopes: v.Scopes,
+		},
+		RegisteredClaims: claims.RegisteredClaims,
+	}, nil
+}
+
+// keyFunc looks a token's kid up in the issuer's JWKS, fetching it again if the kid is unknown
+func (v *Verifier) keyFunc(t *jwt.Token) (interface{}, error) {
+	kid, _ := t.Header["kid"].(string)
+
+	v.mu.Lock()
+	key, ok := v.keys[kid]
+	sinceFetch := time.Since(v.fetchedAt)
+	sinceAttempt := time.Since(v.attemptedAt)
+	v.mu.Unlock()
+
+	if ok && sinceFetch <= keysTTL {
+		return key, nil
+	}
+
+	if sinceAttempt > minRefetchInterval {
+		if err := v.refresh(); err != nil {
+			// an outage at the issuer shouldn't reject tokens signed with a key we already have
+			if ok {
+				logging.Default().Warn("failed to refresh oidc signing keys, using the cached key", "issuer", v.Issuer, "kid", kid, "error", err)
+				return key, nil
+			}
+			return nil, err
+		}
+		v.mu.Lock()
+		key, ok = v.keys[kid]
+		v.mu.Unlock()
+	}
+
+	if !ok {
+		return nil, fmt.Errorf("oidc signing key %q was not found", kid)
+	}
+	return key, nil
+}
+
+// refresh replaces the keys with a fresh copy of the JWKS without holding v.mu during the request,
+// callers arriving while a fetch is in flight wait for it instead of starting another
+func (v *Verifier) refresh() error {
+	v.mu.Lock()
+	if fetching := v.fetching; fetching != nil {
+		v.mu.Unlock()
+		<-fetching
+		v.mu.Lock()
+		defer v.mu.Unlock()
+		return v.fetchErr
+	}
+	fetching := make(chan struct{})
+	v.fetching = fetching
+	v.attemptedAt = time.Now()
+	v.mu.Unlock()
+
+	keys, err := v.fetchKeys()
+
+	v.mu.Lock()
+	if err == nil {
+		v.keys = keys
+		v.fetchedAt = time.Now()
+	}
+	v.fetchErr = err
+	v.fetching = nil
+	v.mu.Unlock()
+	close(fetching)
+	return err
+}
+
+// fetchKeys loads the RSA keys from the JWKS url
+func (v *Verifier) fetchKeys() (map[string]*rsa.PublicKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.JWKSURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := v.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("oidc: GET %s returned %s", v.JWKSURL, res.Status)
+	}
+
+	var jwks token.JWKS
+	if err := json.NewDecoder(res.Body).Decode(&jwks); err != nil {
+		return nil, err
+	}
+
+	keys := map[string]*rsa.PublicKey{}
+	for _, jwk := range jwks.Keys {
+		if jwk.Kty != "RSA" {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			return nil, fmt.Errorf("oidc: key %s has an invalid modulus: %w", jwk.Kid, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil {
+			return nil, fmt.Errorf("oidc: key %s has an invalid exponent: %w", jwk.Kid, err)
+		}
+		keys[jwk.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+
+	return keys, nil
+}
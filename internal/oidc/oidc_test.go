@@ -0,0 +1,237 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v5"
+	token "github.com/runwayapp/air-traffic-control/internal/utils"
+)
+
+const testAudience = "air-traffic-control"
+
+// issuer signs GitHub Actions style tokens and serves its keys as a JWKS
+type issuer struct {
+	server  *httptest.Server
+	key     *rsa.PrivateKey
+	kid     string
+	fetches int32
+	// when set, JWKS requests block until it is closed
+	block chan struct{}
+	// when set, JWKS requests fail with a 500
+	failing int32
+}
+
+func newIssuer(t *testing.T) *issuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	i := &issuer{key: key, kid: "key-1"}
+	i.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&i.fetches, 1)
+		if i.block != nil {
+			<-i.block
+		}
+		if atomic.LoadInt32(&i.failing) == 1 {
+			http.Error(w, "unavailable", http.StatusInternalServerError)
+			return
+		}
+		jwk := token.JWK{
+			Kty: "RSA",
+			Kid: i.kid,
+			Use: "sig",
+			Alg: "RS256",
+			N:   base64.RawURLEncoding.EncodeToString(key.PublicKey.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.PublicKey.E)).Bytes()),
+		}
+		json.NewEncoder(w).Encode(token.JWKS{Keys: []token.JWK{jwk}})
+	}))
+	t.Cleanup(i.server.Close)
+	return i
+}
+
+func (i *issuer) verifier() *Verifier {
+	return NewVerifier(i.server.URL, i.server.URL+"/.well-known/jwks", testAudience, []string{token.ScopeCommandsRead})
+}
+
+// sign mints a token for runwayapp/test-flight, edit adjusts the claims and returns the kid to sign with
+func (i *issuer) sign(t *testing.T, edit func(claims *Claims) string) string {
+	now := time.Now()
+	claims := &Claims{
+		Repository:      "runwayapp/test-flight",
+		RepositoryOwner: "runwayapp",
+		Actor:           "maverick",
+		Ref:             "refs/heads/main",
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    i.server.URL,
+			Subject:   "repo:runwayapp/test-flight:ref:refs/heads/main",
+			Audience:  jwt.ClaimStrings{testAudience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(5 * time.Minute)),
+		},
+	}
+	kid := i.kid
+	if edit != nil {
+		kid = edit(claims)
+	}
+
+	signed := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	signed.Header["kid"] = kid
+	tokenString, err := signed.SignedString(i.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tokenString
+}
+
+func TestVerify(t *testing.T) {
+	i := newIssuer(t)
+
+	cases := []struct {
+		name  string
+		edit  func(claims *Claims) string
+		valid bool
+	}{
+		{name: "valid", valid: true},
+		{name: "wrong issuer", edit: func(c *Claims) string { c.Issuer = "https://token.example.com"; return i.kid }},
+		{name: "wrong audience", edit: func(c *Claims) string { c.Audience = jwt.ClaimStrings{"another-service"}; return i.kid }},
+		{name: "no audience", edit: func(c *Claims) string { c.Audience = nil; return i.kid }},
+		{name: "unknown kid", edit: func(c *Claims) string { return "key-2" }},
+		{name: "owner mismatch", edit: func(c *Claims) string { c.RepositoryOwner = "monalisa"; return i.kid }},
+		{name: "missing repository", edit: func(c *Claims) string { c.Repository = ""; return i.kid }},
+		{name: "expired", edit: func(c *Claims) string { c.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute)); return i.kid }},
+	}
+	for _, tc := range cases {
+		claims, err := i.verifier().Verify(i.sign(t, tc.edit))
+		if !tc.valid {
+			if err == nil {
+				t.Errorf("%s: expected the token to be rejected, got %+v", tc.name, claims)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Fatalf("%s: %s", tc.name, err)
+		}
+		if strings.Join(claims.Orgs, ",") != "runwayapp" || strings.Join(claims.Repos, ",") != "runwayapp/test-flight" {
+			t.Errorf("%s: expected the grant to be limited to the token's repository, got %+v", tc.name, claims.Grant)
+		}
+		if strings.Join(claims.Scopes, ",") != token.ScopeCommandsRead {
+			t.Errorf("%s: expected the configured scopes, got %v", tc.name, claims.Scopes)
+		}
+	}
+}
+
+func TestHandles(t *testing.T) {
+	i := newIssuer(t)
+	v := i.verifier()
+
+	if !v.Handles(i.sign(t, nil)) {
+		t.Errorf("expected a token from the issuer to be handled")
+	}
+	other := i.sign(t, func(c *Claims) string { c.Issuer = "https://token.example.com"; return i.kid })
+	if v.Handles(other) || v.Handles("") || v.Handles("not-a-jwt") {
+		t.Errorf("expected only tokens from the issuer to be handled")
+	}
+	if (*Verifier)(nil).Handles(other) {
+		t.Errorf("expected a nil verifier to handle nothing")
+	}
+}
+
+func TestKeysAreCached(t *testing.T) {
+	i := newIssuer(t)
+	v := i.verifier()
+
+	for n := 0; n < 3; n++ {
+		if _, err := v.Verify(i.sign(t, nil)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// an unknown kid right after a fetch doesn't fetch again
+	v.Verify(i.sign(t, func(c *Claims) string { return "key-2" }))
+
+	if fetches := atomic.LoadInt32(&i.fetches); fetches != 1 {
+		t.Errorf("expected the JWKS to be fetched once, got %d", fetches)
+	}
+}
+
+// a slow JWKS fetch for an unknown kid must not hold up tokens signed with a cached key
+func TestFetchDoesNotBlockCachedKeys(t *testing.T) {
+	i := newIssuer(t)
+	v := i.verifier()
+	if _, err := v.Verify(i.sign(t, nil)); err != nil {
+		t.Fatal(err)
+	}
+	v.fetchedAt = time.Now().Add(-2 * minRefetchInterval)
+	v.attemptedAt = v.fetchedAt
+
+	i.block = make(chan struct{})
+	fetched := make(chan error)
+	go func() {
+		_, err := v.Verify(i.sign(t, func(c *Claims) string { return "key-2" }))
+		fetched <- err
+	}()
+	for atomic.LoadInt32(&i.fetches) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	verified := make(chan error)
+	go func() {
+		_, err := v.Verify(i.sign(t, nil))
+		verified <- err
+	}()
+	select {
+	case err := <-verified:
+		if err != nil {
+			t.Errorf("expected the cached key to verify, got %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("expected the cached key to verify while the JWKS is fetched")
+	}
+
+	close(i.block)
+	if err := <-fetched; err == nil {
+		t.Errorf("expected the unknown kid to be rejected after the fetch")
+	}
+}
+
+// an outage at the issuer keeps verifying tokens signed with cached keys once they are past keysTTL
+func TestCachedKeysSurviveJWKSOutage(t *testing.T) {
+	i := newIssuer(t)
+	v := i.verifier()
+	if _, err := v.Verify(i.sign(t, nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	atomic.StoreInt32(&i.failing, 1)
+	v.fetchedAt = time.Now().Add(-2 * keysTTL)
+	v.attemptedAt = v.fetchedAt
+
+	if _, err := v.Verify(i.sign(t, nil)); err != nil {
+		t.Errorf("expected the cached key to verify while the JWKS is failing, got %s", err)
+	}
+	if fetches := atomic.LoadInt32(&i.fetches); fetches != 2 {
+		t.Errorf("expected the expired keys to be fetched again, got %d fetches", fetches)
+	}
+	if _, err := v.Verify(i.sign(t, func(c *Claims) string { return "key-2" })); err == nil {
+		t.Errorf("expected an unknown kid to be rejected while the JWKS is failing")
+	}
+
+	// a failed fetch is retried after minRefetchInterval, not on every request
+	if _, err := v.Verify(i.sign(t, nil)); err != nil {
+		t.Errorf("expected the cached key to keep verifying, got %s", err)
+	}
+	if fetches := atomic.LoadInt32(&i.fetches); fetches != 2 {
+		t.Errorf("expected no fetch right after a failed one, got %d fetches", fetches)
+	}
+}
@@ -0,0 +1,126 @@
+package plans
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/runwayapp/air-traffic-control/internal/database"
+)
+
+// plans an organization can be on
+const (
+	Enterprise = "enterprise"
+	Team       = "team"
+	Free       = "free"
+)
+
+// Default is the plan of organizations that are not in the organizations table
+const Default = Free
+
+// CacheTTL is how long an organization's plan is cached before it is read again
+const CacheTTL = time.Minute
+
+type entry struct {
+	plan    string
+	expires time.Time
+}
+
+// Cache looks up organization plans in MySQL and keeps them in memory for CacheTTL
+type Cache struct {
+	db *database.DB
+
+	mu      sync.Mutex
+	entries map[string]entry
+}
+
+func NewCache(db *database.DB) *Cache {
+	return &Cache{db: db, entries: map[string]entry{}}
+}
+
+// Plan returns an organization's plan, or Default if the organization does not exist
+func (c *Cache) Plan(ctx context.Context, org string) (string, error) {
+	if org == "" {
+		return Default, nil
+	}
+
+	now := time.Now()
+	c.mu.Lock()
+	cached, ok := c.entries[org]
+	c.mu.Unlock()
+	if ok && now.Before(cached.expires) {
+		return cached.plan, nil
+	}
+
+	plan := Default
+	query := `SELECT plan FROM organizations WHERE name = ?`
+	err := c.db.QueryRowContext(ctx, query, org).Scan(&plan)
+	if err != nil && err != sql.ErrNoRows {
+		return "", err
+	}
+
+	c.mu.Lock()
+	c.entries[org] = entry{plan: plan, expires: now.Add(CacheTTL)}
+	c.mu.Unlock()
+
+	return plan, nil
+}
+
+// command action types
+const (
+	ActionReaction         = "reaction"
+	ActionComment          = "comment"
+	ActionWorkflowDispatch = "workflow_dispatch"
+	ActionHttpRequest      = "http_request"
+)
+
+// Quota is what a plan allows, zero limits and a nil Action_types are unlimited
+type Quota struct {
+	Max_commands_per_repository    int `json:"max_commands_per_repository"`
+	Max_repositories_with_commands int `json:"max_repositories_with_commands"`
+	// org commands are inherited by every repository, so they are limited separately
+	Max_org_commands int      `json:"max_org_commands"`
+	Action_types     []string `json:"action_types"`
+	Approvals        bool     `json:"approvals"`
+}
+
+// Quotas are the quotas of each plan
+var Quotas = map[string]Quota{
+	Enterprise: {
+		Approvals: true,
+	},
+	Team: {
+		Max_commands_per_repository:    50,
+		Max_repositories_with_commands: 100,
+		Max_org_commands:               25,
+		Action_types:                   []string{ActionReaction, ActionComment, ActionWorkflowDispatch, ActionHttpRequest},
+	},
+	Free: {
+		Max_commands_per_repository:    10,
+		Max_repositories_with_commands: 3,
+		Max_org_commands:               5,
+		Action_types:                   []string{ActionReaction, ActionComment, ActionWorkflowDispatch},
+	},
+}
+
+// QuotaFor returns a plan's quota, unknown plans get the quota of Default
+func QuotaFor(plan string) Quota {
+	if quota, ok := Quotas[plan]; ok {
+		return quota
+	}
+	return Quotas[Default]
+}
+
+// AllowsAction reports whether the quota permits commands with an action of type actionType
+func (q Quota) AllowsAction(actionType string) bool {
+	if q.Action_types == nil {
+		return true
+	}
+	for _, allowed := range q.Action_types {
+		if allowed == actionType {
+			return true
+		}
+	}
+	return false
+}
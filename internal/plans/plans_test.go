@@ -0,0 +1,80 @@
+package plans
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"testing"
+
+	"github.com/runwayapp/air-traffic-control/internal/database/dbtest"
+)
+
+func TestQuotaFor(t *testing.T) {
+	if QuotaFor("unknown").Max_commands_per_repository != Quotas[Default].Max_commands_per_repository {
+		t.Errorf("expected unknown plans to get the default plan's quota")
+	}
+
+	cases := []struct {
+		plan    string
+		action  string
+		allowed bool
+	}{
+		{plan: Enterprise, action: ActionHttpRequest, allowed: true},
+		{plan: Enterprise, action: "anything", allowed: true},
+		{plan: Team, action: ActionHttpRequest, allowed: true},
+		{plan: Team, action: "anything", allowed: false},
+		{plan: Free, action: ActionWorkflowDispatch, allowed: true},
+		{plan: Free, action: ActionHttpRequest, allowed: false},
+	}
+	for _, tc := range cases {
+		if allowed := QuotaFor(tc.plan).AllowsAction(tc.action); allowed != tc.allowed {
+			t.Errorf("%s plan with a %s action: expected allowed to be %t", tc.plan, tc.action, tc.allowed)
+		}
+	}
+
+	if !QuotaFor(Enterprise).Approvals || QuotaFor(Team).Approvals || QuotaFor(Free).Approvals {
+		t.Errorf("expected approvals only on the enterprise plan")
+	}
+}
+
+func TestCachePlan(t *testing.T) {
+	queries := 0
+	fake := &dbtest.Fake{
+		Query: func(query string, args []driver.Value) (dbtest.Rows, error) {
+			queries++
+			switch args[0] {
+			case "runwayapp":
+				return dbtest.Rows{Columns: []string{"plan"}, Values: [][]driver.Value{{Enterprise}}}, nil
+			case "broken":
+				return dbtest.Rows{}, errors.New("connection refused")
+			}
+			return dbtest.Rows{Columns: []string{"plan"}}, nil
+		},
+	}
+	cache := NewCache(dbtest.Open(t, fake))
+	ctx := context.Background()
+
+	cases := []struct {
+		org  string
+		plan string
+	}{
+		{org: "runwayapp", plan: Enterprise},
+		{org: "runwayapp", plan: Enterprise},
+		{org: "monalisa", plan: Default},
+		{org: "", plan: Default},
+	}
+	for _, tc := range cases {
+		plan, err := cache.Plan(ctx, tc.org)
+		if err != nil || plan != tc.plan {
+			t.Errorf("%q: expected %s, got %s, %v", tc.org, tc.plan, plan, err)
+		}
+	}
+	// runwayapp is read once, monalisa once, and no org needs no query
+	if queries != 2 {
+		t.Errorf("expected plans to be cached, got %d queries", queries)
+	}
+
+	if _, err := cache.Plan(ctx, "broken"); err == nil {
+		t.Errorf("expected a database error to be returned, not the default plan")
+	}
+}
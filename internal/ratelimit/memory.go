@@ -0,0 +1,46 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// idleBucketAge is how long a bucket goes unused before it is pruned, long enough for any plan's bucket to refill
+const idleBucketAge = time.Hour
+
+// MemoryStore keeps buckets in process, so each instance enforces its own limits
+type MemoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: map[string]*bucket{}}
+}
+
+func (s *MemoryStore) Take(ctx context.Context, key string, limit Limit, now time.Time) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), updated: now}
+		s.buckets[key] = b
+	}
+
+	return b.take(limit, now), nil
+}
+
+func (s *MemoryStore) Prune(ctx context.Context) error {
+	cutoff := time.Now().Add(-idleBucketAge)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, b := range s.buckets {
+		if b.updated.Before(cutoff) {
+			delete(s.buckets, key)
+		}
+	}
+	return nil
+}
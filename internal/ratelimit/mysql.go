@@ -0,0 +1,55 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/runwayapp/air-traffic-control/internal/database"
+)
+
+// MySQLStore keeps buckets in the rate_limit_buckets table so limits are shared by every instance
+type MySQLStore struct {
+	db *database.DB
+}
+
+func NewMySQLStore(db *database.DB) *MySQLStore {
+	return &MySQLStore{db: db}
+}
+
+func (s *MySQLStore) Take(ctx context.Context, key string, limit Limit, now time.Time) (Result, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Result{}, err
+	}
+	defer tx.Rollback()
+
+	b := bucket{tokens: float64(limit.Burst), updated: now}
+
+	// lock the bucket so concurrent requests from other instances take from it one at a time
+	var updated int64
+	query := `SELECT tokens, updated_at FROM rate_limit_buckets WHERE bucket_key = ? FOR UPDATE`
+	err = tx.QueryRowContext(ctx, query, key).Scan(&b.tokens, &updated)
+	if err != nil && err != sql.ErrNoRows {
+		return Result{}, err
+	}
+	if err == nil {
+		b.updated = time.UnixMilli(updated)
+	}
+
+	result := b.take(limit, now)
+
+	query = `INSERT INTO rate_limit_buckets (bucket_key, tokens, updated_at) VALUES (?, ?, ?)
+		ON DUPLICATE KEY UPDATE tokens = VALUES(tokens), updated_at = VALUES(updated_at)`
+	if _, err := tx.ExecContext(ctx, query, key, b.tokens, b.updated.UnixMilli()); err != nil {
+		return Result{}, err
+	}
+
+	return result, tx.Commit()
+}
+
+func (s *MySQLStore) Prune(ctx context.Context) error {
+	query := `DELETE FROM rate_limit_buckets WHERE updated_at < ?`
+	_, err := s.db.ExecContext(ctx, query, time.Now().Add(-idleBucketAge).UnixMilli())
+	return err
+}
@@ -0,0 +1,138 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/runwayapp/air-traffic-control/internal/plans"
+)
+
+// PruneInterval is how often idle buckets are removed from a store
+const PruneInterval = 10 * time.Minute
+
+// Limit is a token bucket that refills Requests tokens every minute and holds at most Burst
+type Limit struct {
+	Requests int
+	Burst    int
+}
+
+// rate is the number of tokens added per second
+func (l Limit) rate() float64 {
+	return float64(l.Requests) / 60
+}
+
+// DefaultLimits are the limits for each plan unless RATE_LIMITS overrides them
+var DefaultLimits = map[string]Limit{
+	plans.Enterprise: {Requests: 3000, Burst: 300},
+	plans.Team:       {Requests: 600, Burst: 100},
+	plans.Free:       {Requests: 60, Burst: 20},
+}
+
+// ParseLimits reads limits in the form "enterprise=3000/300,team=600/100", requests per minute then burst
+// plans that are not mentioned keep their default limit
+func ParseLimits(value string) (map[string]Limit, error) {
+	limits := map[string]Limit{}
+	for plan, limit := range DefaultLimits {
+		limits[plan] = limit
+	}
+
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		plan, spec, ok := strings.Cut(item, "=")
+		requests, burst, hasBurst := strings.Cut(spec, "/")
+		if !ok || !hasBurst {
+			return nil, fmt.Errorf("rate limit %q must be in the form plan=requests/burst", item)
+		}
+
+		limit := Limit{}
+		var err error
+		if limit.Requests, err = strconv.Atoi(requests); err != nil || limit.Requests <= 0 {
+			return nil, fmt.Errorf("rate limit %q must have a positive number of requests per minute", item)
+		}
+		if limit.Burst, err = strconv.Atoi(burst); err != nil || limit.Burst <= 0 {
+			return nil, fmt.Errorf("rate limit %q must have a positive burst", item)
+		}
+		limits[plan] = limit
+	}
+
+	return limits, nil
+}
+
+// Result describes the state of a bucket after a request took from it
+type Result struct {
+	Allowed   bool
+	Remaining int
+	// time until the bucket is full again
+	Reset time.Duration
+	// time until the next request is allowed, zero when Allowed
+	RetryAfter time.Duration
+}
+
+// Store keeps token buckets, implemented by MemoryStore and MySQLStore
+type Store interface {
+	Take(ctx context.Context, key string, limit Limit, now time.Time) (Result, error)
+	// Prune removes buckets that have been idle long enough to be full again
+	Prune(ctx context.Context) error
+}
+
+// bucket is the stored state of a single token bucket
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// take refills the bucket up to now and takes a token from it if there is one
+func (b *bucket) take(limit Limit, now time.Time) Result {
+	elapsed := now.Sub(b.updated).Seconds()
+	if elapsed > 0 {
+		b.tokens = math.Min(float64(limit.Burst), b.tokens+elapsed*limit.rate())
+		b.updated = now
+	}
+
+	result := Result{}
+	if b.tokens >= 1 {
+		b.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = seconds((1 - b.tokens) / limit.rate())
+	}
+	result.Remaining = int(math.Floor(b.tokens))
+	result.Reset = seconds((float64(limit.Burst) - b.tokens) / limit.rate())
+
+	return result
+}
+
+func seconds(value float64) time.Duration {
+	return time.Duration(value * float64(time.Second))
+}
+
+// Limiter takes requests from the bucket of a key using the limit of an organization's plan
+type Limiter struct {
+	Store  Store
+	Plans  *plans.Cache
+	Limits map[string]Limit
+}
+
+// Take takes a request from key's bucket, org decides which plan's limit applies
+func (l *Limiter) Take(ctx context.Context, key string, org string) (Result, Limit, error) {
+	plan, err := l.Plans.Plan(ctx, org)
+	if err != nil {
+		return Result{}, Limit{}, err
+	}
+
+	limit, ok := l.Limits[plan]
+	if !ok {
+		limit = l.Limits[plans.Default]
+	}
+
+	result, err := l.Store.Take(ctx, key, limit, time.Now())
+	return result, limit, err
+}
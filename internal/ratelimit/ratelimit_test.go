@@ -0,0 +1,82 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBucketTake(t *testing.T) {
+	// one token a second, holding at most 5
+	limit := Limit{Requests: 60, Burst: 5}
+	start := time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		name   string
+		tokens float64
+		// time since the bucket was last updated
+		elapsed time.Duration
+		allowed bool
+		// state after the take
+		remaining  int
+		tokensLeft float64
+		retryAfter time.Duration
+		reset      time.Duration
+	}{
+		{name: "full bucket", tokens: 5, allowed: true, remaining: 4, tokensLeft: 4, reset: time.Second},
+		{name: "last token", tokens: 1, allowed: true, remaining: 0, tokensLeft: 0, reset: 5 * time.Second},
+		{name: "empty bucket", tokens: 0, allowed: false, remaining: 0, tokensLeft: 0, retryAfter: time.Second, reset: 5 * time.Second},
+		{name: "partly refilled", tokens: 0.25, allowed: false, remaining: 0, tokensLeft: 0.25, retryAfter: 750 * time.Millisecond, reset: 4750 * time.Millisecond},
+		{name: "refills over time", tokens: 0, elapsed: 2500 * time.Millisecond, allowed: true, remaining: 1, tokensLeft: 1.5, reset: 3500 * time.Millisecond},
+		{name: "refill is capped at the burst", tokens: 2, elapsed: time.Hour, allowed: true, remaining: 4, tokensLeft: 4, reset: time.Second},
+		{name: "clock going backwards doesn't refill", tokens: 0, elapsed: -time.Minute, allowed: false, remaining: 0, tokensLeft: 0, retryAfter: time.Second, reset: 5 * time.Second},
+	}
+	for _, tc := range cases {
+		b := &bucket{tokens: tc.tokens, updated: start}
+		now := start.Add(tc.elapsed)
+		result := b.take(limit, now)
+
+		if result.Allowed != tc.allowed || result.Remaining != tc.remaining {
+			t.Errorf("%s: expected allowed %t with %d remaining, got %+v", tc.name, tc.allowed, tc.remaining, result)
+		}
+		if !near(b.tokens, tc.tokensLeft) {
+			t.Errorf("%s: expected %.2f tokens left, got %.2f", tc.name, tc.tokensLeft, b.tokens)
+		}
+		if !nearDuration(result.RetryAfter, tc.retryAfter) || !nearDuration(result.Reset, tc.reset) {
+			t.Errorf("%s: expected Retry-After %s and reset %s, got %s and %s", tc.name, tc.retryAfter, tc.reset, result.RetryAfter, result.Reset)
+		}
+		if tc.elapsed > 0 && !b.updated.Equal(now) {
+			t.Errorf("%s: expected the bucket to be updated at %s, got %s", tc.name, now, b.updated)
+		}
+	}
+}
+
+func TestBucketDrainsAtTheLimit(t *testing.T) {
+	limit := Limit{Requests: 60, Burst: 3}
+	now := time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC)
+	b := &bucket{tokens: float64(limit.Burst), updated: now}
+
+	allowed := 0
+	for i := 0; i < 10; i++ {
+		if b.take(limit, now).Allowed {
+			allowed++
+		}
+	}
+	if allowed != limit.Burst {
+		t.Errorf("expected a burst of %d, got %d", limit.Burst, allowed)
+	}
+
+	// a second later exactly one more request fits
+	now = now.Add(time.Second)
+	if !b.take(limit, now).Allowed || b.take(limit, now).Allowed {
+		t.Errorf("expected one request a second once the burst is spent")
+	}
+}
+
+func near(a, b float64) bool {
+	return a-b < 1e-9 && b-a < 1e-9
+}
+
+func nearDuration(a, b time.Duration) bool {
+	diff := a - b
+	return diff < time.Microsecond && diff > -time.Microsecond
+}
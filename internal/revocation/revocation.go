@@ -0,0 +1,135 @@
+package revocation
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/runwayapp/air-traffic-control/internal/database"
+)
+
+// RefreshInterval is how often the in-memory list is reloaded so revocations made by other instances apply
+const RefreshInterval = 30 * time.Second
+
+// List is an in-memory cache of revoked token ids and logins backed by MySQL
+type List struct {
+	db *database.DB
+
+	mu     sync.RWMutex
+	jtis   map[string]bool
+	logins map[string]time.Time
+}
+
+func New(db *database.DB) *List {
+	return &List{db: db, jtis: map[string]bool{}, logins: map[string]time.Time{}}
+}
+
+// Refresh reloads the list from the revoked_tokens and login_revocations tables
+func (l *List) Refresh(ctx context.Context) error {
+	jtis := map[string]bool{}
+	logins := map[string]time.Time{}
+
+	query := `SELECT jti FROM revoked_tokens WHERE expires_at > CURRENT_TIMESTAMP`
+	res, err := l.db.QueryContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer res.Close()
+	for res.Next() {
+		var jti string
+		if err := res.Scan(&jti); err != nil {
+			return err
+		}
+		jtis[jti] = true
+	}
+	if err := res.Err(); err != nil {
+		return err
+	}
+
+	query = `SELECT login, CAST(UNIX_TIMESTAMP(revoked_before) * 1000 AS SIGNED) FROM login_revocations`
+	loginRes, err := l.db.QueryContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer loginRes.Close()
+	for loginRes.Next() {
+		var login string
+		var revokedBefore int64
+		if err := loginRes.Scan(&login, &revokedBefore); err != nil {
+			return err
+		}
+		logins[login] = time.UnixMilli(revokedBefore)
+	}
+	if err := loginRes.Err(); err != nil {
+		return err
+	}
+
+	// expired entries are no longer needed once the cache no longer holds them
+	query = `DELETE FROM revoked_tokens WHERE expires_at <= CURRENT_TIMESTAMP`
+	if _, err := l.db.ExecContext(ctx, query); err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	l.jtis = jtis
+	l.logins = logins
+	l.mu.Unlock()
+	return nil
+}
+
+// Revoked reports whether a token with the given id, login and issue time has been revoked
+// login revocations are kept to the millisecond, like our tokens' iat, so a token issued right after one is still valid
+func (l *List) Revoked(jti string, login string, issuedAt time.Time) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if l.jtis[jti] {
+		return true
+	}
+	revokedBefore, ok := l.logins[login]
+	return ok && !issuedAt.After(revokedBefore)
+}
+
+// RevokeToken revokes a single token until it expires
+func (l *List) RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	query := `INSERT IGNORE INTO revoked_tokens (jti, expires_at) VALUES (?, FROM_UNIXTIME(?))`
+	if _, err := l.db.ExecContext(ctx, query, jti, expiresAt.Unix()); err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	l.jtis[jti] = true
+	l.mu.Unlock()
+	return nil
+}
+
+// RevokeLogin revokes every access and refresh token issued to login up to now
+func (l *List) RevokeLogin(ctx context.Context, login string) error {
+	now := time.Now().Truncate(time.Millisecond)
+
+	tx, err := l.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `INSERT INTO login_revocations (login, revoked_before) VALUES (?, FROM_UNIXTIME(? / 1000))
+		ON DUPLICATE KEY UPDATE revoked_before = VALUES(revoked_before)`
+	if _, err := tx.ExecContext(ctx, query, login, now.UnixMilli()); err != nil {
+		return err
+	}
+
+	query = `UPDATE refresh_tokens SET revoked_at = CURRENT_TIMESTAMP WHERE login = ? AND revoked_at IS NULL`
+	if _, err := tx.ExecContext(ctx, query, login); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	l.logins[login] = now
+	l.mu.Unlock()
+	return nil
+}
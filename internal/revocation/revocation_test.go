@@ -0,0 +1,78 @@
+package revocation
+
+import (
+	"context"
+	"database/sql/driver"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/runwayapp/air-traffic-control/internal/database/dbtest"
+)
+
+func TestRevokedLoginPrecision(t *testing.T) {
+	revokedBefore := time.UnixMilli(1760864400123)
+	list := &List{jtis: map[string]bool{"jti-1": true}, logins: map[string]time.Time{"maverick": revokedBefore}}
+
+	cases := []struct {
+		name     string
+		jti      string
+		login    string
+		issuedAt time.Time
+		revoked  bool
+	}{
+		{name: "revoked jti", jti: "jti-1", login: "goose", issuedAt: revokedBefore.Add(time.Hour), revoked: true},
+		{name: "issued earlier in the same second", login: "maverick", issuedAt: revokedBefore.Add(-100 * time.Millisecond), revoked: true},
+		{name: "issued in the same millisecond", login: "maverick", issuedAt: revokedBefore, revoked: true},
+		{name: "issued later in the same second", login: "maverick", issuedAt: revokedBefore.Add(time.Millisecond), revoked: false},
+		{name: "other login", login: "goose", issuedAt: revokedBefore.Add(-time.Hour), revoked: false},
+	}
+	for _, tc := range cases {
+		if revoked := list.Revoked(tc.jti, tc.login, tc.issuedAt); revoked != tc.revoked {
+			t.Errorf("%s: expected revoked to be %t", tc.name, tc.revoked)
+		}
+	}
+}
+
+func TestRevokeLogin(t *testing.T) {
+	fake := &dbtest.Fake{}
+	list := New(dbtest.Open(t, fake))
+
+	before := time.Now()
+	if err := list.RevokeLogin(context.Background(), "maverick"); err != nil {
+		t.Fatal(err)
+	}
+
+	var stored int64
+	for _, statement := range fake.Statements() {
+		if strings.HasPrefix(statement.Query, "INSERT INTO login_revocations") {
+			stored = statement.Args[1].(int64)
+		}
+	}
+	// stored and cached in milliseconds, so both agree on tokens issued right after the revocation
+	if stored < before.UnixMilli() || stored != list.logins["maverick"].UnixMilli() || !list.logins["maverick"].Equal(time.UnixMilli(stored)) {
+		t.Errorf("expected the revocation to be stored at %d, got %d", list.logins["maverick"].UnixMilli(), stored)
+	}
+	if fake.Commits() != 1 {
+		t.Errorf("expected the login and refresh token revocations to be committed together")
+	}
+}
+
+func TestRefreshReadsMilliseconds(t *testing.T) {
+	fake := &dbtest.Fake{
+		Query: func(query string, args []driver.Value) (dbtest.Rows, error) {
+			if strings.Contains(query, "FROM login_revocations") {
+				return dbtest.Rows{Columns: []string{"login", "revoked_before"}, Values: [][]driver.Value{{"maverick", int64(1760864400123)}}}, nil
+			}
+			return dbtest.Rows{Columns: []string{"jti"}, Values: [][]driver.Value{{"jti-1"}}}, nil
+		},
+	}
+	list := New(dbtest.Open(t, fake))
+	if err := list.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if !list.logins["maverick"].Equal(time.UnixMilli(1760864400123)) || !list.jtis["jti-1"] {
+		t.Errorf("unexpected list %v %v", list.logins, list.jtis)
+	}
+}
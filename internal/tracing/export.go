@@ -0,0 +1,261 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// exporters selectable with TRACING_EXPORTER
+const (
+	ExporterNone   = "none"
+	ExporterStdout = "stdout"
+	ExporterOTLP   = "otlp"
+)
+
+// how the OTLP exporter batches spans
+const (
+	batchSize     = 512
+	batchInterval = 5 * time.Second
+	queueSize     = 4096
+)
+
+// the OTLP/HTTP JSON encoding, see https://opentelemetry.io/docs/specs/otlp/#json-protobuf-encoding
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceId           string          `json:"traceId"`
+	SpanId            string          `json:"spanId"`
+	ParentSpanId      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpAttribute struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+type otlpStatus struct {
+	// 0 unset, 2 error
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+func attribute(key string, value interface{}) otlpAttribute {
+	switch v := value.(type) {
+	case bool:
+		return otlpAttribute{Key: key, Value: map[string]interface{}{"boolValue": v}}
+	case int:
+		return otlpAttribute{Key: key, Value: map[string]interface{}{"intValue": strconv.Itoa(v)}}
+	case int64:
+		return otlpAttribute{Key: key, Value: map[string]interface{}{"intValue": strconv.FormatInt(v, 10)}}
+	case float64:
+		return otlpAttribute{Key: key, Value: map[string]interface{}{"doubleValue": v}}
+	}
+	return otlpAttribute{Key: key, Value: map[string]interface{}{"stringValue": fmt.Sprint(value)}}
+}
+
+func encodeSpan(span *Span) otlpSpan {
+	span.mu.Lock()
+	defer span.mu.Unlock()
+
+	encoded := otlpSpan{
+		TraceId:           span.SpanContext.TraceID.String(),
+		SpanId:            span.SpanContext.SpanID.String(),
+		Name:              span.Name,
+		Kind:              span.Kind,
+		StartTimeUnixNano: strconv.FormatInt(span.Start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(span.End.UnixNano(), 10),
+	}
+	if span.Parent != (SpanID{}) {
+		encoded.ParentSpanId = span.Parent.String()
+	}
+	if span.Error != "" {
+		encoded.Status = otlpStatus{Code: 2, Message: span.Error}
+	}
+
+	keys := make([]string, 0, len(span.Attributes))
+	for key := range span.Attributes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		encoded.Attributes = append(encoded.Attributes, attribute(key, span.Attributes[key]))
+	}
+	return encoded
+}
+
+func encodeRequest(serviceName string, spans []otlpSpan) otlpRequest {
+	return otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: []otlpAttribute{attribute("service.name", serviceName)}},
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: serviceName}, Spans: spans}},
+	}}}
+}
+
+// StdoutExporter writes each span as a line of OTLP JSON, for local use
+type StdoutExporter struct {
+	ServiceName string
+	Out         io.Writer
+	mu          sync.Mutex
+}
+
+func (e *StdoutExporter) Export(span *Span) {
+	data, err := json.Marshal(encodeRequest(e.ServiceName, []otlpSpan{encodeSpan(span)}))
+	if err != nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.Out.Write(append(data, '\n'))
+}
+
+func (e *StdoutExporter) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+// OTLPExporter batches spans and posts them to an OTLP/HTTP collector as JSON
+// spans are dropped, rather than slowing requests down, when the collector falls behind
+type OTLPExporter struct {
+	serviceName string
+	url         string
+	headers     map[string]string
+	client      *http.Client
+	onError     func(error)
+
+	queue    chan otlpSpan
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewOTLPExporter posts to endpoint + /v1/traces, such as http://localhost:4318, with headers added to every request
+// onError is told about batches that could not be sent
+func NewOTLPExporter(serviceName string, endpoint string, headers map[string]string, onError func(error)) *OTLPExporter {
+	e := &OTLPExporter{
+		serviceName: serviceName,
+		url:         strings.TrimSuffix(endpoint, "/") + "/v1/traces",
+		headers:     headers,
+		client:      &http.Client{Timeout: 10 * time.Second},
+		onError:     onError,
+		queue:       make(chan otlpSpan, queueSize),
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	go e.run()
+	return e
+}
+
+func (e *OTLPExporter) Export(span *Span) {
+	select {
+	case e.queue <- encodeSpan(span):
+	default:
+	}
+}
+
+func (e *OTLPExporter) run() {
+	defer close(e.done)
+	ticker := time.NewTicker(batchInterval)
+	defer ticker.Stop()
+
+	batch := []otlpSpan{}
+	for {
+		select {
+		case span := <-e.queue:
+			batch = append(batch, span)
+			if len(batch) >= batchSize {
+				e.send(batch)
+				batch = []otlpSpan{}
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				e.send(batch)
+				batch = []otlpSpan{}
+			}
+		case <-e.stop:
+			for {
+				select {
+				case span := <-e.queue:
+					batch = append(batch, span)
+				default:
+					if len(batch) > 0 {
+						e.send(batch)
+					}
+					return
+				}
+			}
+		}
+	}
+}
+
+func (e *OTLPExporter) send(batch []otlpSpan) {
+	body, err := json.Marshal(encodeRequest(e.serviceName, batch))
+	if err != nil {
+		e.onError(err)
+		return
+	}
+
+	req, err := http.NewRequest(http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		e.onError(err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range e.headers {
+		req.Header.Set(name, value)
+	}
+
+	res, err := e.client.Do(req)
+	if err != nil {
+		e.onError(err)
+		return
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, res.Body)
+	if res.StatusCode >= 300 {
+		e.onError(fmt.Errorf("exporting %d spans to %s: %s", len(batch), e.url, res.Status))
+	}
+}
+
+// Shutdown sends the spans still queued, waiting until ctx is done
+func (e *OTLPExporter) Shutdown(ctx context.Context) error {
+	e.stopOnce.Do(func() { close(e.stop) })
+	select {
+	case <-e.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
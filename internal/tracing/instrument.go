@@ -0,0 +1,65 @@
+package tracing
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// DatabaseObserver records a client span for every statement, named after its SQL verb
+type DatabaseObserver struct{}
+
+func (DatabaseObserver) StartQuery(ctx context.Context, operation string, query string) (context.Context, func(err error)) {
+	statement := strings.Join(strings.Fields(query), " ")
+	name := operation
+	if verb, _, _ := strings.Cut(statement, " "); verb != "" {
+		name = strings.ToUpper(verb)
+	}
+
+	ctx, span := Default().Start(ctx, name, KindClient)
+	span.SetAttribute("db.system", "mysql")
+	span.SetAttribute("db.operation", operation)
+	span.SetAttribute("db.statement", statement)
+	return ctx, func(err error) {
+		if !errors.Is(err, sql.ErrNoRows) {
+			span.RecordError(err)
+		}
+		span.Finish()
+	}
+}
+
+// Transport records a client span for every outgoing request and propagates the trace in its traceparent header
+type Transport struct {
+	Base http.RoundTripper
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	ctx, span := Default().Start(req.Context(), "HTTP "+req.Method, KindClient)
+	span.SetAttribute("http.method", req.Method)
+	span.SetAttribute("server.address", req.URL.Host)
+	span.SetAttribute("url.path", req.URL.Path)
+
+	req = req.Clone(ctx)
+	if traceparent := Inject(ctx); traceparent != "" {
+		req.Header.Set(TraceparentHeader, traceparent)
+	}
+
+	res, err := base.RoundTrip(req)
+	if err != nil {
+		span.RecordError(err)
+	} else {
+		span.SetAttribute("http.status_code", res.StatusCode)
+		if res.StatusCode >= http.StatusInternalServerError {
+			span.RecordError(errors.New(res.Status))
+		}
+	}
+	span.Finish()
+	return res, err
+}
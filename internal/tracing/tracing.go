@@ -0,0 +1,256 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TraceparentHeader carries trace context as in https://www.w3.org/TR/trace-context/
+const TraceparentHeader = "traceparent"
+
+// span kinds, numbered as in OTLP
+const (
+	KindInternal = 1
+	KindServer   = 2
+	KindClient   = 3
+)
+
+type TraceID [16]byte
+type SpanID [8]byte
+
+func (t TraceID) String() string { return hex.EncodeToString(t[:]) }
+func (s SpanID) String() string  { return hex.EncodeToString(s[:]) }
+
+// SpanContext identifies a span and whether its trace is recorded
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+func (sc SpanContext) Valid() bool {
+	return sc.TraceID != TraceID{} && sc.SpanID != SpanID{}
+}
+
+// Traceparent formats sc as a version 00 traceparent header value
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%s-%s-%s", sc.TraceID, sc.SpanID, flags)
+}
+
+// ParseTraceparent reads a traceparent header value, ok is false if it is missing or malformed
+func ParseTraceparent(value string) (SpanContext, bool) {
+	value = strings.TrimSpace(value)
+	parts := strings.Split(value, "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return SpanContext{}, false
+	}
+	// version 00 has exactly four fields, later versions may append more
+	if parts[0] == "00" && len(parts) != 4 {
+		return SpanContext{}, false
+	}
+
+	var sc SpanContext
+	var flags [1]byte
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return SpanContext{}, false
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return SpanContext{}, false
+	}
+	if _, err := hex.Decode(flags[:], []byte(parts[3])); err != nil {
+		return SpanContext{}, false
+	}
+	if strings.ToLower(value) != value || !sc.Valid() {
+		return SpanContext{}, false
+	}
+	sc.Sampled = flags[0]&1 == 1
+	return sc, true
+}
+
+// Span is one timed operation, its methods are safe to call on a nil span, which records nothing
+type Span struct {
+	Name        string
+	Kind        int
+	SpanContext SpanContext
+	Parent      SpanID
+	Start       time.Time
+	End         time.Time
+	Attributes  map[string]interface{}
+	Error       string
+
+	tracer *Tracer
+	mu     sync.Mutex
+	ended  bool
+}
+
+// SetAttribute records a string, bool, integer or float value on the span
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Attributes[key] = value
+}
+
+// RecordError marks the span as failed, nil errors are ignored
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Error = err.Error()
+}
+
+// Finish ends the span and hands it to the exporter, later calls do nothing
+func (s *Span) Finish() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.End = time.Now()
+	s.mu.Unlock()
+
+	if s.SpanContext.Sampled {
+		s.tracer.exporter.Export(s)
+	}
+}
+
+// Exporter receives every sampled span once it has finished
+type Exporter interface {
+	Export(span *Span)
+	// Shutdown sends spans that are still buffered
+	Shutdown(ctx context.Context) error
+}
+
+// Tracer starts spans and sends them to its exporter
+type Tracer struct {
+	exporter Exporter
+	// fraction of new traces that are recorded, traces started elsewhere follow the caller's decision
+	sampleRatio float64
+}
+
+// New returns a tracer exporting to exporter, a nil exporter disables tracing
+func New(exporter Exporter, sampleRatio float64) *Tracer {
+	return &Tracer{exporter: exporter, sampleRatio: sampleRatio}
+}
+
+var defaultTracer = New(nil, 0)
+
+// Default returns the tracer set by SetDefault, which is disabled until then
+func Default() *Tracer {
+	return defaultTracer
+}
+
+func SetDefault(tracer *Tracer) {
+	defaultTracer = tracer
+}
+
+// Enabled reports whether the tracer records spans
+func (t *Tracer) Enabled() bool {
+	return t.exporter != nil
+}
+
+// Shutdown flushes the exporter
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	if !t.Enabled() {
+		return nil
+	}
+	return t.exporter.Shutdown(ctx)
+}
+
+type spanKey struct{}
+type remoteKey struct{}
+
+// SpanFromContext returns the span started by Start, or nil
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// WithRemoteParent returns a context whose next span continues the trace of an incoming traceparent
+func WithRemoteParent(ctx context.Context, parent SpanContext) context.Context {
+	return context.WithValue(ctx, remoteKey{}, parent)
+}
+
+// parent returns the span context a new span in ctx is a child of
+func parent(ctx context.Context) (SpanContext, bool) {
+	if span := SpanFromContext(ctx); span != nil {
+		return span.SpanContext, true
+	}
+	if remote, ok := ctx.Value(remoteKey{}).(SpanContext); ok {
+		return remote, true
+	}
+	return SpanContext{}, false
+}
+
+// Start begins a span as a child of the span in ctx, or of the remote parent, or as a new trace
+// it returns nil, which records nothing, when tracing is disabled
+func (t *Tracer) Start(ctx context.Context, name string, kind int) (context.Context, *Span) {
+	if !t.Enabled() {
+		return ctx, nil
+	}
+
+	span := &Span{Name: name, Kind: kind, Start: time.Now(), Attributes: map[string]interface{}{}, tracer: t}
+	span.SpanContext.SpanID = newSpanID()
+	if p, ok := parent(ctx); ok {
+		span.SpanContext.TraceID = p.TraceID
+		span.SpanContext.Sampled = p.Sampled
+		span.Parent = p.SpanID
+	} else {
+		span.SpanContext.TraceID = newTraceID()
+		span.SpanContext.Sampled = sampled(span.SpanContext.TraceID, t.sampleRatio)
+	}
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// Inject returns the traceparent header value for calls made from ctx, empty when there is no trace
+func Inject(ctx context.Context) string {
+	if p, ok := parent(ctx); ok {
+		return p.Traceparent()
+	}
+	return ""
+}
+
+// sampled decides from the trace id, so every instance makes the same choice for a trace
+func sampled(id TraceID, ratio float64) bool {
+	if ratio >= 1 {
+		return true
+	}
+	if ratio <= 0 {
+		return false
+	}
+	return float64(binary.BigEndian.Uint64(id[8:])>>11)/(1<<53) < ratio
+}
+
+func newTraceID() TraceID {
+	var id TraceID
+	for id == (TraceID{}) {
+		rand.Read(id[:])
+	}
+	return id
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	for id == (SpanID{}) {
+		rand.Read(id[:])
+	}
+	return id
+}
@@ -0,0 +1,102 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	valid := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	sc, ok := ParseTraceparent(valid)
+	if !ok || !sc.Sampled || sc.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || sc.SpanID.String() != "00f067aa0ba902b7" {
+		t.Fatalf("ParseTraceparent(%q) = %+v, %v", valid, sc, ok)
+	}
+	if got := sc.Traceparent(); got != valid {
+		t.Errorf("Traceparent() = %q, expected %q", got, valid)
+	}
+
+	invalid := []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+	}
+	for _, value := range invalid {
+		if _, ok := ParseTraceparent(value); ok {
+			t.Errorf("expected ParseTraceparent(%q) to fail", value)
+		}
+	}
+}
+
+type recorder struct {
+	spans []*Span
+}
+
+func (r *recorder) Export(span *Span)                  { r.spans = append(r.spans, span) }
+func (r *recorder) Shutdown(ctx context.Context) error { return nil }
+
+func TestStartContinuesRemoteTrace(t *testing.T) {
+	exporter := &recorder{}
+	tracer := New(exporter, 0)
+
+	parent, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx, server := tracer.Start(WithRemoteParent(context.Background(), parent), "GET /ping", KindServer)
+	_, query := tracer.Start(ctx, "SELECT", KindClient)
+	query.Finish()
+	server.Finish()
+
+	if len(exporter.spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(exporter.spans))
+	}
+	if server.SpanContext.TraceID != parent.TraceID || server.Parent != parent.SpanID {
+		t.Errorf("expected the server span to continue the remote trace, got %+v", server.SpanContext)
+	}
+	if query.SpanContext.TraceID != parent.TraceID || query.Parent != server.SpanContext.SpanID {
+		t.Errorf("expected the query span to be a child of the server span")
+	}
+
+	// a sample ratio of 0 only affects new traces
+	_, root := tracer.Start(context.Background(), "job", KindInternal)
+	root.Finish()
+	if len(exporter.spans) != 2 {
+		t.Errorf("expected new traces not to be sampled")
+	}
+}
+
+func TestOTLPExporter(t *testing.T) {
+	requests := make(chan otlpRequest, 1)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" || r.Header.Get("Authorization") != "Bearer abc" {
+			t.Errorf("unexpected request to %s", r.URL.Path)
+		}
+		body, _ := io.ReadAll(r.Body)
+		var request otlpRequest
+		if err := json.Unmarshal(body, &request); err != nil {
+			t.Error(err)
+		}
+		requests <- request
+	}))
+	defer collector.Close()
+
+	exporter := NewOTLPExporter("atc", collector.URL, map[string]string{"Authorization": "Bearer abc"}, func(err error) { t.Error(err) })
+	tracer := New(exporter, 1)
+	_, span := tracer.Start(context.Background(), "job", KindInternal)
+	span.SetAttribute("job.name", "invocation-expiry")
+	span.Finish()
+
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	request := <-requests
+	spans := request.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 1 || spans[0].Name != "job" || spans[0].TraceId != span.SpanContext.TraceID.String() || spans[0].Attributes[0].Value["stringValue"] != "invocation-expiry" {
+		t.Errorf("unexpected spans %+v", spans)
+	}
+}
@@ -0,0 +1,43 @@
+package token
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v5"
+)
+
+// DevIdentityIssuer is the issuer of claims injected by the development identity provider
+const DevIdentityIssuer = "development"
+
+// devIdentity is injected into requests without a token, nil unless SetDevIdentity was called
+var devIdentity *Claims
+
+// SetDevIdentity makes requests without a token act as login with grant so scope checks still run in development
+// callers are responsible for only enabling it in development
+func SetDevIdentity(login string, grant Grant) (*Claims, error) {
+	if login == "" {
+		return nil, errors.New("development identity login is required")
+	}
+	if err := ValidateGrant(grant); err != nil {
+		return nil, fmt.Errorf("invalid development identity: %w", err)
+	}
+
+	devIdentity = &Claims{Authorized: true, Login: login, Grant: grant}
+	return devIdentity, nil
+}
+
+// devClaims returns a fresh copy of the development identity for a single request
+func devClaims() *Claims {
+	now := time.Now()
+	claims := *devIdentity
+	claims.RegisteredClaims = jwt.RegisteredClaims{
+		ID:        "development",
+		Issuer:    DevIdentityIssuer,
+		Subject:   claims.Login,
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+	}
+	return &claims
+}
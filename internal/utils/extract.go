@@ -0,0 +1,49 @@
+package token
+
+import (
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// QueryTokenParam is the query parameter routes that opt in may read a token from
+const QueryTokenParam = "token"
+
+// queryTokenRoutes are the route patterns, as returned by c.FullPath(), that accept ?token=
+var queryTokenRoutes = map[string]bool{}
+
+// AllowQueryToken lets routes read a token from ?token= when the request has no Authorization header
+// query params end up in access logs and proxies, so only use it for routes that can't set headers such as EventSource streams
+func AllowQueryToken(routes ...string) {
+	for _, route := range routes {
+		route = strings.TrimSpace(route)
+		if route != "" {
+			queryTokenRoutes[route] = true
+		}
+	}
+}
+
+// ExtractToken returns the request's bearer token, or an empty string if it has none
+// tokens are read from the Authorization header, and from ?token= only on routes allowed by AllowQueryToken
+func ExtractToken(c *gin.Context) string {
+	if header := c.GetHeader("Authorization"); header != "" {
+		return ParseAuthorizationHeader(header)
+	}
+	if queryTokenRoutes[c.FullPath()] {
+		return c.Query(QueryTokenParam)
+	}
+	return ""
+}
+
+// ParseAuthorizationHeader returns the token in a "Bearer <token>" header, or an empty string for any other scheme
+// the scheme is case insensitive as in RFC 7235
+func ParseAuthorizationHeader(header string) string {
+	scheme, credentials, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	if credentials == "" || strings.ContainsAny(credentials, " \t") {
+		return ""
+	}
+	return credentials
+}
@@ -0,0 +1,86 @@
+package token
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// extractFrom runs ExtractToken for a request to target served by a route registered as pattern
+func extractFrom(t *testing.T, pattern string, target string, header string) string {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	var extracted string
+	router := gin.New()
+	router.GET(pattern, func(c *gin.Context) {
+		extracted = ExtractToken(c)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	if header != "" {
+		req.Header.Set("Authorization", header)
+	}
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	return extracted
+}
+
+func TestExtractTokenFromHeader(t *testing.T) {
+	if got := extractFrom(t, "/header", "/header", "Bearer abc.def.ghi"); got != "abc.def.ghi" {
+		t.Errorf("expected the header token, got %q", got)
+	}
+}
+
+func TestExtractTokenSchemeIsCaseInsensitive(t *testing.T) {
+	if got := extractFrom(t, "/scheme", "/scheme", "bearer abc.def.ghi"); got != "abc.def.ghi" {
+		t.Errorf("expected the header token, got %q", got)
+	}
+}
+
+func TestExtractTokenRejectsMalformedHeaders(t *testing.T) {
+	headers := []string{
+		"abc.def.ghi",
+		"Basic dXNlcjpwYXNz",
+		"Token abc.def.ghi",
+		"Bearer",
+		"Bearer ",
+		"Bearer abc def",
+		"Bearer  abc",
+	}
+	for _, header := range headers {
+		if got := extractFrom(t, "/malformed", "/malformed", header); got != "" {
+			t.Errorf("expected no token for %q, got %q", header, got)
+		}
+	}
+}
+
+func TestExtractTokenIgnoresQueryByDefault(t *testing.T) {
+	if got := extractFrom(t, "/query-default", "/query-default?token=abc", ""); got != "" {
+		t.Errorf("expected ?token= to be ignored, got %q", got)
+	}
+}
+
+func TestExtractTokenFromQueryOnAllowedRoutes(t *testing.T) {
+	AllowQueryToken("/streams/:id")
+	defer delete(queryTokenRoutes, "/streams/:id")
+
+	if got := extractFrom(t, "/streams/:id", "/streams/1?token=abc", ""); got != "abc" {
+		t.Errorf("expected the query token, got %q", got)
+	}
+}
+
+func TestExtractTokenPrefersHeaderOnAllowedRoutes(t *testing.T) {
+	AllowQueryToken("/events")
+	defer delete(queryTokenRoutes, "/events")
+
+	if got := extractFrom(t, "/events", "/events?token=query", "Bearer header"); got != "header" {
+		t.Errorf("expected the header token, got %q", got)
+	}
+
+	// a malformed header does not fall back to the query param
+	if got := extractFrom(t, "/events", "/events?token=query", "Basic header"); got != "" {
+		t.Errorf("expected no token, got %q", got)
+	}
+}
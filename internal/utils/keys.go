@@ -0,0 +1,326 @@
+package token
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v5"
+)
+
+// supported signing algorithms
+const (
+	AlgHS256 = "HS256"
+	AlgRS256 = "RS256"
+	AlgEdDSA = "EdDSA"
+)
+
+// the file in a keys directory holding the kid of the key new tokens are signed with
+const activeKeyFile = "active"
+
+// size of generated RSA keys
+const rsaKeyBits = 2048
+
+// ReloadInterval is how often the keys dir is read again, so a key generated on another instance verifies here
+const ReloadInterval = 30 * time.Second
+
+// SigningKey is an asymmetric key loaded from a keys directory
+type SigningKey struct {
+	Kid     string
+	Alg     string
+	Private crypto.Signer
+}
+
+// KeySet holds the key new tokens are signed with and every key tokens may be verified with
+type KeySet struct {
+	Signing      *SigningKey
+	Verification map[string]*SigningKey
+}
+
+// JWK is a public key in JSON Web Key format
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	// RSA keys
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// Ed25519 keys
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// keys holds the loaded key set, nil means tokens are HS256 signed with options.Secret
+var keys atomic.Pointer[KeySet]
+
+// loadKeys loads the key set from options.KeysDir when options.SigningAlg is RS256 or EdDSA
+// the signing key is options.SigningKid, or the kid in the directory's "active" file
+func loadKeys() error {
+	alg := options.SigningAlg
+	if alg == "" || alg == AlgHS256 {
+		keys.Store(nil)
+		return nil
+	}
+	if alg != AlgRS256 && alg != AlgEdDSA {
+		return fmt.Errorf("signing alg must be one of %s, %s or %s", AlgHS256, AlgRS256, AlgEdDSA)
+	}
+
+	if options.KeysDir == "" {
+		return fmt.Errorf("a keys dir is required when the signing alg is %s", alg)
+	}
+
+	keySet, err := ReadKeySet(options.KeysDir, options.SigningKid)
+	if err != nil {
+		return err
+	}
+	if keySet.Signing.Alg != alg {
+		return fmt.Errorf("active key %s is %s but the signing alg is %s", keySet.Signing.Kid, keySet.Signing.Alg, alg)
+	}
+
+	keys.Store(keySet)
+	return nil
+}
+
+// ReloadKeys reads the keys dir again, picking up keys generated and activated since the last load
+// the loaded keys are kept when the dir can't be read
+func ReloadKeys(ctx context.Context) error {
+	if keys.Load() == nil {
+		return nil
+	}
+	return loadKeys()
+}
+
+// ReadKeySet loads every <kid>.pem private key in dir
+// an empty activeKid falls back to the kid stored in the directory's "active" file
+func ReadKeySet(dir string, activeKid string) (*KeySet, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+
+	keySet := &KeySet{Verification: map[string]*SigningKey{}}
+	for _, path := range paths {
+		key, err := readKeyFile(path)
+		if err != nil {
+			return nil, err
+		}
+		keySet.Verification[key.Kid] = key
+	}
+
+	if activeKid == "" {
+		active, err := os.ReadFile(filepath.Join(dir, activeKeyFile))
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("%s has no active key, run the keys rotate command", dir)
+		}
+		if err != nil {
+			return nil, err
+		}
+		activeKid = strings.TrimSpace(string(active))
+	}
+
+	signing, ok := keySet.Verification[activeKid]
+	if !ok {
+		return nil, fmt.Errorf("active key %q was not found in %s", activeKid, dir)
+	}
+	keySet.Signing = signing
+
+	return keySet, nil
+}
+
+// readKeyFile parses a PKCS #8 PEM private key named <kid>.pem
+func readKeyFile(path string) (*SigningKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s is not a PEM file", path)
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	kid := strings.TrimSuffix(filepath.Base(path), ".pem")
+	switch private := parsed.(type) {
+	case *rsa.PrivateKey:
+		return &SigningKey{Kid: kid, Alg: AlgRS256, Private: private}, nil
+	case ed25519.PrivateKey:
+		return &SigningKey{Kid: kid, Alg: AlgEdDSA, Private: private}, nil
+	default:
+		return nil, fmt.Errorf("%s holds an unsupported key type %T", path, parsed)
+	}
+}
+
+// GenerateKeyFile creates a new private key for alg in dir and returns its kid
+// when activate is set the new key also becomes the signing key, older keys stay for verification
+func GenerateKeyFile(dir string, alg string, activate bool) (string, error) {
+	var private crypto.Signer
+	var err error
+	switch alg {
+	case AlgRS256:
+		private, err = rsa.GenerateKey(rand.Reader, rsaKeyBits)
+	case AlgEdDSA:
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return "", fmt.Errorf("alg must be %s or %s", AlgRS256, AlgEdDSA)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return "", err
+	}
+
+	kidBytes := make([]byte, 8)
+	if _, err := rand.Read(kidBytes); err != nil {
+		return "", err
+	}
+	kid := hex.EncodeToString(kidBytes)
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	if err := os.WriteFile(filepath.Join(dir, kid+".pem"), data, 0o600); err != nil {
+		return "", err
+	}
+
+	if activate {
+		if err := ActivateKey(dir, kid); err != nil {
+			return "", err
+		}
+	}
+
+	return kid, nil
+}
+
+// SortedKids returns the kids in a key set in a stable order
+func (k *KeySet) SortedKids() []string {
+	kids := []string{}
+	for kid := range k.Verification {
+		kids = append(kids, kid)
+	}
+	sort.Strings(kids)
+	return kids
+}
+
+// method returns the jwt signing method for a key
+func (k *SigningKey) method() jwt.SigningMethod {
+	if k.Alg == AlgEdDSA {
+		return jwt.SigningMethodEdDSA
+	}
+	return jwt.SigningMethodRS256
+}
+
+// jwk renders a key's public half
+func (k *SigningKey) jwk() JWK {
+	switch public := k.Private.Public().(type) {
+	case *rsa.PublicKey:
+		return JWK{
+			Kty: "RSA",
+			Kid: k.Kid,
+			Use: "sig",
+			Alg: k.Alg,
+			N:   base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+		}
+	case ed25519.PublicKey:
+		return JWK{Kty: "OKP", Kid: k.Kid, Use: "sig", Alg: k.Alg, Crv: "Ed25519", X: base64.RawURLEncoding.EncodeToString(public)}
+	}
+	return JWK{}
+}
+
+// ActivateKey makes kid the key new tokens are signed with
+func ActivateKey(dir string, kid string) error {
+	if _, err := readKeyFile(filepath.Join(dir, kid+".pem")); err != nil {
+		return fmt.Errorf("key %q can't be activated: %w", kid, err)
+	}
+	return os.WriteFile(filepath.Join(dir, activeKeyFile), []byte(kid+"\n"), 0o600)
+}
+
+// PublicJWKS returns every verification key so other services can verify our tokens
+// it is empty when tokens are HS256 signed since the secret can't be published
+func PublicJWKS() JWKS {
+	jwks := JWKS{Keys: []JWK{}}
+	keySet := keys.Load()
+	if keySet == nil {
+		return jwks
+	}
+	for _, kid := range keySet.SortedKids() {
+		jwks.Keys = append(jwks.Keys, keySet.Verification[kid].jwk())
+	}
+	return jwks
+}
+
+// signToken signs claims with the active key, or with options.Secret when no key set is loaded
+func signToken(claims jwt.Claims) (string, error) {
+	keySet := keys.Load()
+	if keySet == nil {
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+		return token.SignedString([]byte(options.Secret))
+	}
+
+	token := jwt.NewWithClaims(keySet.Signing.method(), claims)
+	token.Header["kid"] = keySet.Signing.Kid
+	return token.SignedString(keySet.Signing.Private)
+}
+
+// AcceptsHS256Transition reports whether HS256 tokens are still accepted although new tokens are signed with a key set
+func AcceptsHS256Transition() bool {
+	return keys.Load() != nil && options.AcceptHS256
+}
+
+// keyFunc returns the key to verify a token with
+// tokens with a kid are verified with that key, tokens without one with options.Secret when tokens are HS256 signed,
+// or when options.AcceptHS256 keeps HS256 tokens issued before switching algorithms valid until they expire
+func keyFunc(token *jwt.Token) (interface{}, error) {
+	keySet := keys.Load()
+	if kid, ok := token.Header["kid"].(string); ok && keySet != nil {
+		key, ok := keySet.Verification[kid]
+		if !ok {
+			return nil, fmt.Errorf("Unknown signing key: %s", kid)
+		}
+		if token.Method.Alg() != key.method().Alg() {
+			return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
+		}
+		return key.Private.Public(), nil
+	}
+
+	if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+		return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
+	}
+	if keySet != nil && !options.AcceptHS256 {
+		return nil, errors.New("HS256 tokens are no longer accepted")
+	}
+	if options.Secret == "" {
+		return nil, errors.New("HS256 tokens are not accepted without a secret")
+	}
+	return []byte(options.Secret), nil
+}
@@ -0,0 +1,246 @@
+package token
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v5"
+)
+
+var testGrant = Grant{Orgs: []string{"runwayapp"}, Scopes: []string{ScopeCommandsRead}}
+
+// configureKeys signs with the active key in dir and fails the test if the keys can't be loaded
+func configureKeys(t *testing.T, alg string, dir string, secret string, acceptHS256 bool) {
+	t.Helper()
+	err := Configure(Options{Secret: secret, SigningAlg: alg, KeysDir: dir, AcceptHS256: acceptHS256, AccessTokenLifespan: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func signedKid(t *testing.T, tokenString string) string {
+	t.Helper()
+	parsed, _, err := jwt.NewParser().ParseUnverified(tokenString, &Claims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	kid, _ := parsed.Header["kid"].(string)
+	return kid
+}
+
+func TestKeyRotation(t *testing.T) {
+	for _, alg := range []string{AlgRS256, AlgEdDSA} {
+		dir := t.TempDir()
+		oldKid, err := GenerateKeyFile(dir, alg, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		configureKeys(t, alg, dir, "", false)
+		oldToken, err := GenerateToken("maverick", testGrant)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// rotating activates a new key, tokens signed with the old one stay valid
+		newKid, err := GenerateKeyFile(dir, alg, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		configureKeys(t, alg, dir, "", false)
+		newToken, err := GenerateToken("maverick", testGrant)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if signedKid(t, oldToken) != oldKid || signedKid(t, newToken) != newKid {
+			t.Errorf("%s: expected tokens to be signed with %s then %s", alg, oldKid, newKid)
+		}
+		for _, tokenString := range []string{oldToken, newToken} {
+			if _, err := ParseTokenString(tokenString); err != nil {
+				t.Errorf("%s: expected %s to verify after rotation: %s", alg, signedKid(t, tokenString), err)
+			}
+		}
+
+		// once the old key is removed its tokens no longer verify
+		if err := os.Remove(filepath.Join(dir, oldKid+".pem")); err != nil {
+			t.Fatal(err)
+		}
+		configureKeys(t, alg, dir, "", false)
+		if _, err := ParseTokenString(oldToken); err == nil {
+			t.Errorf("%s: expected a token signed with a removed key to be rejected", alg)
+		}
+		if _, err := ParseTokenString(newToken); err != nil {
+			t.Errorf("%s: expected the active key's token to verify: %s", alg, err)
+		}
+	}
+}
+
+func TestReloadKeys(t *testing.T) {
+	dir := t.TempDir()
+	oldKid, err := GenerateKeyFile(dir, AlgEdDSA, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	configureKeys(t, AlgEdDSA, dir, "", false)
+
+	// a key generated without activating is trusted after a reload but not signed with
+	newKid, err := GenerateKeyFile(dir, AlgEdDSA, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ReloadKeys(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := keys.Load().Verification[newKid]; !ok {
+		t.Errorf("expected %s to be trusted after a reload", newKid)
+	}
+	tokenString, err := GenerateToken("maverick", testGrant)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signedKid(t, tokenString) != oldKid {
+		t.Errorf("expected tokens to be signed with %s until %s is activated", oldKid, newKid)
+	}
+
+	if err := ActivateKey(dir, "unknown"); err == nil {
+		t.Errorf("expected activating an unknown kid to fail")
+	}
+	if err := ActivateKey(dir, newKid); err != nil {
+		t.Fatal(err)
+	}
+	if err := ReloadKeys(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	tokenString, err = GenerateToken("maverick", testGrant)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signedKid(t, tokenString) != newKid {
+		t.Errorf("expected tokens to be signed with %s once it's activated", newKid)
+	}
+
+	// a broken dir keeps the loaded keys
+	if err := os.Remove(filepath.Join(dir, newKid+".pem")); err != nil {
+		t.Fatal(err)
+	}
+	if err := ReloadKeys(context.Background()); err == nil {
+		t.Errorf("expected a reload without the active key to fail")
+	}
+	if _, err := ParseTokenString(tokenString); err != nil {
+		t.Errorf("expected the loaded keys to be kept after a failed reload: %s", err)
+	}
+}
+
+func TestKeyIdMustMatchAlg(t *testing.T) {
+	dir := t.TempDir()
+	kid, err := GenerateKeyFile(dir, AlgEdDSA, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	configureKeys(t, AlgEdDSA, dir, "", false)
+
+	// an HS256 token claiming a known kid must not be verified with that key
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, &Claims{Login: "maverick", Grant: testGrant})
+	forged.Header["kid"] = kid
+	tokenString, err := forged.SignedString([]byte("guess"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseTokenString(tokenString); err == nil {
+		t.Errorf("expected a token with a mismatched alg to be rejected")
+	}
+}
+
+func TestHS256Transition(t *testing.T) {
+	secret := "a-secret-of-at-least-32-characters"
+	configureKeys(t, AlgHS256, "", secret, false)
+	hsToken, err := GenerateToken("maverick", testGrant)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	if _, err := GenerateKeyFile(dir, AlgRS256, true); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name        string
+		secret      string
+		acceptHS256 bool
+		accepted    bool
+	}{
+		{name: "secret without the transition flag", secret: secret, accepted: false},
+		{name: "transition flag", secret: secret, acceptHS256: true, accepted: true},
+		{name: "transition flag without a secret", acceptHS256: true, accepted: false},
+	}
+	for _, tc := range cases {
+		configureKeys(t, AlgRS256, dir, tc.secret, tc.acceptHS256)
+		_, err := ParseTokenString(hsToken)
+		if (err == nil) != tc.accepted {
+			t.Errorf("%s: expected accepted to be %t, got %v", tc.name, tc.accepted, err)
+		}
+		if AcceptsHS256Transition() != tc.acceptHS256 {
+			t.Errorf("%s: expected the transition to be reported as %t", tc.name, tc.acceptHS256)
+		}
+	}
+}
+
+func TestPublicJWKS(t *testing.T) {
+	configureKeys(t, AlgHS256, "", "secret", false)
+	if jwks := PublicJWKS(); len(jwks.Keys) != 0 {
+		t.Errorf("expected no public keys for HS256, got %+v", jwks)
+	}
+
+	dir := t.TempDir()
+	rsaKid, err := GenerateKeyFile(dir, AlgRS256, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edKid, err := GenerateKeyFile(dir, AlgEdDSA, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	configureKeys(t, AlgEdDSA, dir, "", false)
+
+	jwks := PublicJWKS()
+	if len(jwks.Keys) != 2 {
+		t.Fatalf("expected both keys to be published, got %+v", jwks)
+	}
+	if jwks.Keys[0].Kid > jwks.Keys[1].Kid {
+		t.Errorf("expected keys in kid order")
+	}
+
+	for _, jwk := range jwks.Keys {
+		private := keys.Load().Verification[jwk.Kid].Private
+		if jwk.Use != "sig" {
+			t.Errorf("%s: expected use sig, got %q", jwk.Kid, jwk.Use)
+		}
+		switch jwk.Kid {
+		case rsaKid:
+			public := private.Public().(*rsa.PublicKey)
+			n, _ := base64.RawURLEncoding.DecodeString(jwk.N)
+			e, _ := base64.RawURLEncoding.DecodeString(jwk.E)
+			if jwk.Kty != "RSA" || jwk.Alg != AlgRS256 || new(big.Int).SetBytes(n).Cmp(public.N) != 0 || int(new(big.Int).SetBytes(e).Int64()) != public.E {
+				t.Errorf("unexpected RSA JWK %+v", jwk)
+			}
+		case edKid:
+			x, _ := base64.RawURLEncoding.DecodeString(jwk.X)
+			if jwk.Kty != "OKP" || jwk.Crv != "Ed25519" || jwk.Alg != AlgEdDSA || !ed25519.PublicKey(x).Equal(private.Public()) {
+				t.Errorf("unexpected Ed25519 JWK %+v", jwk)
+			}
+		default:
+			t.Errorf("unexpected kid %s", jwk.Kid)
+		}
+		if jwk.N != "" && jwk.X != "" {
+			t.Errorf("%s: expected only the fields of its key type", jwk.Kid)
+		}
+	}
+}
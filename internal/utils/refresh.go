@@ -0,0 +1,59 @@
+package token
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"time"
+)
+
+// every refresh token starts with this prefix so leaked tokens are easy to spot
+const refreshTokenPrefix = "atcr_"
+
+// RefreshTokenLifespan is how long refresh tokens are valid
+func RefreshTokenLifespan() time.Duration {
+	return options.RefreshTokenLifespan
+}
+
+// GenerateRefreshToken returns a new token id, the opaque token to hand to the caller, and the hash to store
+func GenerateRefreshToken() (id string, refreshToken string, hash string, err error) {
+	idBytes := make([]byte, 8)
+	if _, err := rand.Read(idBytes); err != nil {
+		return "", "", "", err
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", "", err
+	}
+
+	id = hex.EncodeToString(idBytes)
+	refreshToken = refreshTokenPrefix + id + "_" + base64.RawURLEncoding.EncodeToString(secret)
+	return id, refreshToken, HashRefreshToken(refreshToken), nil
+}
+
+// HashRefreshToken returns the hex encoded SHA-256 of a refresh token, which is what gets stored
+func HashRefreshToken(refreshToken string) string {
+	sum := sha256.Sum256([]byte(refreshToken))
+	return hex.EncodeToString(sum[:])
+}
+
+// RefreshTokenId extracts the public id from a refresh token in the form atcr_<id>_<secret>
+func RefreshTokenId(refreshToken string) (string, bool) {
+	rest := strings.TrimPrefix(refreshToken, refreshTokenPrefix)
+	if rest == refreshToken {
+		return "", false
+	}
+	id, secret, ok := strings.Cut(rest, "_")
+	if !ok || id == "" || secret == "" {
+		return "", false
+	}
+	return id, true
+}
+
+// RefreshTokenMatches compares a presented refresh token against a stored hash in constant time
+func RefreshTokenMatches(refreshToken string, hash string) bool {
+	return subtle.ConstantTimeCompare([]byte(HashRefreshToken(refreshToken)), []byte(hash)) == 1
+}
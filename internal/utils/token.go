@@ -1,79 +1,255 @@
 package token
 
 import (
+	"errors"
 	"fmt"
-	"os"
-	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	jwt "github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 )
 
-func GenerateToken(login string) (string, error) {
+// ClaimsKey is where the validated *Claims are stored on a gin.Context
+const ClaimsKey = "claims"
 
-	token_lifespan, err := strconv.Atoi(os.Getenv("TOKEN_HOUR_LIFESPAN"))
+// token scopes
+const (
+	ScopeCommandsRead  = "commands:read"
+	ScopeCommandsWrite = "commands:write"
+	ScopeLocksWrite    = "locks:write"
+	// ScopeAdmin grants every other scope
+	ScopeAdmin = "admin"
+)
+
+// Scopes lists every valid token scope
+var Scopes = []string{ScopeCommandsRead, ScopeCommandsWrite, ScopeLocksWrite, ScopeAdmin}
+
+// Grant restricts what a token can be used for
+type Grant struct {
+	// organizations the token can act on
+	Orgs []string `json:"orgs"`
+	// "org/repo" pairs the token is limited to, empty allows every repository in Orgs
+	Repos  []string `json:"repos,omitempty"`
+	Scopes []string `json:"scopes"`
+}
+
+type Claims struct {
+	Authorized bool   `json:"authorized"`
+	Login      string `json:"login"`
+	Grant
+	jwt.RegisteredClaims
+}
+
+// Options configures how tokens are signed and how long they live
+type Options struct {
+	// HS256 secret, also used to verify HS256 tokens issued before switching to RS256 or EdDSA when AcceptHS256 is set
+	Secret     string
+	SigningAlg string
+	KeysDir    string
+	SigningKid string
+	// accept HS256 tokens alongside RS256 or EdDSA ones, only meant for the transition between them
+	AcceptHS256 bool
+
+	AccessTokenLifespan  time.Duration
+	RefreshTokenLifespan time.Duration
+}
+
+var options Options
+
+func init() {
+	// iat and exp carry milliseconds so login revocations can tell apart tokens issued within the same second
+	jwt.TimePrecision = time.Millisecond
+}
+
+// Configure sets the package's options and loads the signing keys, it must be called before tokens are issued or verified
+func Configure(o Options) error {
+	options = o
+	return loadKeys()
+}
+
+// AccessTokenLifespan is how long access tokens are valid
+func AccessTokenLifespan() time.Duration {
+	return options.AccessTokenLifespan
+}
+
+func GenerateToken(login string, grant Grant) (string, error) {
+
+	token_lifespan := AccessTokenLifespan()
+
+	now := time.Now()
+	claims := Claims{
+		Authorized: true,
+		Login:      login,
+		Grant:      grant,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.New().String(),
+			ExpiresAt: jwt.NewNumericDate(now.Add(token_lifespan)),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+	}
+
+	return signToken(claims)
+}
+
+// ValidateGrant checks a grant only names known scopes and repositories inside its orgs
+func ValidateGrant(grant Grant) error {
+	if len(grant.Orgs) == 0 {
+		return errors.New("at least one org is required")
+	}
+	if len(grant.Scopes) == 0 {
+		return errors.New("at least one scope is required")
+	}
+
+	for _, scope := range grant.Scopes {
+		if !ValidScope(scope) {
+			return fmt.Errorf("unknown scope %q, valid scopes are %s", scope, strings.Join(Scopes, ", "))
+		}
+	}
+
+	for _, repo := range grant.Repos {
+		org, name, ok := strings.Cut(repo, "/")
+		if !ok || org == "" || name == "" {
+			return fmt.Errorf("repo %q must be in the form org/repo", repo)
+		}
+		if !contains(grant.Orgs, org) {
+			return fmt.Errorf("repo %q is not in one of the token's orgs", repo)
+		}
+	}
+
+	return nil
+}
+
+// Allows returns an empty string if the claims permit scope on org and repo,
+// otherwise it returns a message explaining what is missing
+// repo may be empty for routes that act on a whole org
+func (c *Claims) Allows(scope string, org string, repo string) string {
+	if !contains(c.Scopes, scope) && !contains(c.Scopes, ScopeAdmin) {
+		return fmt.Sprintf("token is missing the required scope %s", scope)
+	}
+
+	if org == "" {
+		return ""
+	}
+
+	if !contains(c.Orgs, org) {
+		return fmt.Sprintf("token is not granted access to the %s org", org)
+	}
+
+	if len(c.Repos) > 0 {
+		if repo == "" {
+			return fmt.Sprintf("token is restricted to specific repositories and can't act on the whole %s org", org)
+		}
+		if !contains(c.Repos, org+"/"+repo) {
+			return fmt.Sprintf("token is not granted access to %s/%s", org, repo)
+		}
+	}
+
+	return ""
+}
+
+// GrantsOrg reports whether the claims are granted access to org
+func (c *Claims) GrantsOrg(org string) bool {
+	return contains(c.Orgs, org)
+}
 
+// ValidScope reports whether scope is a known token scope
+func ValidScope(scope string) bool {
+	return contains(Scopes, scope)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseToken validates the request's token and returns its claims
+func ParseToken(c *gin.Context) (*Claims, error) {
+	return ParseTokenString(ExtractToken(c))
+}
+
+// ParseTokenString validates a token signed by us and returns its claims
+func ParseTokenString(tokenString string) (*Claims, error) {
+	claims := &Claims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, keyFunc)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	return claims, nil
+}
+
+// ErrRevoked is returned for tokens on the revocation list
+var ErrRevoked = errors.New("token has been revoked")
+
+// Revocations reports whether a token has been revoked, it is satisfied by *revocation.List
+type Revocations interface {
+	Revoked(jti string, login string, issuedAt time.Time) bool
+}
+
+// ExternalVerifier verifies tokens issued by someone else, it is satisfied by *oidc.Verifier
+type ExternalVerifier interface {
+	Handles(tokenString string) bool
+	Verify(tokenString string) (*Claims, error)
+}
+
+// VerifyToken validates a token and checks it has not been revoked
+// tokens the external verifier handles are verified by it instead of with our own keys
+func VerifyToken(tokenString string, revocations Revocations, external ExternalVerifier) (*Claims, error) {
+	var claims *Claims
+	var err error
+	if external != nil && external.Handles(tokenString) {
+		claims, err = external.Verify(tokenString)
+	} else {
+		claims, err = ParseTokenString(tokenString)
+	}
+	if err != nil {
+		return nil, err
 	}
 
-	claims := jwt.MapClaims{}
-	claims["authorized"] = true
-	claims["login"] = login
-	claims["exp"] = time.Now().Add(time.Hour * time.Duration(token_lifespan)).Unix()
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	if claims.IssuedAt == nil || revocations.Revoked(claims.ID, claims.Login, claims.IssuedAt.Time) {
+		return nil, ErrRevoked
+	}
 
-	return token.SignedString([]byte(os.Getenv("JWT_SECRET")))
+	return claims, nil
 }
 
-func TokenValid(c *gin.Context) error {
-	// If we're in development, skip the token check
-	if os.Getenv("ENV") == "development" && os.Getenv("SKIP_JWT_CHECK") == "true" {
+// TokenValid validates the request's token and stores its claims on the context
+func TokenValid(c *gin.Context, revocations Revocations, external ExternalVerifier) error {
+	tokenString := ExtractToken(c)
+
+	// requests without a token act as the development identity when one is configured
+	if tokenString == "" && devIdentity != nil {
+		c.Set(ClaimsKey, devClaims())
 		return nil
 	}
 
-	tokenString := ExtractToken(c)
-	_, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
-		}
-		return []byte(os.Getenv("JWT_SECRET")), nil
-	})
+	claims, err := VerifyToken(tokenString, revocations, external)
 	if err != nil {
 		return err
 	}
+
+	c.Set(ClaimsKey, claims)
 	return nil
 }
 
-func ExtractToken(c *gin.Context) string {
-	token := c.Query("token")
-	if token != "" {
-		return token
+// ExtractClaims returns the claims stored by JwtAuthMiddleware, or parses the request's token
+// on routes without it
+func ExtractClaims(c *gin.Context) (*Claims, error) {
+	if value, ok := c.Get(ClaimsKey); ok {
+		return value.(*Claims), nil
 	}
-	bearerToken := c.Request.Header.Get("Authorization")
-	if len(strings.Split(bearerToken, " ")) == 2 {
-		return strings.Split(bearerToken, " ")[1]
-	}
-	return ""
+	return ParseToken(c)
 }
 
 func ExtractTokenID(c *gin.Context) (string, error) {
-	tokenString := ExtractToken(c)
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
-		}
-		return []byte(os.Getenv("JWT_SECRET")), nil
-	})
+	claims, err := ExtractClaims(c)
 	if err != nil {
 		return "", err
 	}
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if ok && token.Valid {
-		login := claims["login"].(string)
-		return login, nil
-	}
-	return "", nil
+	return claims.Login, nil
 }
@@ -0,0 +1,28 @@
+package token
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIssuedAtMilliseconds(t *testing.T) {
+	if err := Configure(Options{Secret: "test-secret", AccessTokenLifespan: time.Hour}); err != nil {
+		t.Fatal(err)
+	}
+
+	before := time.Now().Truncate(time.Millisecond)
+	tokenString, err := GenerateToken("maverick", Grant{Orgs: []string{"runwayapp"}, Scopes: []string{ScopeCommandsRead}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := ParseTokenString(tokenString)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// whole seconds would put a token issued right after a login revocation before it,
+	// parsing the iat as a float may still lose up to a millisecond
+	if issuedAt := claims.IssuedAt.Time; before.Sub(issuedAt) > time.Millisecond {
+		t.Errorf("expected iat %s to keep milliseconds, the token was issued after %s", issuedAt, before)
+	}
+}
@@ -0,0 +1,469 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/runwayapp/air-traffic-control/internal/metrics"
+	token "github.com/runwayapp/air-traffic-control/internal/utils"
+)
+
+// invocation states
+const (
+	InvocationPending   = "pending"
+	InvocationApproved  = "approved"
+	InvocationCancelled = "cancelled"
+	InvocationExpired   = "expired"
+)
+
+// how long an invocation waits for approvals when the command does not set a timeout
+const defaultApprovalTimeout = time.Hour
+
+// how often pending invocations are checked for expiry
+const invocationExpiryInterval = time.Minute
+
+// ApprovalConfig is the optional "approvals" section of a command's data document
+type ApprovalConfig struct {
+	Required  int      `json:"required"`
+	Approvers []string `json:"approvers"`
+	Timeout   string   `json:"timeout"`
+}
+
+type Invocation struct {
+	Id                 string
+	Organization       string
+	Repository         string
+	Command_id         string
+	Login              string
+	State              string
+	Approvals_required int
+	Approvers          sql.NullString
+	Expires_at         sql.NullString
+	Created_at         string
+	Updated_at         string
+}
+
+type InvocationResponse struct {
+	Id                 string   `json:"id"`
+	Organization       string   `json:"organization"`
+	Repository         string   `json:"repository"`
+	Command_id         string   `json:"command_id"`
+	Login              string   `json:"login"`
+	State              string   `json:"state"`
+	Approvals_required int      `json:"approvals_required"`
+	Approvers          []string `json:"approvers"`
+	Approvals          []string `json:"approvals"`
+	Expires_at         *string  `json:"expires_at"`
+	Created_at         string   `json:"created_at"`
+	Updated_at         string   `json:"updated_at"`
+}
+
+type InvocationCommentRequest struct {
+	Body string `json:"body"`
+}
+
+// parseApprovalConfig reads the "approvals" section from a command's data document
+// it returns nil if the command does not require approvals
+func parseApprovalConfig(data string) (*ApprovalConfig, error) {
+	var document struct {
+		Approvals *ApprovalConfig `json:"approvals"`
+	}
+	if err := json.Unmarshal([]byte(data), &document); err != nil {
+		return nil, fmt.Errorf("data must be a valid JSON object: %w", err)
+	}
+
+	approvals := document.Approvals
+	if approvals == nil || approvals.Required == 0 {
+		return nil, nil
+	}
+
+	if approvals.Required < 0 {
+		return nil, errors.New("approvals.required must not be negative")
+	}
+
+	if len(approvals.Approvers) > 0 && approvals.Required > len(approvals.Approvers) {
+		return nil, errors.New("approvals.required must not exceed the number of approvers")
+	}
+
+	if approvals.Timeout != "" {
+		timeout, err := time.ParseDuration(approvals.Timeout)
+		if err != nil || timeout <= 0 {
+			return nil, errors.New("approvals.timeout must be a positive duration such as 30m or 2h")
+		}
+	}
+
+	return approvals, nil
+}
+
+// timeoutDuration returns the configured approval timeout, falling back to the default
+func (a *ApprovalConfig) timeoutDuration() time.Duration {
+	timeout, err := time.ParseDuration(a.Timeout)
+	if err != nil || timeout <= 0 {
+		return defaultApprovalTimeout
+	}
+	return timeout
+}
+
+// canApprove reports whether login is allowed to approve the invocation
+func (i *Invocation) canApprove(login string, approvers []string) bool {
+	// the login that started an invocation can never approve it
+	if strings.EqualFold(login, i.Login) {
+		return false
+	}
+
+	// without a list of designated approvers anyone else may approve
+	if len(approvers) == 0 {
+		return true
+	}
+
+	for _, approver := range approvers {
+		if strings.EqualFold(login, approver) {
+			return true
+		}
+	}
+	return false
+}
+
+// canCancel reports whether login is allowed to cancel the invocation, only its invoker and its approvers are
+func (i *Invocation) canCancel(login string, approvers []string) bool {
+	return strings.EqualFold(login, i.Login) || i.canApprove(login, approvers)
+}
+
+// actingLogin returns the login of the token the request was made with, writing a 401 if there is none
+// the login is never taken from the request body so callers can't act as someone else
+func actingLogin(c *gin.Context) (string, bool) {
+	claims, err := token.ExtractClaims(c)
+	if err != nil || claims.Login == "" {
+		c.String(http.StatusUnauthorized, "Unauthorized")
+		return "", false
+	}
+	return claims.Login, true
+}
+
+// invocationApprovers decodes the designated approvers stored on an invocation
+func invocationApprovers(invocation Invocation) []string {
+	approvers := []string{}
+	if invocation.Approvers.Valid {
+		err := json.Unmarshal([]byte(invocation.Approvers.String), &approvers)
+		if err != nil {
+			panic(fmt.Sprintf("(invocationApprovers) json.Unmarshal %s", err))
+		}
+	}
+	return approvers
+}
+
+// findInvocation loads an invocation scoped to an org and repo
+func findInvocation(ctx context.Context, invocationId string, org string, repo string) (Invocation, error) {
+	var invocation Invocation
+	query := `SELECT id, organization, repository, command_id, login, state, approvals_required, approvers, expires_at, created_at, updated_at
+		FROM invocations WHERE id = ? AND organization = ? AND repository = ?`
+	err := db.QueryRowContext(ctx, query, invocationId, org, repo).Scan(&invocation.Id, &invocation.Organization, &invocation.Repository, &invocation.Command_id, &invocation.Login, &invocation.State, &invocation.Approvals_required, &invocation.Approvers, &invocation.Expires_at, &invocation.Created_at, &invocation.Updated_at)
+	return invocation, err
+}
+
+// buildInvocationResponse converts an invocation row and its approvals into an API response
+func buildInvocationResponse(ctx context.Context, invocation Invocation) InvocationResponse {
+	approvers := invocationApprovers(invocation)
+
+	query := `SELECT login FROM invocation_approvals WHERE invocation_id = ? ORDER BY created_at`
+	res, err := db.QueryContext(ctx, query, invocation.Id)
+	if err != nil {
+		panic(fmt.Sprintf("(buildInvocationResponse) db.Query %s", err))
+	}
+	defer res.Close()
+
+	approvals := []string{}
+	for res.Next() {
+		var login string
+		if err := res.Scan(&login); err != nil {
+			panic(fmt.Sprintf("(buildInvocationResponse) res.Scan %s", err))
+		}
+		approvals = append(approvals, login)
+	}
+
+	var expiresAt *string
+	if invocation.Expires_at.Valid {
+		expiresAt = &invocation.Expires_at.String
+	}
+
+	return InvocationResponse{
+		Id:                 invocation.Id,
+		Organization:       invocation.Organization,
+		Repository:         invocation.Repository,
+		Command_id:         invocation.Command_id,
+		Login:              invocation.Login,
+		State:              invocation.State,
+		Approvals_required: invocation.Approvals_required,
+		Approvers:          approvers,
+		Approvals:          approvals,
+		Expires_at:         expiresAt,
+		Created_at:         invocation.Created_at,
+		Updated_at:         invocation.Updated_at,
+	}
+}
+
+func CreateInvocation(c *gin.Context) {
+	org := c.Param("org")
+	org = strings.ReplaceAll(org, "/", "")
+	repo := c.Param("repo")
+	repo = strings.ReplaceAll(repo, "/", "")
+	commandId := c.Param("commandId")
+	commandId = strings.ReplaceAll(commandId, "/", "")
+
+	login, ok := actingLogin(c)
+	if !ok {
+		return
+	}
+
+	data, err := findCommandData(c.Request.Context(), commandId, org, repo)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "command not found"})
+		return
+	}
+	if err != nil {
+		panic(fmt.Sprintf("(CreateInvocation) findCommandData %s", err))
+	}
+
+	approvals, err := parseApprovalConfig(data)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": fmt.Sprintf("command has an invalid approvals config: %s", err)})
+		return
+	}
+
+	id := uuid.New().String()
+
+	var query string
+
+	// commands without approvals are approved as soon as they are invoked
+	if approvals == nil {
+		query = `INSERT INTO invocations (id, organization, repository, command_id, login, state) VALUES (?, ?, ?, ?, ?, ?)`
+		_, err = db.ExecContext(c.Request.Context(), query, id, org, repo, commandId, login, InvocationApproved)
+	} else {
+		approvers, _ := json.Marshal(approvals.Approvers)
+		query = `INSERT INTO invocations (id, organization, repository, command_id, login, state, approvals_required, approvers, expires_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, DATE_ADD(CURRENT_TIMESTAMP, INTERVAL ? SECOND))`
+		_, err = db.ExecContext(c.Request.Context(), query, id, org, repo, commandId, login, InvocationPending, approvals.Required, string(approvers), int64(approvals.timeoutDuration().Seconds()))
+	}
+	if err != nil {
+		panic(fmt.Sprintf("(CreateInvocation) db.Exec %s", err))
+	}
+
+	invocation, err := findInvocation(c.Request.Context(), id, org, repo)
+	if err != nil {
+		panic(fmt.Sprintf("(CreateInvocation) findInvocation %s", err))
+	}
+	metrics.Invocations.Inc(invocation.State)
+
+	c.JSON(http.StatusCreated, buildInvocationResponse(c.Request.Context(), invocation))
+}
+
+func GetInvocation(c *gin.Context) {
+	org := c.Param("org")
+	org = strings.ReplaceAll(org, "/", "")
+	repo := c.Param("repo")
+	repo = strings.ReplaceAll(repo, "/", "")
+	invocationId := c.Param("invocationId")
+	invocationId = strings.ReplaceAll(invocationId, "/", "")
+
+	invocation, err := findInvocation(c.Request.Context(), invocationId, org, repo)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "invocation not found"})
+		return
+	}
+	if err != nil {
+		panic(fmt.Sprintf("(GetInvocation) findInvocation %s", err))
+	}
+
+	c.JSON(http.StatusOK, buildInvocationResponse(c.Request.Context(), invocation))
+}
+
+func ApproveInvocation(c *gin.Context) {
+	login, ok := actingLogin(c)
+	if !ok {
+		return
+	}
+
+	org := c.Param("org")
+	org = strings.ReplaceAll(org, "/", "")
+	repo := c.Param("repo")
+	repo = strings.ReplaceAll(repo, "/", "")
+	invocationId := c.Param("invocationId")
+	invocationId = strings.ReplaceAll(invocationId, "/", "")
+
+	approve(c, invocationId, org, repo, login)
+}
+
+func CancelInvocation(c *gin.Context) {
+	login, ok := actingLogin(c)
+	if !ok {
+		return
+	}
+
+	org := c.Param("org")
+	org = strings.ReplaceAll(org, "/", "")
+	repo := c.Param("repo")
+	repo = strings.ReplaceAll(repo, "/", "")
+	invocationId := c.Param("invocationId")
+	invocationId = strings.ReplaceAll(invocationId, "/", "")
+
+	cancel(c, invocationId, org, repo, login)
+}
+
+// HandleInvocationComment applies a follow-up comment such as ".approve <id>" or ".cancel <id>" using the repository's trigger prefix
+// the comment is acted on as the login of the request's token
+func HandleInvocationComment(c *gin.Context) {
+	login, ok := actingLogin(c)
+	if !ok {
+		return
+	}
+
+	var request InvocationCommentRequest
+	err := c.BindJSON(&request)
+	if err != nil {
+		panic(fmt.Sprintf("(HandleInvocationComment) c.BindJSON %s", err))
+	}
+
+	org := c.Param("org")
+	org = strings.ReplaceAll(org, "/", "")
+	repo := c.Param("repo")
+	repo = strings.ReplaceAll(repo, "/", "")
+
+	settings, err := loadRepositorySettings(c.Request.Context(), org, repo)
+	if err != nil {
+		panic(fmt.Sprintf("(HandleInvocationComment) loadRepositorySettings %s", err))
+	}
+
+	name, invocationId, ok := parseComment(settings, request.Body)
+	if !ok || invocationId == "" || strings.ContainsAny(invocationId, " \t\r\n") {
+		usage := fmt.Sprintf("comment must be in the form \"%[1]sapprove <id>\" or \"%[1]scancel <id>\"", settings.Trigger_prefix)
+		c.JSON(http.StatusBadRequest, gin.H{"error": usage})
+		return
+	}
+
+	switch {
+	case triggerMatches(settings, name, "approve"):
+		approve(c, invocationId, org, repo, login)
+	case triggerMatches(settings, name, "cancel"):
+		cancel(c, invocationId, org, repo, login)
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unknown invocation command %q", settings.Trigger_prefix+name)})
+	}
+}
+
+// approve records an approval from login and moves the invocation to approved once enough approvals exist
+func approve(c *gin.Context, invocationId string, org string, repo string, login string) {
+	invocation, ok := loadPendingInvocation(c, invocationId, org, repo)
+	if !ok {
+		return
+	}
+
+	if !invocation.canApprove(login, invocationApprovers(invocation)) {
+		c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("%s is not allowed to approve this invocation", login)})
+		return
+	}
+
+	// approving twice is a no-op
+	query := `INSERT IGNORE INTO invocation_approvals (invocation_id, login) VALUES (?, ?)`
+	_, err := db.ExecContext(c.Request.Context(), query, invocation.Id, login)
+	if err != nil {
+		panic(fmt.Sprintf("(approve) db.Exec %s", err))
+	}
+
+	query = `UPDATE invocations SET state = ? WHERE id = ? AND state = ?
+		AND (SELECT COUNT(*) FROM invocation_approvals WHERE invocation_id = ?) >= approvals_required`
+	result, err := db.ExecContext(c.Request.Context(), query, InvocationApproved, invocation.Id, InvocationPending, invocation.Id)
+	if err != nil {
+		panic(fmt.Sprintf("(approve) db.Exec %s", err))
+	}
+	if approved, _ := result.RowsAffected(); approved > 0 {
+		metrics.Invocations.Inc(InvocationApproved)
+	}
+
+	invocation, err = findInvocation(c.Request.Context(), invocation.Id, org, repo)
+	if err != nil {
+		panic(fmt.Sprintf("(approve) findInvocation %s", err))
+	}
+
+	c.JSON(http.StatusOK, buildInvocationResponse(c.Request.Context(), invocation))
+}
+
+// cancel moves a pending invocation to cancelled if login invoked it or may approve it
+func cancel(c *gin.Context, invocationId string, org string, repo string, login string) {
+	invocation, ok := loadPendingInvocation(c, invocationId, org, repo)
+	if !ok {
+		return
+	}
+
+	if !invocation.canCancel(login, invocationApprovers(invocation)) {
+		c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("%s is not allowed to cancel this invocation", login)})
+		return
+	}
+
+	query := `UPDATE invocations SET state = ? WHERE id = ? AND state = ?`
+	result, err := db.ExecContext(c.Request.Context(), query, InvocationCancelled, invocation.Id, InvocationPending)
+	if err != nil {
+		panic(fmt.Sprintf("(cancel) db.Exec %s", err))
+	}
+	if cancelled, _ := result.RowsAffected(); cancelled > 0 {
+		metrics.Invocations.Inc(InvocationCancelled)
+	}
+
+	invocation, err = findInvocation(c.Request.Context(), invocation.Id, org, repo)
+	if err != nil {
+		panic(fmt.Sprintf("(cancel) findInvocation %s", err))
+	}
+
+	c.JSON(http.StatusOK, buildInvocationResponse(c.Request.Context(), invocation))
+}
+
+// loadPendingInvocation fetches an invocation and writes an error response if it can no longer change
+func loadPendingInvocation(c *gin.Context, invocationId string, org string, repo string) (Invocation, bool) {
+	// expire first so an invocation past its deadline can't be approved before the worker runs
+	if err := expireInvocations(c.Request.Context()); err != nil {
+		panic(fmt.Sprintf("(loadPendingInvocation) expireInvocations %s", err))
+	}
+
+	invocation, err := findInvocation(c.Request.Context(), invocationId, org, repo)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "invocation not found"})
+		return invocation, false
+	}
+	if err != nil {
+		panic(fmt.Sprintf("(loadPendingInvocation) findInvocation %s", err))
+	}
+
+	if invocation.State != InvocationPending {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("invocation is %s", invocation.State)})
+		return invocation, false
+	}
+
+	return invocation, true
+}
+
+// expireInvocations marks every pending invocation past its deadline as expired, and records how many are still pending
+func expireInvocations(ctx context.Context) error {
+	query := `UPDATE invocations SET state = ? WHERE state = ? AND expires_at IS NOT NULL AND expires_at <= CURRENT_TIMESTAMP`
+	result, err := db.ExecContext(ctx, query, InvocationExpired, InvocationPending)
+	if err != nil {
+		return err
+	}
+	if expired, _ := result.RowsAffected(); expired > 0 {
+		metrics.Invocations.Add(float64(expired), InvocationExpired)
+	}
+
+	var pending int
+	query = `SELECT COUNT(*) FROM invocations WHERE state = ?`
+	if err := db.QueryRowContext(ctx, query, InvocationPending).Scan(&pending); err != nil {
+		return err
+	}
+	metrics.InvocationsPending.Set(float64(pending))
+	return nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/runwayapp/air-traffic-control/internal/database/dbtest"
+	token "github.com/runwayapp/air-traffic-control/internal/utils"
+)
+
+// fakeInvocations serves a single pending invocation from the fake database and records approvals
+type fakeInvocations struct {
+	login     string
+	approvers string
+	state     string
+	approvals []string
+}
+
+func (f *fakeInvocations) open(t *testing.T) {
+	fake := &dbtest.Fake{
+		Query: func(query string, args []driver.Value) (dbtest.Rows, error) {
+			switch {
+			case strings.Contains(query, "SELECT COUNT(*) FROM invocations"):
+				return dbtest.Rows{Columns: []string{"count"}, Values: [][]driver.Value{{int64(1)}}}, nil
+			case strings.Contains(query, "FROM invocations WHERE id = ?"):
+				if args[0] != "inv-1" {
+					return dbtest.Rows{}, nil
+				}
+				columns := []string{"id", "organization", "repository", "command_id", "login", "state", "approvals_required", "approvers", "expires_at", "created_at", "updated_at"}
+				row := []driver.Value{"inv-1", "runwayapp", "test-flight", "cmd-1", f.login, f.state, int64(1), f.approvers, nil, "2026-10-19 09:00:00", "2026-10-19 09:00:00"}
+				return dbtest.Rows{Columns: columns, Values: [][]driver.Value{row}}, nil
+			case strings.Contains(query, "FROM invocation_approvals"):
+				values := [][]driver.Value{}
+				for _, login := range f.approvals {
+					values = append(values, []driver.Value{login})
+				}
+				return dbtest.Rows{Columns: []string{"login"}, Values: values}, nil
+			}
+			t.Fatalf("unexpected query %s", query)
+			return dbtest.Rows{}, nil
+		},
+		Exec: func(query string, args []driver.Value) (driver.Result, error) {
+			switch {
+			case strings.Contains(query, "INSERT IGNORE INTO invocation_approvals"):
+				f.approvals = append(f.approvals, args[1].(string))
+			case strings.Contains(query, "UPDATE invocations SET state = ? WHERE id = ?"):
+				if args[0] == InvocationCancelled || len(f.approvals) >= 1 {
+					f.state = args[0].(string)
+					return dbtest.Result(1), nil
+				}
+			}
+			return dbtest.Result(0), nil
+		},
+	}
+	db = dbtest.Open(t, fake)
+}
+
+// serveAs sends a request to handler as if it carried a token for login
+func serveAs(login string, method string, path string, route string, handler gin.HandlerFunc, body string) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set(token.ClaimsKey, &token.Claims{Authorized: true, Login: login})
+	})
+	router.Handle(method, route, handler)
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(method, path, strings.NewReader(body))
+	request.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestApproveInvocation(t *testing.T) {
+	route := "/api/v1/:org/:repo/invocations/:invocationId/approve"
+	path := "/api/v1/runwayapp/test-flight/invocations/inv-1/approve"
+
+	cases := []struct {
+		name      string
+		login     string
+		body      string
+		status    int
+		approvals []string
+	}{
+		// the login in the body is ignored, so naming a designated approver does not help
+		{name: "impersonating an approver", login: "iceman", body: `{"login":"goose"}`, status: http.StatusForbidden},
+		// naming a different login does not get around the no self approval rule
+		{name: "self approval", login: "maverick", body: `{"login":"goose"}`, status: http.StatusForbidden},
+		{name: "designated approver", login: "goose", status: http.StatusOK, approvals: []string{"goose"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			invocations := &fakeInvocations{login: "maverick", approvers: `["goose"]`, state: InvocationPending}
+			invocations.open(t)
+
+			recorder := serveAs(tc.login, http.MethodPost, path, route, ApproveInvocation, tc.body)
+			if recorder.Code != tc.status {
+				t.Fatalf("expected %d, got %d: %s", tc.status, recorder.Code, recorder.Body)
+			}
+			if strings.Join(invocations.approvals, ",") != strings.Join(tc.approvals, ",") {
+				t.Errorf("expected approvals %v, got %v", tc.approvals, invocations.approvals)
+			}
+		})
+	}
+}
+
+func TestCancelInvocation(t *testing.T) {
+	route := "/api/v1/:org/:repo/invocations/:invocationId/cancel"
+	path := "/api/v1/runwayapp/test-flight/invocations/inv-1/cancel"
+
+	cases := map[string]int{
+		"maverick": http.StatusOK,
+		"goose":    http.StatusOK,
+		"iceman":   http.StatusForbidden,
+	}
+	for login, status := range cases {
+		invocations := &fakeInvocations{login: "maverick", approvers: `["goose"]`, state: InvocationPending}
+		invocations.open(t)
+
+		recorder := serveAs(login, http.MethodPost, path, route, CancelInvocation, `{"login":"maverick"}`)
+		if recorder.Code != status {
+			t.Errorf("%s: expected %d, got %d: %s", login, status, recorder.Code, recorder.Body)
+		}
+		cancelled := invocations.state == InvocationCancelled
+		if cancelled != (status == http.StatusOK) {
+			t.Errorf("%s: expected cancelled to be %t", login, !cancelled)
+		}
+	}
+}
+
+func TestInvocationRequiresToken(t *testing.T) {
+	invocations := &fakeInvocations{login: "maverick", state: InvocationPending}
+	invocations.open(t)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/api/v1/:org/:repo/invocations/:invocationId/approve", ApproveInvocation)
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodPost, "/api/v1/runwayapp/test-flight/invocations/inv-1/approve", strings.NewReader(`{"login":"goose"}`))
+	router.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d", recorder.Code)
+	}
+	if len(invocations.approvals) != 0 {
+		t.Errorf("expected no approvals, got %v", invocations.approvals)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+
+	"github.com/runwayapp/air-traffic-control/internal/config"
+	token "github.com/runwayapp/air-traffic-control/internal/utils"
+)
+
+const keysUsage = `usage: air-traffic-control keys <command> [flags]
+
+commands:
+  generate  create a new signing key without activating it
+  rotate    create a new signing key and make it the active key
+  activate  make a generated key the active key
+  list      list the keys in the keys directory
+
+running instances read the keys directory again every 30s, so to rotate without rejecting tokens,
+generate a key, wait until every instance has loaded it, then activate it
+
+flags:
+  -dir  directory holding the keys (default $JWT_KEYS_DIR)
+  -alg  RS256 or EdDSA (default $JWT_SIGNING_ALG, or RS256)
+  -kid  key to activate`
+
+// runKeysCommand implements the keys subcommand used to generate and rotate JWT signing keys
+// rotated out keys stay in the directory so tokens they signed verify until deleted
+// only the JWT settings are validated so keys can be managed on a machine without the database
+func runKeysCommand(args []string) error {
+	if len(args) == 0 {
+		return errors.New(keysUsage)
+	}
+
+	set := flag.NewFlagSet("keys "+args[0], flag.ContinueOnError)
+	set.Usage = func() { fmt.Fprintln(set.Output(), keysUsage) }
+	dir := set.String("dir", "", "directory holding the keys")
+	alg := set.String("alg", "", "RS256 or EdDSA")
+	kid := set.String("kid", "", "key to activate")
+	cfg, err := loadConfigWith(set, args[1:], func(cfg *config.Config) error {
+		if *dir != "" {
+			cfg.JWT.KeysDir = *dir
+		}
+		return cfg.ValidateJWT()
+	})
+	if err != nil {
+		return err
+	}
+
+	*dir = cfg.JWT.KeysDir
+	if *alg == "" {
+		*alg = cfg.JWT.SigningAlg
+		if *alg != token.AlgEdDSA {
+			*alg = token.AlgRS256
+		}
+	}
+
+	if *dir == "" {
+		return errors.New("-dir or JWT_KEYS_DIR is required")
+	}
+
+	switch args[0] {
+	case "generate", "rotate":
+		activate := args[0] == "rotate"
+		kid, err := token.GenerateKeyFile(*dir, *alg, activate)
+		if err != nil {
+			return err
+		}
+		if activate {
+			fmt.Printf("generated %s key %s and made it the active key\n", *alg, kid)
+		} else {
+			fmt.Printf("generated %s key %s\n", *alg, kid)
+		}
+		return nil
+	case "activate":
+		if *kid == "" {
+			return errors.New("-kid is required")
+		}
+		if err := token.ActivateKey(*dir, *kid); err != nil {
+			return err
+		}
+		fmt.Printf("made %s the active key\n", *kid)
+		return nil
+	case "list":
+		keySet, err := token.ReadKeySet(*dir, "")
+		if err != nil {
+			return err
+		}
+		for _, kid := range keySet.SortedKids() {
+			active := ""
+			if kid == keySet.Signing.Kid {
+				active = " (active)"
+			}
+			fmt.Printf("%s\t%s%s\n", kid, keySet.Verification[kid].Alg, active)
+		}
+		return nil
+	default:
+		return errors.New(keysUsage)
+	}
+}
@@ -1,24 +1,44 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"strings"
-
+	"syscall"
+	"time"
+
+	"github.com/runwayapp/air-traffic-control/internal/apikeys"
+	"github.com/runwayapp/air-traffic-control/internal/config"
+	"github.com/runwayapp/air-traffic-control/internal/database"
+	"github.com/runwayapp/air-traffic-control/internal/github"
+	"github.com/runwayapp/air-traffic-control/internal/health"
+	"github.com/runwayapp/air-traffic-control/internal/jobs"
+	"github.com/runwayapp/air-traffic-control/internal/logging"
+	"github.com/runwayapp/air-traffic-control/internal/metrics"
 	"github.com/runwayapp/air-traffic-control/internal/middlewares"
+	"github.com/runwayapp/air-traffic-control/internal/oidc"
+	"github.com/runwayapp/air-traffic-control/internal/plans"
+	"github.com/runwayapp/air-traffic-control/internal/ratelimit"
+	"github.com/runwayapp/air-traffic-control/internal/revocation"
+	"github.com/runwayapp/air-traffic-control/internal/tracing"
 	token "github.com/runwayapp/air-traffic-control/internal/utils"
 
 	"github.com/gin-gonic/gin"
-	_ "github.com/go-sql-driver/mysql"
 	"github.com/google/uuid"
 	"github.com/joho/godotenv"
 )
 
-var db *sql.DB
+var db *database.DB
+var githubClient *github.Client
+var revocations *revocation.List
+var oidcVerifier token.ExternalVerifier
+var planCache *plans.Cache
 
 type Command struct {
 	Id           string
@@ -28,6 +48,8 @@ type Command struct {
 	Data         string
 	Created_at   string
 	Updated_at   string
+	// the organization a command is inherited from, nil for repository commands
+	Inherited_from *string `json:"-"`
 }
 
 type CommandResponse struct {
@@ -38,10 +60,17 @@ type CommandResponse struct {
 	Data         map[string]interface{} `json:"data"`
 	Created_at   string                 `json:"created_at"`
 	Updated_at   string                 `json:"updated_at"`
+	// the organization a command is inherited from, null for repository commands
+	Inherited_from *string `json:"inherited_from"`
 }
 
 type AuthRequest struct {
 	Login string `json:"login"`
+	// the orgs, "org/repo" pairs and scopes the token is restricted to
+	// orgs defaults to the org that owns the API key
+	Orgs   []string `json:"orgs"`
+	Repos  []string `json:"repos"`
+	Scopes []string `json:"scopes"`
 }
 
 func main() {
@@ -49,72 +78,209 @@ func main() {
 
 	// log env on startup just incase
 	if os.Getenv("ENV") != "" {
-		log.Printf("ENV Detected on Startup: %s", os.Getenv("ENV"))
+		logging.Default().Info("ENV detected on startup", "env", os.Getenv("ENV"))
 	}
 
 	// Load in the `.env` file only in development
 	if os.Getenv("ENV") != "production" {
 		err = godotenv.Load()
 		if err != nil {
-			log.Fatal("failed to load env", err)
+			logging.Default().Fatal("failed to load env", "error", err)
 		}
 	}
 
-	log.Printf("ENV: %s", os.Getenv("ENV"))
+	logging.Default().Info("loaded env", "env", os.Getenv("ENV"))
 
-	// Open a connection to the database
-	db, err = sql.Open("mysql", os.Getenv("DSN"))
-	if err != nil {
-		log.Fatal("failed to open db connection", err)
+	// Run the command given on the command line, serve when there is none
+	if err := runCommand(os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// serve runs the API server until it receives SIGINT or SIGTERM
+func serve(cfg *config.Config) {
+	logger := logging.Default()
+
+	// Trace requests, queries, background jobs and GitHub calls
+	switch cfg.Tracing.Exporter {
+	case tracing.ExporterStdout:
+		tracing.SetDefault(tracing.New(&tracing.StdoutExporter{ServiceName: cfg.Tracing.ServiceName, Out: os.Stdout}, cfg.Tracing.SampleRatio))
+	case tracing.ExporterOTLP:
+		exporter := tracing.NewOTLPExporter(cfg.Tracing.ServiceName, cfg.Tracing.Endpoint, cfg.Tracing.Headers, func(err error) {
+			logger.Error("failed to export spans", "error", err)
+		})
+		tracing.SetDefault(tracing.New(exporter, cfg.Tracing.SampleRatio))
+		logger.Info("exporting traces", "endpoint", cfg.Tracing.Endpoint)
+	}
+
+	// Load the keys used to sign and verify tokens
+	if err := configureTokens(cfg); err != nil {
+		logger.Fatal("failed to load jwt signing keys", "error", err)
+	}
+	if token.AcceptsHS256Transition() {
+		logger.Warn("HS256 tokens signed with JWT_SECRET are still accepted, unset JWT_ACCEPT_HS256 once they have expired", "signing_alg", cfg.JWT.SigningAlg)
+	}
+
+	// Requests without a token act as a fake identity in development so scope checks still run
+	if cfg.DevIdentity.Login != "" {
+		grant := token.Grant{Orgs: cfg.DevIdentity.Orgs, Repos: cfg.DevIdentity.Repos, Scopes: cfg.DevIdentity.Scopes}
+		devIdentity, err := token.SetDevIdentity(cfg.DevIdentity.Login, grant)
+		if err != nil {
+			logger.Fatal("invalid dev identity", "error", err)
+		}
+		logger.Warn("authentication is bypassed, requests without a token act as the dev identity", "login", devIdentity.Login, "orgs", devIdentity.Orgs, "scopes", devIdentity.Scopes)
+	}
+	if os.Getenv("SKIP_JWT_CHECK") != "" {
+		logger.Warn("SKIP_JWT_CHECK is no longer supported and is ignored, set DEV_IDENTITY_LOGIN instead")
+	}
+	if os.Getenv("TOKEN_HOUR_LIFESPAN") != "" {
+		logger.Warn("TOKEN_HOUR_LIFESPAN is no longer supported and is ignored, access tokens live for ACCESS_TOKEN_MINUTE_LIFESPAN and are renewed with refresh tokens", "access_token_lifespan", cfg.JWT.AccessTokenLifespan.String())
+	}
+
+	// Open a connection to the database, waiting for it to come up
+	if err := openDatabase(cfg); err != nil {
+		logger.Fatal("failed to connect to database", "error", err)
+	}
+
+	logger.Info("successfully connected to database")
+	database.LogStats(db)
+	metrics.RegisterDatabase(db)
+	db.Observers = append(db.Observers, tracing.DatabaseObserver{})
+
+	// GitHub API client used to fetch command manifests
+	githubClient = github.NewClient(cfg.GitHub.ApiURL, cfg.GitHub.Token)
+
+	// Expire invocations that were not approved in time
+	invocationExpiry := &jobs.Worker{Name: "invocation-expiry", Interval: invocationExpiryInterval, Run: expireInvocations}
+	startWorker(invocationExpiry)
+
+	// Load revoked tokens and keep the in-memory list in sync with other instances
+	revocations = revocation.New(db)
+	if err := revocations.Refresh(context.Background()); err != nil {
+		logger.Error("failed to load token revocations", "error", err)
+	}
+	revocationRefresh := &jobs.Worker{Name: "revocation-refresh", Interval: revocation.RefreshInterval, Run: revocations.Refresh}
+	startWorker(revocationRefresh)
+
+	// Pick up signing keys generated and activated after startup
+	keysReload := &jobs.Worker{Name: "signing-keys-reload", Interval: token.ReloadInterval, Run: token.ReloadKeys}
+	startWorker(keysReload)
+
+	// Accept OIDC tokens from GitHub Actions workflows, scoped to the repository running the workflow
+	if cfg.OIDC.Issuer != "" {
+		oidcVerifier = oidc.NewVerifier(cfg.OIDC.Issuer, cfg.OIDC.JWKSURL, cfg.OIDC.Audience, cfg.OIDC.Scopes)
+		logger.Info("accepting OIDC tokens", "issuer", cfg.OIDC.Issuer)
 	}
 
-	if err := db.Ping(); err != nil {
-		log.Printf("ERROR: failed to ping / connect to database: %v", err)
+	// Tokens are only read from the Authorization header, except on routes listed in TOKEN_QUERY_ROUTES
+	token.AllowQueryToken(cfg.JWT.QueryTokenRoutes...)
+
+	// Rate limit requests with the limits of each org's plan
+	var rateLimitStore ratelimit.Store = ratelimit.NewMemoryStore()
+	if cfg.RateLimit.Store == config.RateLimitStoreMySQL {
+		rateLimitStore = ratelimit.NewMySQLStore(db)
 	}
+	planCache = plans.NewCache(db)
+	limiter := &ratelimit.Limiter{Store: rateLimitStore, Plans: planCache, Limits: cfg.RateLimit.Limits}
+	rateLimitPrune := &jobs.Worker{Name: "rate-limit-prune", Interval: ratelimit.PruneInterval, Run: rateLimitStore.Prune}
+	startWorker(rateLimitPrune)
 
-	log.Println("successfully connected to database")
+	// liveness, readiness and a detailed dependency report
+	healthChecker = &health.Checker{}
+	healthChecker.Add("database", checkDatabase)
+	healthChecker.Add("migrations", checkMigrations)
+	healthChecker.Add("workers", checkWorkers)
 
 	// Build router & define routes
-	router := gin.Default()
-
-	// Recovery middleware recovers from any panics and writes a 500 if there was one.
-	router.Use(gin.Recovery())
-
-	protected := router.Group("/api/v1")
-	protected.Use(middlewares.JwtAuthMiddleware())
-	protected.GET("/:org/:repo/commands", GetRepoCommands)
-	protected.GET("/:org/:repo/commands/:commandId", GetSingleCommand)
-	protected.POST("/:org/:repo/commands", CreateCommand)
-	protected.PUT("/:org/:repo/commands/:commandId", UpdateCommand)
-	protected.DELETE("/:org/:repo/commands/:commandId", DeleteCommand)
-
-	apiKeyProtection := router.Group("/api/v1")
-	apiKeyProtection.Use(middlewares.ApiKeyAuthMiddleware())
-	apiKeyProtection.POST("/auth", Auth)
-
-	// add ping endpoint
-	router.GET("/ping", func(c *gin.Context) {
-		c.JSON(200, gin.H{
-			"message": "pong",
-		})
-	})
+	router := newRouter(cfg, limiter)
+
+	// Serve metrics on their own listener, or on the API port behind the admin token
+	servers := []*http.Server{}
+	if cfg.Metrics.Addr != "" {
+		metricsRouter := gin.New()
+		metricsRouter.Use(middlewares.Recovery())
+		if cfg.Metrics.Token != "" {
+			metricsRouter.Use(middlewares.MetricsTokenAuth(cfg.Metrics.Token))
+		}
+		metricsRouter.GET("/metrics", gin.WrapH(metrics.Default))
+		servers = append(servers, &http.Server{Addr: cfg.Metrics.Addr, Handler: metricsRouter, ReadTimeout: cfg.Server.ReadTimeout, WriteTimeout: cfg.Server.WriteTimeout})
+	} else if cfg.Metrics.Token == "" {
+		logger.Info("metrics are disabled, set METRICS_ADDR or METRICS_TOKEN to serve /metrics")
+	}
 
-	router.GET("/", func(c *gin.Context) {
-		c.JSON(200, gin.H{
-			"message": "air-traffic-control application",
-		})
-	})
+	// Run the servers until they are told to stop
+	server := &http.Server{
+		Addr:         ":" + cfg.Port,
+		Handler:      router,
+		ReadTimeout:  cfg.Server.ReadTimeout,
+		WriteTimeout: cfg.Server.WriteTimeout,
+		IdleTimeout:  cfg.Server.IdleTimeout,
+	}
+	servers = append([]*http.Server{server}, servers...)
+	for _, server := range servers {
+		go func(server *http.Server) {
+			logger.Info("listening", "addr", server.Addr)
+			if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				logger.Fatal("server failed", "error", err)
+			}
+		}(server)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	received := <-signals
+
+	shutdown(servers, cfg.Server.ShutdownDelay, cfg.Server.ShutdownTimeout)
+	logger.Info("shut down", "signal", received.String())
+}
+
+// shutdown fails readiness, waits delay for load balancers to notice, then drains in-flight requests
+// and background workers, flushes traces and closes the database pool, giving up on anything still running after timeout
+func shutdown(servers []*http.Server, delay time.Duration, timeout time.Duration) {
+	logger := logging.Default()
+	logger.Info("shutting down, draining requests and workers", "timeout", timeout.String())
+	healthChecker.ShutDown()
+	time.Sleep(delay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	for _, server := range servers {
+		if err := server.Shutdown(ctx); err != nil {
+			logger.Error("failed to drain in-flight requests", "addr", server.Addr, "error", err)
+		}
+	}
+
+	for _, worker := range workers {
+		worker.Stop()
+	}
+	for _, worker := range workers {
+		if err := worker.Wait(ctx); err != nil {
+			logger.Error("worker did not stop", "worker", worker.Name, "error", err)
+		}
+	}
+
+	if err := tracing.Default().Shutdown(ctx); err != nil {
+		logger.Error("failed to export the last spans", "error", err)
+	}
 
-	// Run the router
-	router.Run()
+	if err := db.Close(); err != nil {
+		logger.Error("failed to close the database pool", "error", err)
+	}
+}
+
+// startWorker starts a background worker and tracks it for readiness
+func startWorker(worker *jobs.Worker) {
+	worker.Start(context.Background())
+	workers = append(workers, worker)
 }
 
 func Auth(c *gin.Context) {
 	var authRequest AuthRequest
 	err := c.BindJSON(&authRequest)
 	if err != nil {
-		msg, _ := fmt.Printf("(Auth) c.BindJSON %s", err)
-		panic(msg)
+		panic(fmt.Sprintf("(Auth) c.BindJSON %s", err))
 	}
 
 	if authRequest.Login == "" {
@@ -122,14 +288,33 @@ func Auth(c *gin.Context) {
 		return
 	}
 
-	token, err := token.GenerateToken(authRequest.Login)
+	// an org owned API key can only mint tokens for its own org
+	key := c.MustGet(apikeys.ContextKey).(*apikeys.Key)
+	if len(authRequest.Orgs) == 0 && key.Organization != "" {
+		authRequest.Orgs = []string{key.Organization}
+	}
+	for _, org := range authRequest.Orgs {
+		if !key.AllowsOrg(org) {
+			metrics.AuthFailures.Inc("api_key_org")
+			c.JSON(http.StatusForbidden, gin.H{"message": fmt.Sprintf("api key can't mint tokens for the %s org", org)})
+			return
+		}
+	}
+
+	grant := token.Grant{Orgs: authRequest.Orgs, Repos: authRequest.Repos, Scopes: authRequest.Scopes}
+	if err := token.ValidateGrant(grant); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	// every login starts a new refresh token family
+	tokens, err := issueTokens(c.Request.Context(), authRequest.Login, grant, uuid.New().String())
 
 	if err != nil {
-		msg, _ := fmt.Printf("(Auth) token.GenerateToken %s", err)
-		panic(msg)
+		panic(fmt.Sprintf("(Auth) issueTokens %s", err))
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "ok", "token": token})
+	c.JSON(http.StatusOK, tokens)
 }
 
 func GetRepoCommands(c *gin.Context) {
@@ -138,40 +323,20 @@ func GetRepoCommands(c *gin.Context) {
 	repo := c.Param("repo")
 	repo = strings.ReplaceAll(repo, "/", "")
 
-	query := `SELECT * FROM commands WHERE organization = ? AND repository = ?`
-	res, err := db.Query(query, org, repo)
-	defer res.Close()
+	// repository commands merged with the org commands they inherit
+	effectiveCommands, err := loadEffectiveCommands(c.Request.Context(), org, repo)
 	if err != nil {
-		msg, _ := fmt.Printf("(GetCommands) db.Query %s", err)
-		panic(msg)
+		panic(fmt.Sprintf("(GetCommands) loadEffectiveCommands %s", err))
 	}
 
 	commands := []CommandResponse{}
-	for res.Next() {
-		var commandResponse CommandResponse
-		var command Command
-		err := res.Scan(&command.Id, &command.Organization, &command.Repository, &command.Name, &command.Data, &command.Created_at, &command.Updated_at)
-		if err != nil {
-			msg, _ := fmt.Printf("(GetCommands) res.Scan %s", err)
-			panic(msg)
-		}
-
+	for _, command := range effectiveCommands {
 		// ensure the data is valid json before appending
-		var data map[string]interface{}
-		err = json.Unmarshal([]byte(command.Data), &data)
+		commandResponse, err := buildCommandResponse(command)
 		if err != nil {
-			msg, _ := fmt.Printf("(GetCommands) json.Unmarshal %s", err)
-			panic(msg)
+			panic(fmt.Sprintf("(GetCommands) json.Unmarshal %s", err))
 		}
 
-		commandResponse.Id = command.Id
-		commandResponse.Organization = command.Organization
-		commandResponse.Repository = command.Repository
-		commandResponse.Name = command.Name
-		commandResponse.Created_at = command.Created_at
-		commandResponse.Updated_at = command.Updated_at
-		commandResponse.Data = data
-
 		commands = append(commands, commandResponse)
 	}
 
@@ -186,31 +351,22 @@ func GetSingleCommand(c *gin.Context) {
 	commandId := c.Param("commandId")
 	commandId = strings.ReplaceAll(commandId, "/", "")
 
-	var commandResponse CommandResponse
-	var command Command
-	query := `SELECT * FROM commands WHERE id = ? AND organization = ? AND repository = ?`
-	err := db.QueryRow(query, commandId, org, repo).Scan(&command.Id, &command.Organization, &command.Repository, &command.Name, &command.Data, &command.Created_at, &command.Updated_at)
+	// the id can be a repository command or an org command the repository inherits
+	command, err := findCommand(c.Request.Context(), commandId, org, repo)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "command not found"})
+		return
+	}
 	if err != nil {
-		msg, _ := fmt.Printf("(GetSingleCommand) db.Exec %s", err)
-		panic(msg)
+		panic(fmt.Sprintf("(GetSingleCommand) findCommand %s", err))
 	}
 
 	// ensure the data is valid json before appending
-	var data map[string]interface{}
-	err = json.Unmarshal([]byte(command.Data), &data)
+	commandResponse, err := buildCommandResponse(command)
 	if err != nil {
-		msg, _ := fmt.Printf("(GetCommands) json.Unmarshal %s", err)
-		panic(msg)
+		panic(fmt.Sprintf("(GetSingleCommand) json.Unmarshal %s", err))
 	}
 
-	commandResponse.Id = command.Id
-	commandResponse.Organization = command.Organization
-	commandResponse.Repository = command.Repository
-	commandResponse.Name = command.Name
-	commandResponse.Created_at = command.Created_at
-	commandResponse.Updated_at = command.Updated_at
-	commandResponse.Data = data
-
 	c.JSON(http.StatusOK, commandResponse)
 }
 
@@ -225,8 +381,7 @@ func CreateCommand(c *gin.Context) {
 	var newCommand Command
 	err := c.BindJSON(&newCommand)
 	if err != nil {
-		msg, _ := fmt.Printf("(CreateCommand) c.BindJSON %s", err)
-		panic(msg)
+		panic(fmt.Sprintf("(CreateCommand) c.BindJSON %s", err))
 	}
 
 	// add values to the new command
@@ -246,18 +401,40 @@ func CreateCommand(c *gin.Context) {
 		return
 	}
 
+	// ensure the approvals config is usable before storing it
+	if _, err := parseApprovalConfig(newCommand.Data); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tx, err := db.BeginTx(c.Request.Context(), nil)
+	if err != nil {
+		panic(fmt.Sprintf("(CreateCommand) db.BeginTx %s", err))
+	}
+	defer tx.Rollback()
+
+	// reject commands the org's plan does not allow, counting and inserting in one transaction
+	if err := checkCommandQuota(c.Request.Context(), tx, org, repo, newCommand.Name, newCommand.Data, 1); err != nil {
+		if writeQuotaError(c, err) {
+			return
+		}
+		panic(fmt.Sprintf("(CreateCommand) checkCommandQuota %s", err))
+	}
+
 	query := `INSERT INTO commands (id, organization, repository, name, data) VALUES (?, ?, ?, ?, ?)`
-	res, err := db.Exec(query, newCommand.Id, newCommand.Organization, newCommand.Repository, newCommand.Name, newCommand.Data)
+	res, err := tx.ExecContext(c.Request.Context(), query, newCommand.Id, newCommand.Organization, newCommand.Repository, newCommand.Name, newCommand.Data)
 	if err != nil {
-		msg, _ := fmt.Printf("(CreateCommand) db.Exec %s", err)
-		panic(msg)
+		panic(fmt.Sprintf("(CreateCommand) tx.Exec %s", err))
 	}
 
 	_, err = res.LastInsertId()
 
 	if err != nil {
-		msg, _ := fmt.Printf("(CreateCommand) res.LastInsertId %s", err)
-		panic(msg)
+		panic(fmt.Sprintf("(CreateCommand) res.LastInsertId %s", err))
+	}
+
+	if err := tx.Commit(); err != nil {
+		panic(fmt.Sprintf("(CreateCommand) tx.Commit %s", err))
 	}
 
 	var commandResponse CommandResponse
@@ -266,8 +443,7 @@ func CreateCommand(c *gin.Context) {
 	var data map[string]interface{}
 	err = json.Unmarshal([]byte(newCommand.Data), &data)
 	if err != nil {
-		msg, _ := fmt.Printf("(GetCommands) json.Unmarshal %s", err)
-		panic(msg)
+		panic(fmt.Sprintf("(GetCommands) json.Unmarshal %s", err))
 	}
 
 	commandResponse.Id = newCommand.Id
@@ -285,8 +461,7 @@ func UpdateCommand(c *gin.Context) {
 	var updates Command
 	err := c.BindJSON(&updates)
 	if err != nil {
-		msg, _ := fmt.Printf("(UpdateCommand) c.BindJSON %s", err)
-		panic(msg)
+		panic(fmt.Sprintf("(UpdateCommand) c.BindJSON %s", err))
 	}
 
 	org := c.Param("org")
@@ -302,18 +477,30 @@ func UpdateCommand(c *gin.Context) {
 		return
 	}
 
+	// ensure the approvals config is usable before storing it
+	if _, err := parseApprovalConfig(updates.Data); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// reject commands the org's plan does not allow
+	if err := checkCommandFeatures(c.Request.Context(), org, updates.Name, updates.Data); err != nil {
+		if writeQuotaError(c, err) {
+			return
+		}
+		panic(fmt.Sprintf("(UpdateCommand) checkCommandFeatures %s", err))
+	}
+
 	query := `UPDATE commands SET name = ?, data = ? WHERE id = ? AND organization = ? AND repository = ?`
-	result, err := db.Exec(query, updates.Name, updates.Data, commandId, org, repo)
+	result, err := db.ExecContext(c.Request.Context(), query, updates.Name, updates.Data, commandId, org, repo)
 	if err != nil {
-		msg, _ := fmt.Printf("(UpdateCommand) db.Exec %s", err)
-		panic(msg)
+		panic(fmt.Sprintf("(UpdateCommand) db.Exec %s", err))
 	}
 
 	// if no rows were affected, return an error
 	rowsAffected, err := result.RowsAffected()
 	if err != nil {
-		msg, _ := fmt.Printf("(DeleteCommand) result.RowsAffected %s", err)
-		panic(msg)
+		panic(fmt.Sprintf("(DeleteCommand) result.RowsAffected %s", err))
 	}
 
 	if rowsAffected == 0 {
@@ -345,17 +532,15 @@ func DeleteCommand(c *gin.Context) {
 	}
 
 	query := `DELETE FROM commands WHERE id = ? AND organization = ? AND repository = ?`
-	result, err := db.Exec(query, commandId, org, repo)
+	result, err := db.ExecContext(c.Request.Context(), query, commandId, org, repo)
 	if err != nil {
-		msg, _ := fmt.Printf("(DeleteCommand) db.Exec %s", err)
-		panic(msg)
+		panic(fmt.Sprintf("(DeleteCommand) db.Exec %s", err))
 	}
 
 	// if no rows were affected, return an error
 	rowsAffected, err := result.RowsAffected()
 	if err != nil {
-		msg, _ := fmt.Printf("(DeleteCommand) result.RowsAffected %s", err)
-		panic(msg)
+		panic(fmt.Sprintf("(DeleteCommand) result.RowsAffected %s", err))
 	}
 
 	if rowsAffected == 0 {
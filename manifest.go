@@ -0,0 +1,378 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/runwayapp/air-traffic-control/internal/database"
+	"gopkg.in/yaml.v3"
+)
+
+// the largest manifest accepted by the import endpoint
+const maxManifestBytes = 1 << 20
+
+// Manifest is the declarative, version-controllable form of a repository's commands
+type Manifest struct {
+	Commands []ManifestCommand `json:"commands" yaml:"commands"`
+}
+
+type ManifestCommand struct {
+	Name string                 `json:"name" yaml:"name"`
+	Data map[string]interface{} `json:"data" yaml:"data"`
+}
+
+// ManifestChange describes a command whose data differs from the manifest
+type ManifestChange struct {
+	Name   string   `json:"name"`
+	Fields []string `json:"fields"`
+}
+
+// ManifestPlan is the diff between a manifest and the stored commands
+type ManifestPlan struct {
+	Dry_run   bool             `json:"dry_run"`
+	Prune     bool             `json:"prune"`
+	Create    []string         `json:"create"`
+	Update    []ManifestChange `json:"update"`
+	Delete    []string         `json:"delete"`
+	Unchanged []string         `json:"unchanged"`
+}
+
+// parseManifest decodes a YAML or JSON manifest and validates its commands
+func parseManifest(body []byte, contentType string) (Manifest, error) {
+	var manifest Manifest
+
+	var err error
+	if strings.HasPrefix(contentType, "application/json") {
+		err = json.Unmarshal(body, &manifest)
+	} else {
+		err = yaml.Unmarshal(body, &manifest)
+	}
+	if err != nil {
+		return manifest, fmt.Errorf("manifest could not be parsed: %w", err)
+	}
+
+	names := map[string]bool{}
+	for i, command := range manifest.Commands {
+		if command.Name == "" {
+			return manifest, fmt.Errorf("commands[%d] is missing a name", i)
+		}
+		if names[command.Name] {
+			return manifest, fmt.Errorf("command %q is defined more than once", command.Name)
+		}
+		names[command.Name] = true
+
+		if command.Data == nil {
+			return manifest, fmt.Errorf("command %q is missing data", command.Name)
+		}
+
+		// normalize the data through json so it compares equal to what is stored in MySQL
+		data, err := json.Marshal(command.Data)
+		if err != nil {
+			return manifest, fmt.Errorf("command %q has data that can't be stored as JSON: %w", command.Name, err)
+		}
+		if _, err := parseApprovalConfig(string(data)); err != nil {
+			return manifest, fmt.Errorf("command %q: %w", command.Name, err)
+		}
+		manifest.Commands[i].Data = nil
+		if err := json.Unmarshal(data, &manifest.Commands[i].Data); err != nil {
+			return manifest, err
+		}
+	}
+
+	return manifest, nil
+}
+
+// changedFields lists the top level keys of a command's data that differ between two documents
+func changedFields(stored map[string]interface{}, desired map[string]interface{}) []string {
+	fields := []string{}
+	for key, value := range desired {
+		if !reflect.DeepEqual(stored[key], value) {
+			fields = append(fields, key)
+		}
+	}
+	for key := range stored {
+		if _, ok := desired[key]; !ok {
+			fields = append(fields, key)
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// loadRepoCommands returns a repository's own commands, excluding inherited org commands
+func loadRepoCommands(ctx context.Context, q querier, org string, repo string) ([]Command, error) {
+	query := `SELECT * FROM commands WHERE organization = ? AND repository = ? ORDER BY name, created_at`
+	res, err := q.QueryContext(ctx, query, org, repo)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	commands := []Command{}
+	for res.Next() {
+		var command Command
+		err := res.Scan(&command.Id, &command.Organization, &command.Repository, &command.Name, &command.Data, &command.Created_at, &command.Updated_at)
+		if err != nil {
+			return nil, err
+		}
+		commands = append(commands, command)
+	}
+	return commands, res.Err()
+}
+
+// planManifest compares a manifest to a repository's stored commands
+// commands are matched by name, and any stored command the manifest does not mention is deleted when pruning
+func planManifest(manifest Manifest, stored []Command, prune bool) (ManifestPlan, map[string]Command, error) {
+	plan := ManifestPlan{Prune: prune, Create: []string{}, Update: []ManifestChange{}, Delete: []string{}, Unchanged: []string{}}
+
+	existing := map[string]Command{}
+	extras := []Command{}
+	for _, command := range stored {
+		if _, ok := existing[command.Name]; ok {
+			extras = append(extras, command)
+			continue
+		}
+		existing[command.Name] = command
+	}
+
+	desired := map[string]bool{}
+	for _, command := range manifest.Commands {
+		desired[command.Name] = true
+
+		current, ok := existing[command.Name]
+		if !ok {
+			plan.Create = append(plan.Create, command.Name)
+			continue
+		}
+
+		var data map[string]interface{}
+		if err := json.Unmarshal([]byte(current.Data), &data); err != nil {
+			return plan, nil, err
+		}
+
+		fields := changedFields(data, command.Data)
+		if len(fields) == 0 {
+			plan.Unchanged = append(plan.Unchanged, command.Name)
+		} else {
+			plan.Update = append(plan.Update, ManifestChange{Name: command.Name, Fields: fields})
+		}
+	}
+
+	// every command not in the manifest, including duplicates of a name, is removed when pruning
+	deletions := map[string]Command{}
+	if prune {
+		for name, command := range existing {
+			if !desired[name] {
+				plan.Delete = append(plan.Delete, name)
+				deletions[command.Id] = command
+			}
+		}
+		for _, command := range extras {
+			plan.Delete = append(plan.Delete, command.Name)
+			deletions[command.Id] = command
+		}
+		sort.Strings(plan.Delete)
+	}
+
+	return plan, deletions, nil
+}
+
+// checkManifestQuota returns a *QuotaError if applying a plan would exceed the org's quotas
+// unchanged commands are not checked so orgs that downgrade can keep importing their manifests
+func checkManifestQuota(ctx context.Context, tx *database.Tx, org string, repo string, manifest Manifest, plan ManifestPlan) error {
+	changed := map[string]bool{}
+	for _, name := range plan.Create {
+		changed[name] = true
+	}
+	for _, change := range plan.Update {
+		changed[change.Name] = true
+	}
+
+	for _, command := range manifest.Commands {
+		if !changed[command.Name] {
+			continue
+		}
+		data, err := json.Marshal(command.Data)
+		if err != nil {
+			return err
+		}
+		if err := checkCommandFeatures(ctx, org, command.Name, string(data)); err != nil {
+			return err
+		}
+	}
+
+	return checkRepositoryQuota(ctx, tx, org, repo, len(plan.Create)-len(plan.Delete))
+}
+
+// applyManifest plans a manifest against a repository and, unless dryRun is set, applies it in a single transaction
+// dry runs are planned in a transaction too and rolled back
+func applyManifest(ctx context.Context, org string, repo string, manifest Manifest, prune bool, dryRun bool) (ManifestPlan, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return ManifestPlan{}, err
+	}
+	defer tx.Rollback()
+
+	// plan against the commands stored once the org's quota is locked, so the counts checked are the ones written to
+	if !dryRun {
+		if err := lockQuota(ctx, tx, org); err != nil {
+			return ManifestPlan{}, err
+		}
+	}
+
+	stored, err := loadRepoCommands(ctx, tx, org, repo)
+	if err != nil {
+		return ManifestPlan{}, err
+	}
+
+	plan, deletions, err := planManifest(manifest, stored, prune)
+	plan.Dry_run = dryRun
+	if err != nil {
+		return plan, err
+	}
+
+	// created and updated commands must fit the org's plan, dry runs report it too
+	if err := checkManifestQuota(ctx, tx, org, repo, manifest, plan); err != nil {
+		return plan, err
+	}
+
+	if dryRun {
+		return plan, nil
+	}
+
+	creates := map[string]bool{}
+	for _, name := range plan.Create {
+		creates[name] = true
+	}
+	updates := map[string]bool{}
+	for _, change := range plan.Update {
+		updates[change.Name] = true
+	}
+
+	for _, command := range manifest.Commands {
+		data, err := json.Marshal(command.Data)
+		if err != nil {
+			return plan, err
+		}
+
+		if creates[command.Name] {
+			query := `INSERT INTO commands (id, organization, repository, name, data) VALUES (?, ?, ?, ?, ?)`
+			if _, err := tx.ExecContext(ctx, query, uuid.New().String(), org, repo, command.Name, string(data)); err != nil {
+				return plan, err
+			}
+		}
+
+		if updates[command.Name] {
+			query := `UPDATE commands SET data = ? WHERE organization = ? AND repository = ? AND name = ?`
+			if _, err := tx.ExecContext(ctx, query, string(data), org, repo, command.Name); err != nil {
+				return plan, err
+			}
+		}
+	}
+
+	for id := range deletions {
+		query := `DELETE FROM commands WHERE id = ? AND organization = ? AND repository = ?`
+		if _, err := tx.ExecContext(ctx, query, id, org, repo); err != nil {
+			return plan, err
+		}
+	}
+
+	return plan, tx.Commit()
+}
+
+// readManifestBody reads a manifest from the request body, writing a 413 if it is too large
+func readManifestBody(c *gin.Context) ([]byte, bool) {
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxManifestBytes))
+	var maxBytesError *http.MaxBytesError
+	if errors.As(err, &maxBytesError) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("manifest must be at most %d bytes", maxManifestBytes)})
+		return nil, false
+	}
+	if err != nil {
+		panic(fmt.Sprintf("(readManifestBody) io.ReadAll %s", err))
+	}
+	return body, true
+}
+
+// ExportCommands writes a repository's own commands as a YAML (default) or JSON manifest
+func ExportCommands(c *gin.Context) {
+	org := c.Param("org")
+	org = strings.ReplaceAll(org, "/", "")
+	repo := c.Param("repo")
+	repo = strings.ReplaceAll(repo, "/", "")
+
+	format := c.DefaultQuery("format", "yaml")
+	if format != "yaml" && format != "json" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be yaml or json"})
+		return
+	}
+
+	commands, err := loadRepoCommands(c.Request.Context(), db, org, repo)
+	if err != nil {
+		panic(fmt.Sprintf("(ExportCommands) loadRepoCommands %s", err))
+	}
+
+	manifest := Manifest{Commands: []ManifestCommand{}}
+	for _, command := range commands {
+		var data map[string]interface{}
+		err := json.Unmarshal([]byte(command.Data), &data)
+		if err != nil {
+			panic(fmt.Sprintf("(ExportCommands) json.Unmarshal %s", err))
+		}
+		manifest.Commands = append(manifest.Commands, ManifestCommand{Name: command.Name, Data: data})
+	}
+
+	if format == "json" {
+		c.JSON(http.StatusOK, manifest)
+		return
+	}
+
+	out, err := yaml.Marshal(manifest)
+	if err != nil {
+		panic(fmt.Sprintf("(ExportCommands) yaml.Marshal %s", err))
+	}
+
+	c.Data(http.StatusOK, "application/yaml; charset=utf-8", out)
+}
+
+// ImportCommands declaratively applies a manifest to a repository
+// ?prune=true deletes commands missing from the manifest and ?dry_run=true only returns the plan
+func ImportCommands(c *gin.Context) {
+	org := c.Param("org")
+	org = strings.ReplaceAll(org, "/", "")
+	repo := c.Param("repo")
+	repo = strings.ReplaceAll(repo, "/", "")
+
+	prune := c.Query("prune") == "true"
+	dryRun := c.Query("dry_run") == "true"
+
+	body, ok := readManifestBody(c)
+	if !ok {
+		return
+	}
+
+	manifest, err := parseManifest(body, c.ContentType())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	plan, err := applyManifest(c.Request.Context(), org, repo, manifest, prune, dryRun)
+	if writeQuotaError(c, err) {
+		return
+	}
+	if err != nil {
+		panic(fmt.Sprintf("(ImportCommands) applyManifest %s", err))
+	}
+
+	c.JSON(http.StatusOK, plan)
+}
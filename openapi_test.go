@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/runwayapp/air-traffic-control/internal/config"
+	"github.com/runwayapp/air-traffic-control/internal/ratelimit"
+)
+
+var specParam = regexp.MustCompile(`\{([^}]+)\}`)
+
+func testRouterRoutes(t *testing.T) map[string]bool {
+	t.Helper()
+	cfg := &config.Config{Env: config.EnvProduction, Metrics: config.Metrics{Token: "test"}}
+	router := newRouter(cfg, &ratelimit.Limiter{Store: ratelimit.NewMemoryStore()})
+
+	routes := map[string]bool{}
+	for _, route := range router.Routes() {
+		routes[route.Method+" "+route.Path] = true
+	}
+	return routes
+}
+
+func TestOpenAPIMatchesRoutes(t *testing.T) {
+	var spec struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(openapiSpec, &spec); err != nil {
+		t.Fatalf("openapi.json is not valid JSON: %s", err)
+	}
+
+	documented := map[string]bool{}
+	for path, operations := range spec.Paths {
+		ginPath := specParam.ReplaceAllString(path, ":$1")
+		for method := range operations {
+			if method == "parameters" {
+				continue
+			}
+			documented[strings.ToUpper(method)+" "+ginPath] = true
+		}
+	}
+
+	routes := testRouterRoutes(t)
+	var missing, stale []string
+	for route := range routes {
+		if !documented[route] {
+			missing = append(missing, route)
+		}
+	}
+	for route := range documented {
+		if !routes[route] {
+			stale = append(stale, route)
+		}
+	}
+	sort.Strings(missing)
+	sort.Strings(stale)
+	for _, route := range missing {
+		t.Errorf("%s is registered but not documented in openapi.json", route)
+	}
+	for _, route := range stale {
+		t.Errorf("%s is documented in openapi.json but not registered", route)
+	}
+}
+
+func TestOpenAPIRefs(t *testing.T) {
+	var spec map[string]interface{}
+	if err := json.Unmarshal(openapiSpec, &spec); err != nil {
+		t.Fatalf("openapi.json is not valid JSON: %s", err)
+	}
+
+	// every $ref must point at a component that exists
+	var walk func(node interface{})
+	walk = func(node interface{}) {
+		switch node := node.(type) {
+		case map[string]interface{}:
+			if ref, ok := node["$ref"].(string); ok {
+				var target interface{} = spec
+				for _, part := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+					object, _ := target.(map[string]interface{})
+					target = object[part]
+				}
+				if target == nil {
+					t.Errorf("%s does not resolve", ref)
+				}
+			}
+			for _, child := range node {
+				walk(child)
+			}
+		case []interface{}:
+			for _, child := range node {
+				walk(child)
+			}
+		}
+	}
+	walk(spec)
+}
+
+func TestOpenAPIServed(t *testing.T) {
+	cfg := &config.Config{Env: config.EnvProduction}
+	router := newRouter(cfg, &ratelimit.Limiter{Store: ratelimit.NewMemoryStore()})
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/v1/openapi.json", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", recorder.Code)
+	}
+	if contentType := recorder.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "application/json") {
+		t.Errorf("expected a JSON content type, got %q", contentType)
+	}
+	if recorder.Body.String() != string(openapiSpec) {
+		t.Error("expected the embedded openapi.json to be served")
+	}
+}
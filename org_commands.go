@@ -0,0 +1,331 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type OrgCommandResponse struct {
+	Id           string                 `json:"id"`
+	Organization string                 `json:"organization"`
+	Name         string                 `json:"name"`
+	Data         map[string]interface{} `json:"data"`
+	Created_at   string                 `json:"created_at"`
+	Updated_at   string                 `json:"updated_at"`
+}
+
+// scanOrgCommand reads an organization_commands row into a Command with no repository
+func scanOrgCommand(row interface{ Scan(...interface{}) error }) (Command, error) {
+	var command Command
+	err := row.Scan(&command.Id, &command.Organization, &command.Name, &command.Data, &command.Created_at, &command.Updated_at)
+	return command, err
+}
+
+// buildOrgCommandResponse converts an org command into an API response
+func buildOrgCommandResponse(command Command) (OrgCommandResponse, error) {
+	var data map[string]interface{}
+	err := json.Unmarshal([]byte(command.Data), &data)
+	if err != nil {
+		return OrgCommandResponse{}, err
+	}
+
+	return OrgCommandResponse{
+		Id:           command.Id,
+		Organization: command.Organization,
+		Name:         command.Name,
+		Data:         data,
+		Created_at:   command.Created_at,
+		Updated_at:   command.Updated_at,
+	}, nil
+}
+
+// loadEffectiveCommands returns a repository's own commands followed by the org commands it inherits
+// a repository command overrides an org command with the same name, and the repository's
+// disabled_inherited_commands setting removes org commands by name
+func loadEffectiveCommands(ctx context.Context, org string, repo string) ([]Command, error) {
+	settings, err := loadRepositorySettings(ctx, org, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	commands := []Command{}
+	names := map[string]bool{}
+
+	query := `SELECT * FROM commands WHERE organization = ? AND repository = ?`
+	res, err := db.QueryContext(ctx, query, org, repo)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	for res.Next() {
+		var command Command
+		err := res.Scan(&command.Id, &command.Organization, &command.Repository, &command.Name, &command.Data, &command.Created_at, &command.Updated_at)
+		if err != nil {
+			return nil, err
+		}
+		names[command.Name] = true
+		commands = append(commands, command)
+	}
+	if err := res.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, name := range settings.Disabled_inherited_commands {
+		names[name] = true
+	}
+
+	query = `SELECT id, organization, name, data, created_at, updated_at FROM organization_commands WHERE organization = ?`
+	orgRes, err := db.QueryContext(ctx, query, org)
+	if err != nil {
+		return nil, err
+	}
+	defer orgRes.Close()
+
+	for orgRes.Next() {
+		command, err := scanOrgCommand(orgRes)
+		if err != nil {
+			return nil, err
+		}
+		if names[command.Name] {
+			continue
+		}
+		command.Repository = repo
+		command.Inherited_from = &command.Organization
+		commands = append(commands, command)
+	}
+
+	return commands, orgRes.Err()
+}
+
+// findCommand returns a repository command or an org command the repository inherits, sql.ErrNoRows if there is neither
+func findCommand(ctx context.Context, commandId string, org string, repo string) (Command, error) {
+	var command Command
+	query := `SELECT * FROM commands WHERE id = ? AND organization = ? AND repository = ?`
+	err := db.QueryRowContext(ctx, query, commandId, org, repo).Scan(&command.Id, &command.Organization, &command.Repository, &command.Name, &command.Data, &command.Created_at, &command.Updated_at)
+	if err != sql.ErrNoRows {
+		return command, err
+	}
+
+	commands, err := loadEffectiveCommands(ctx, org, repo)
+	if err != nil {
+		return Command{}, err
+	}
+	for _, command := range commands {
+		if command.Id == commandId {
+			return command, nil
+		}
+	}
+	return Command{}, sql.ErrNoRows
+}
+
+// findCommandData returns the data of a repository command or an org command the repository inherits
+func findCommandData(ctx context.Context, commandId string, org string, repo string) (string, error) {
+	command, err := findCommand(ctx, commandId, org, repo)
+	return command.Data, err
+}
+
+func GetOrgCommands(c *gin.Context) {
+	org := c.Param("org")
+	org = strings.ReplaceAll(org, "/", "")
+
+	query := `SELECT id, organization, name, data, created_at, updated_at FROM organization_commands WHERE organization = ?`
+	res, err := db.QueryContext(c.Request.Context(), query, org)
+	if err != nil {
+		panic(fmt.Sprintf("(GetOrgCommands) db.Query %s", err))
+	}
+	defer res.Close()
+
+	commands := []OrgCommandResponse{}
+	for res.Next() {
+		command, err := scanOrgCommand(res)
+		if err != nil {
+			panic(fmt.Sprintf("(GetOrgCommands) res.Scan %s", err))
+		}
+
+		commandResponse, err := buildOrgCommandResponse(command)
+		if err != nil {
+			panic(fmt.Sprintf("(GetOrgCommands) json.Unmarshal %s", err))
+		}
+
+		commands = append(commands, commandResponse)
+	}
+
+	c.JSON(http.StatusOK, commands)
+}
+
+func GetSingleOrgCommand(c *gin.Context) {
+	org := c.Param("org")
+	org = strings.ReplaceAll(org, "/", "")
+	commandId := c.Param("commandId")
+	commandId = strings.ReplaceAll(commandId, "/", "")
+
+	query := `SELECT id, organization, name, data, created_at, updated_at FROM organization_commands WHERE id = ? AND organization = ?`
+	command, err := scanOrgCommand(db.QueryRowContext(c.Request.Context(), query, commandId, org))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "command not found"})
+		return
+	}
+	if err != nil {
+		panic(fmt.Sprintf("(GetSingleOrgCommand) db.QueryRow %s", err))
+	}
+
+	commandResponse, err := buildOrgCommandResponse(command)
+	if err != nil {
+		panic(fmt.Sprintf("(GetSingleOrgCommand) json.Unmarshal %s", err))
+	}
+
+	c.JSON(http.StatusOK, commandResponse)
+}
+
+func CreateOrgCommand(c *gin.Context) {
+	id := uuid.New().String()
+
+	org := c.Param("org")
+	org = strings.ReplaceAll(org, "/", "")
+
+	var newCommand Command
+	err := c.BindJSON(&newCommand)
+	if err != nil {
+		panic(fmt.Sprintf("(CreateOrgCommand) c.BindJSON %s", err))
+	}
+
+	newCommand.Id = id
+	newCommand.Organization = org
+
+	// check all required inputs
+	if newCommand.Organization == "" || newCommand.Name == "" || newCommand.Data == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "organization, name, and data are required"})
+		return
+	}
+
+	// ensure the approvals config is usable before storing it
+	if _, err := parseApprovalConfig(newCommand.Data); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// reject commands the org's plan does not allow
+	if err := checkCommandFeatures(c.Request.Context(), org, newCommand.Name, newCommand.Data); err != nil {
+		if writeQuotaError(c, err) {
+			return
+		}
+		panic(fmt.Sprintf("(CreateOrgCommand) checkCommandFeatures %s", err))
+	}
+
+	tx, err := db.BeginTx(c.Request.Context(), nil)
+	if err != nil {
+		panic(fmt.Sprintf("(CreateOrgCommand) db.BeginTx %s", err))
+	}
+	defer tx.Rollback()
+
+	// every repository inherits org commands, so they are counted and inserted in one transaction
+	if err := checkOrgCommandQuota(c.Request.Context(), tx, org, 1); err != nil {
+		if writeQuotaError(c, err) {
+			return
+		}
+		panic(fmt.Sprintf("(CreateOrgCommand) checkOrgCommandQuota %s", err))
+	}
+
+	query := `INSERT INTO organization_commands (id, organization, name, data) VALUES (?, ?, ?, ?)`
+	_, err = tx.ExecContext(c.Request.Context(), query, newCommand.Id, newCommand.Organization, newCommand.Name, newCommand.Data)
+	if err != nil {
+		panic(fmt.Sprintf("(CreateOrgCommand) tx.Exec %s", err))
+	}
+
+	if err := tx.Commit(); err != nil {
+		panic(fmt.Sprintf("(CreateOrgCommand) tx.Commit %s", err))
+	}
+
+	commandResponse, err := buildOrgCommandResponse(newCommand)
+	if err != nil {
+		panic(fmt.Sprintf("(CreateOrgCommand) json.Unmarshal %s", err))
+	}
+
+	c.JSON(http.StatusOK, commandResponse)
+}
+
+func UpdateOrgCommand(c *gin.Context) {
+	var updates Command
+	err := c.BindJSON(&updates)
+	if err != nil {
+		panic(fmt.Sprintf("(UpdateOrgCommand) c.BindJSON %s", err))
+	}
+
+	org := c.Param("org")
+	org = strings.ReplaceAll(org, "/", "")
+	commandId := c.Param("commandId")
+	commandId = strings.ReplaceAll(commandId, "/", "")
+
+	// check all required inputs
+	if updates.Name == "" || updates.Data == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name and data are required"})
+		return
+	}
+
+	// ensure the approvals config is usable before storing it
+	if _, err := parseApprovalConfig(updates.Data); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// reject commands the org's plan does not allow
+	if err := checkCommandFeatures(c.Request.Context(), org, updates.Name, updates.Data); err != nil {
+		if writeQuotaError(c, err) {
+			return
+		}
+		panic(fmt.Sprintf("(UpdateOrgCommand) checkCommandFeatures %s", err))
+	}
+
+	query := `UPDATE organization_commands SET name = ?, data = ? WHERE id = ? AND organization = ?`
+	result, err := db.ExecContext(c.Request.Context(), query, updates.Name, updates.Data, commandId, org)
+	if err != nil {
+		panic(fmt.Sprintf("(UpdateOrgCommand) db.Exec %s", err))
+	}
+
+	// if no rows were affected, return an error
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		panic(fmt.Sprintf("(UpdateOrgCommand) result.RowsAffected %s", err))
+	}
+
+	if rowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "command not found"})
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
+
+func DeleteOrgCommand(c *gin.Context) {
+	org := c.Param("org")
+	org = strings.ReplaceAll(org, "/", "")
+	commandId := c.Param("commandId")
+	commandId = strings.ReplaceAll(commandId, "/", "")
+
+	query := `DELETE FROM organization_commands WHERE id = ? AND organization = ?`
+	result, err := db.ExecContext(c.Request.Context(), query, commandId, org)
+	if err != nil {
+		panic(fmt.Sprintf("(DeleteOrgCommand) db.Exec %s", err))
+	}
+
+	// if no rows were affected, return an error
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		panic(fmt.Sprintf("(DeleteOrgCommand) result.RowsAffected %s", err))
+	}
+
+	if rowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "command not found"})
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
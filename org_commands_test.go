@@ -0,0 +1,82 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/runwayapp/air-traffic-control/internal/database/dbtest"
+)
+
+// fakeInheritance serves runwayapp/test-flight with a repository command and an inherited org command
+func fakeInheritance(t *testing.T) {
+	const created = "2026-10-19 09:00:00"
+	fake := &dbtest.Fake{
+		Query: func(query string, args []driver.Value) (dbtest.Rows, error) {
+			commandColumns := []string{"id", "organization", "repository", "name", "data", "created_at", "updated_at"}
+			switch {
+			case strings.Contains(query, "FROM commands WHERE id = ?"):
+				if args[0] != "repo-command" {
+					return dbtest.Rows{Columns: commandColumns}, nil
+				}
+				fallthrough
+			case strings.Contains(query, "FROM commands WHERE organization = ? AND repository = ?"):
+				return dbtest.Rows{Columns: commandColumns, Values: [][]driver.Value{{"repo-command", "runwayapp", "test-flight", "lint", `{"actions": []}`, created, created}}}, nil
+			case strings.Contains(query, "FROM repositories WHERE organization"):
+				columns := []string{"trigger_prefix", "case_sensitive", "default_reaction", "allowed_branches", "ignore_closed_prs", "disabled_inherited_commands", "reconcile_on_push"}
+				return dbtest.Rows{Columns: columns, Values: [][]driver.Value{{".", false, "", "[]", false, "[]", false}}}, nil
+			case strings.Contains(query, "FROM organization_commands WHERE organization = ?"):
+				columns := []string{"id", "organization", "name", "data", "created_at", "updated_at"}
+				return dbtest.Rows{Columns: columns, Values: [][]driver.Value{{"org-command", "runwayapp", "help", `{"actions": []}`, created, created}}}, nil
+			}
+			t.Fatalf("unexpected query %s", query)
+			return dbtest.Rows{}, nil
+		},
+	}
+	db = dbtest.Open(t, fake)
+}
+
+func TestGetSingleCommand(t *testing.T) {
+	cases := []struct {
+		id            string
+		status        int
+		inheritedFrom string
+	}{
+		{id: "repo-command", status: http.StatusOK},
+		{id: "org-command", status: http.StatusOK, inheritedFrom: "runwayapp"},
+		{id: "unknown", status: http.StatusNotFound},
+	}
+	for _, tc := range cases {
+		fakeInheritance(t)
+
+		gin.SetMode(gin.TestMode)
+		router := gin.New()
+		router.GET("/api/v1/:org/:repo/commands/:commandId", GetSingleCommand)
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/v1/runwayapp/test-flight/commands/"+tc.id, nil))
+
+		if recorder.Code != tc.status {
+			t.Errorf("%s: expected %d, got %d: %s", tc.id, tc.status, recorder.Code, recorder.Body)
+			continue
+		}
+		if tc.status != http.StatusOK {
+			continue
+		}
+
+		var command CommandResponse
+		if err := json.Unmarshal(recorder.Body.Bytes(), &command); err != nil {
+			t.Fatal(err)
+		}
+		inheritedFrom := ""
+		if command.Inherited_from != nil {
+			inheritedFrom = *command.Inherited_from
+		}
+		if command.Id != tc.id || command.Repository != "test-flight" || inheritedFrom != tc.inheritedFrom {
+			t.Errorf("%s: unexpected command %+v", tc.id, command)
+		}
+	}
+}
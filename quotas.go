@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/runwayapp/air-traffic-control/internal/database"
+	"github.com/runwayapp/air-traffic-control/internal/plans"
+)
+
+// QuotaError is returned when a write would exceed what an organization's plan allows
+type QuotaError struct {
+	Message string
+}
+
+func (e *QuotaError) Error() string {
+	return e.Message
+}
+
+type Usage struct {
+	Repositories_with_commands int `json:"repositories_with_commands"`
+	// number of commands in each repository that has any
+	Commands_per_repository map[string]int `json:"commands_per_repository"`
+	Org_commands            int            `json:"org_commands"`
+}
+
+type UsageResponse struct {
+	Organization string      `json:"organization"`
+	Plan         string      `json:"plan"`
+	Limits       plans.Quota `json:"limits"`
+	Usage        Usage       `json:"usage"`
+}
+
+// loadQuota returns an organization's plan and its quota
+func loadQuota(ctx context.Context, org string) (string, plans.Quota, error) {
+	plan, err := planCache.Plan(ctx, org)
+	if err != nil {
+		return "", plans.Quota{}, err
+	}
+	return plan, plans.QuotaFor(plan), nil
+}
+
+// querier runs queries on the db pool or inside a transaction
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*database.Rows, error)
+}
+
+// lockQuota locks an organization's row in quota_locks until tx ends so concurrent writes are counted one at a time
+// orgs on the default plan have no organizations row to lock, so the lock row is created on first use
+func lockQuota(ctx context.Context, tx *database.Tx, org string) error {
+	query := `INSERT INTO quota_locks (organization) VALUES (?) ON DUPLICATE KEY UPDATE organization = organization`
+	_, err := tx.ExecContext(ctx, query, org)
+	return err
+}
+
+// loadCommandCounts returns the number of commands in each of an organization's repositories
+func loadCommandCounts(ctx context.Context, q querier, org string) (map[string]int, error) {
+	query := `SELECT repository, COUNT(*) FROM commands WHERE organization = ? GROUP BY repository`
+	res, err := q.QueryContext(ctx, query, org)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	counts := map[string]int{}
+	for res.Next() {
+		var repo string
+		var count int
+		if err := res.Scan(&repo, &count); err != nil {
+			return nil, err
+		}
+		counts[repo] = count
+	}
+	return counts, res.Err()
+}
+
+// checkCommandFeatures returns a *QuotaError if a command's data uses features its org's plan does not include
+func checkCommandFeatures(ctx context.Context, org string, name string, data string) error {
+	plan, quota, err := loadQuota(ctx, org)
+	if err != nil {
+		return err
+	}
+
+	var document struct {
+		Actions []struct {
+			Type string `json:"type"`
+		} `json:"actions"`
+	}
+	if err := json.Unmarshal([]byte(data), &document); err != nil {
+		return &QuotaError{Message: fmt.Sprintf("command %q data must be a valid JSON object with an actions list", name)}
+	}
+
+	for _, action := range document.Actions {
+		if !quota.AllowsAction(action.Type) {
+			return &QuotaError{Message: fmt.Sprintf("command %q uses the %s action which is not available on the %s plan", name, action.Type, plan)}
+		}
+	}
+
+	approvals, err := parseApprovalConfig(data)
+	if err != nil {
+		return err
+	}
+	if approvals != nil && !quota.Approvals {
+		return &QuotaError{Message: fmt.Sprintf("command %q requires approvals which are not available on the %s plan", name, plan)}
+	}
+
+	return nil
+}
+
+// checkRepositoryQuota returns a *QuotaError if adding commands to a repository would exceed its org's command quotas
+// the commands are counted in tx after locking the org's quota, so the caller must add them in the same tx
+func checkRepositoryQuota(ctx context.Context, tx *database.Tx, org string, repo string, added int) error {
+	// only growing a repository is rejected so orgs that downgrade can still remove commands
+	if added <= 0 {
+		return nil
+	}
+
+	plan, quota, err := loadQuota(ctx, org)
+	if err != nil {
+		return err
+	}
+
+	if err := lockQuota(ctx, tx, org); err != nil {
+		return err
+	}
+	counts, err := loadCommandCounts(ctx, tx, org)
+	if err != nil {
+		return err
+	}
+
+	total := counts[repo] + added
+	if quota.Max_commands_per_repository > 0 && total > quota.Max_commands_per_repository {
+		return &QuotaError{Message: fmt.Sprintf("the %s plan allows at most %d commands per repository", plan, quota.Max_commands_per_repository)}
+	}
+
+	if _, ok := counts[repo]; !ok && quota.Max_repositories_with_commands > 0 && len(counts) >= quota.Max_repositories_with_commands {
+		return &QuotaError{Message: fmt.Sprintf("the %s plan allows commands in at most %d repositories", plan, quota.Max_repositories_with_commands)}
+	}
+
+	return nil
+}
+
+// checkOrgCommandQuota returns a *QuotaError if adding org commands would exceed the org's plan
+// the commands are counted in tx after locking the org's quota, so the caller must add them in the same tx
+func checkOrgCommandQuota(ctx context.Context, tx *database.Tx, org string, added int) error {
+	if added <= 0 {
+		return nil
+	}
+
+	plan, quota, err := loadQuota(ctx, org)
+	if err != nil {
+		return err
+	}
+
+	if err := lockQuota(ctx, tx, org); err != nil {
+		return err
+	}
+	var count int
+	query := `SELECT COUNT(*) FROM organization_commands WHERE organization = ?`
+	if err := tx.QueryRowContext(ctx, query, org).Scan(&count); err != nil {
+		return err
+	}
+
+	if quota.Max_org_commands > 0 && count+added > quota.Max_org_commands {
+		return &QuotaError{Message: fmt.Sprintf("the %s plan allows at most %d org commands", plan, quota.Max_org_commands)}
+	}
+	return nil
+}
+
+// checkCommandQuota returns a *QuotaError if a command can't be written to a repository on its org's plan
+func checkCommandQuota(ctx context.Context, tx *database.Tx, org string, repo string, name string, data string, added int) error {
+	if err := checkCommandFeatures(ctx, org, name, data); err != nil {
+		return err
+	}
+	return checkRepositoryQuota(ctx, tx, org, repo, added)
+}
+
+// writeQuotaError writes a 403 if err is a *QuotaError and reports whether it did
+func writeQuotaError(c *gin.Context, err error) bool {
+	var quotaError *QuotaError
+	if !errors.As(err, &quotaError) {
+		return false
+	}
+	c.JSON(http.StatusForbidden, gin.H{"error": quotaError.Message})
+	return true
+}
+
+// GetUsage reports an organization's usage against the quotas of its plan
+func GetUsage(c *gin.Context) {
+	org := c.Param("org")
+	org = strings.ReplaceAll(org, "/", "")
+
+	plan, quota, err := loadQuota(c.Request.Context(), org)
+	if err != nil {
+		panic(fmt.Sprintf("(GetUsage) loadQuota %s", err))
+	}
+
+	counts, err := loadCommandCounts(c.Request.Context(), db, org)
+	if err != nil {
+		panic(fmt.Sprintf("(GetUsage) loadCommandCounts %s", err))
+	}
+
+	var orgCommands int
+	query := `SELECT COUNT(*) FROM organization_commands WHERE organization = ?`
+	err = db.QueryRowContext(c.Request.Context(), query, org).Scan(&orgCommands)
+	if err != nil {
+		panic(fmt.Sprintf("(GetUsage) db.QueryRow %s", err))
+	}
+
+	c.JSON(http.StatusOK, UsageResponse{
+		Organization: org,
+		Plan:         plan,
+		Limits:       quota,
+		Usage: Usage{
+			Repositories_with_commands: len(counts),
+			Commands_per_repository:    counts,
+			Org_commands:               orgCommands,
+		},
+	})
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/runwayapp/air-traffic-control/internal/database/dbtest"
+	"github.com/runwayapp/air-traffic-control/internal/plans"
+)
+
+func TestCheckCommandFeatures(t *testing.T) {
+	const approvals = `{"approvals": {"required": 1}, "actions": [{"type": "reaction"}]}`
+
+	cases := []struct {
+		plan    string
+		data    string
+		allowed bool
+	}{
+		{plan: plans.Free, data: `{"actions": [{"type": "reaction"}, {"type": "workflow_dispatch"}]}`, allowed: true},
+		{plan: plans.Free, data: `{"actions": [{"type": "http_request"}]}`, allowed: false},
+		{plan: plans.Team, data: `{"actions": [{"type": "http_request"}]}`, allowed: true},
+		{plan: plans.Team, data: `{"actions": [{"type": "unknown"}]}`, allowed: false},
+		{plan: plans.Enterprise, data: `{"actions": [{"type": "unknown"}]}`, allowed: true},
+		{plan: plans.Free, data: approvals, allowed: false},
+		{plan: plans.Team, data: approvals, allowed: false},
+		{plan: plans.Enterprise, data: approvals, allowed: true},
+		{plan: plans.Enterprise, data: `not json`, allowed: false},
+	}
+	for _, tc := range cases {
+		store := &fakeCommandStore{plan: tc.plan}
+		store.open(t)
+
+		err := checkCommandFeatures(context.Background(), "runwayapp", "deploy", tc.data)
+		if tc.allowed && err != nil {
+			t.Errorf("%s plan with %s: expected it to be allowed, got %s", tc.plan, tc.data, err)
+		}
+		var quotaError *QuotaError
+		if !tc.allowed && !errors.As(err, &quotaError) {
+			t.Errorf("%s plan with %s: expected a quota error, got %v", tc.plan, tc.data, err)
+		}
+	}
+}
+
+// commandRequest sends method to path through the repository command routes
+func commandRequest(method string, path string, body string) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/api/v1/:org/:repo/commands", CreateCommand)
+	router.DELETE("/api/v1/:org/:repo/commands/:commandId", DeleteCommand)
+	router.POST("/api/v1/:org/:repo/commands/import", ImportCommands)
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(method, path, strings.NewReader(body))
+	request.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(recorder, request)
+	return recorder
+}
+
+// fullRepository returns the data of max reaction commands keyed by name
+func fullRepository(max int) map[string]string {
+	commands := map[string]string{}
+	for n := 0; n < max; n++ {
+		commands["command-"+string(rune('a'+n))] = `{"actions": [{"type": "reaction"}]}`
+	}
+	return commands
+}
+
+func TestCreateCommandQuota(t *testing.T) {
+	const body = `{"Name": "deploy", "Data": "{\"actions\": [{\"type\": \"reaction\"}]}"}`
+
+	cases := []struct {
+		name     string
+		commands map[string]string
+		status   int
+	}{
+		{name: "within quota", commands: fullRepository(9), status: http.StatusOK},
+		{name: "over quota", commands: fullRepository(10), status: http.StatusForbidden},
+	}
+	for _, tc := range cases {
+		store := &fakeCommandStore{plan: plans.Free, commands: tc.commands}
+		fake := store.open(t)
+
+		recorder := commandRequest(http.MethodPost, "/api/v1/runwayapp/test-flight/commands", body)
+		if recorder.Code != tc.status {
+			t.Errorf("%s: expected %d, got %d: %s", tc.name, tc.status, recorder.Code, recorder.Body)
+		}
+
+		// the org's quota is locked before its commands are counted
+		order := []string{}
+		for _, statement := range fake.Statements() {
+			switch {
+			case strings.HasPrefix(statement.Query, "INSERT INTO quota_locks"):
+				order = append(order, "lock")
+			case strings.Contains(statement.Query, "COUNT(*) FROM commands"):
+				order = append(order, "count")
+			}
+		}
+		if strings.Join(order, ",") != "lock,count" {
+			t.Errorf("%s: expected the quota to be locked then counted, got %v", tc.name, order)
+		}
+
+		if tc.status == http.StatusOK {
+			if len(store.created) != 1 || fake.Commits() != 1 {
+				t.Errorf("%s: expected the command to be inserted and committed, got %v and %d commits", tc.name, store.created, fake.Commits())
+			}
+			continue
+		}
+		if len(store.created) != 0 || fake.Commits() != 0 || fake.Rollbacks() != 1 {
+			t.Errorf("%s: expected nothing to be inserted and the transaction rolled back, got %v", tc.name, store.created)
+		}
+	}
+}
+
+func TestCreateOrgCommandQuota(t *testing.T) {
+	const body = `{"Name": "help", "Data": "{\"actions\": [{\"type\": \"reaction\"}]}"}`
+	limit := plans.QuotaFor(plans.Free).Max_org_commands
+
+	cases := []struct {
+		name        string
+		orgCommands int
+		status      int
+	}{
+		{name: "within quota", orgCommands: limit - 1, status: http.StatusOK},
+		{name: "at the limit", orgCommands: limit, status: http.StatusForbidden},
+	}
+	for _, tc := range cases {
+		inserted := 0
+		fake := &dbtest.Fake{
+			Query: func(query string, args []driver.Value) (dbtest.Rows, error) {
+				switch {
+				case strings.Contains(query, "SELECT plan FROM organizations"):
+					return dbtest.Rows{Columns: []string{"plan"}, Values: [][]driver.Value{{plans.Free}}}, nil
+				case strings.Contains(query, "COUNT(*) FROM organization_commands"):
+					return dbtest.Rows{Columns: []string{"count"}, Values: [][]driver.Value{{int64(tc.orgCommands)}}}, nil
+				}
+				t.Fatalf("unexpected query %s", query)
+				return dbtest.Rows{}, nil
+			},
+			Exec: func(query string, args []driver.Value) (driver.Result, error) {
+				if strings.HasPrefix(query, "INSERT INTO organization_commands") {
+					inserted++
+				}
+				return dbtest.Result(1), nil
+			},
+		}
+		db = dbtest.Open(t, fake)
+		planCache = plans.NewCache(db)
+
+		gin.SetMode(gin.TestMode)
+		router := gin.New()
+		router.POST("/api/v1/org_commands/:org", CreateOrgCommand)
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, "/api/v1/org_commands/runwayapp", strings.NewReader(body))
+		request.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(recorder, request)
+
+		if recorder.Code != tc.status {
+			t.Errorf("%s: expected %d, got %d: %s", tc.name, tc.status, recorder.Code, recorder.Body)
+		}
+		statements := fake.Statements()
+		if len(statements) < 2 || !strings.HasPrefix(statements[1].Query, "INSERT INTO quota_locks") {
+			t.Errorf("%s: expected the org's quota to be locked before counting, got %v", tc.name, statements)
+		}
+		if tc.status == http.StatusOK && (inserted != 1 || fake.Commits() != 1) {
+			t.Errorf("%s: expected the org command to be inserted and committed", tc.name)
+		}
+		if tc.status != http.StatusOK && (inserted != 0 || fake.Commits() != 0) {
+			t.Errorf("%s: expected nothing to be inserted", tc.name)
+		}
+	}
+}
+
+// an org that downgraded to a plan it no longer fits can still shrink its repositories
+func TestDowngradeCanStillDelete(t *testing.T) {
+	commands := fullRepository(12)
+	store := &fakeCommandStore{plan: plans.Free, commands: commands}
+	store.open(t)
+
+	// growing the repository is rejected
+	recorder := commandRequest(http.MethodPost, "/api/v1/runwayapp/test-flight/commands", `{"Name": "deploy", "Data": "{\"actions\": []}"}`)
+	if recorder.Code != http.StatusForbidden {
+		t.Errorf("expected a create to be rejected over quota, got %d: %s", recorder.Code, recorder.Body)
+	}
+
+	// but commands can still be deleted one at a time
+	recorder = commandRequest(http.MethodDelete, "/api/v1/runwayapp/test-flight/commands/id-command-a", "")
+	if recorder.Code != http.StatusOK || len(store.deleted) != 1 {
+		t.Errorf("expected a delete to succeed, got %d: %s", recorder.Code, recorder.Body)
+	}
+
+	// or pruned by a manifest that keeps only some of them
+	store.deleted = nil
+	manifest := `{"commands": [{"name": "command-b", "data": {"actions": [{"type": "reaction"}]}}]}`
+	recorder = commandRequest(http.MethodPost, "/api/v1/runwayapp/test-flight/commands/import?prune=true", manifest)
+	if recorder.Code != http.StatusOK {
+		t.Errorf("expected a pruning import to succeed, got %d: %s", recorder.Code, recorder.Body)
+	}
+	if len(store.deleted) != len(commands)-1 {
+		t.Errorf("expected %d commands to be pruned, got %v", len(commands)-1, store.deleted)
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/runwayapp/air-traffic-control/internal/metrics"
+)
+
+// resolution outcomes
+const (
+	ResolutionMatched = "matched"
+	ResolutionNoMatch = "no_match"
+	ResolutionIgnored = "ignored"
+)
+
+type ResolutionRequest struct {
+	Comment            string `json:"comment"`
+	Branch             string `json:"branch"`
+	Pull_request_state string `json:"pull_request_state"`
+}
+
+type ResolutionResponse struct {
+	Outcome   string           `json:"outcome"`
+	Reason    string           `json:"reason,omitempty"`
+	Trigger   string           `json:"trigger,omitempty"`
+	Arguments string           `json:"arguments,omitempty"`
+	Reaction  string           `json:"reaction,omitempty"`
+	Command   *CommandResponse `json:"command,omitempty"`
+}
+
+// commandTrigger holds the fields of a command's data document used to resolve it
+type commandTrigger struct {
+	Command string `json:"command"`
+	State   string `json:"state"`
+}
+
+// triggerName strips the legacy "." prefix from a stored trigger so the repository prefix can be applied instead
+func triggerName(command string) string {
+	return strings.TrimPrefix(command, legacyTriggerPrefix)
+}
+
+// matchBranch reports whether branch matches an allowed_branches glob pattern such as "main" or "release/*"
+func matchBranch(pattern string, branch string) (bool, error) {
+	return path.Match(pattern, branch)
+}
+
+// branchAllowed reports whether a branch may run commands under the given settings
+func branchAllowed(settings RepositorySettings, branch string) bool {
+	if len(settings.Allowed_branches) == 0 {
+		return true
+	}
+	for _, pattern := range settings.Allowed_branches {
+		if ok, _ := matchBranch(pattern, branch); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// parseComment splits a comment into the word that may be a trigger and its arguments
+func parseComment(settings RepositorySettings, comment string) (name string, arguments string, ok bool) {
+	fields := strings.Fields(comment)
+	if len(fields) == 0 || !strings.HasPrefix(fields[0], settings.Trigger_prefix) {
+		return "", "", false
+	}
+
+	name = strings.TrimPrefix(fields[0], settings.Trigger_prefix)
+	arguments = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(comment), fields[0]))
+	return name, arguments, name != ""
+}
+
+// triggerMatches compares a comment's trigger against a stored trigger honoring case sensitivity
+func triggerMatches(settings RepositorySettings, name string, command string) bool {
+	if settings.Case_sensitive {
+		return name == triggerName(command)
+	}
+	return strings.EqualFold(name, triggerName(command))
+}
+
+// buildCommandResponse converts a command row into an API response
+func buildCommandResponse(command Command) (CommandResponse, error) {
+	var data map[string]interface{}
+	err := json.Unmarshal([]byte(command.Data), &data)
+	if err != nil {
+		return CommandResponse{}, err
+	}
+
+	return CommandResponse{
+		Id:             command.Id,
+		Organization:   command.Organization,
+		Repository:     command.Repository,
+		Name:           command.Name,
+		Data:           data,
+		Created_at:     command.Created_at,
+		Updated_at:     command.Updated_at,
+		Inherited_from: command.Inherited_from,
+	}, nil
+}
+
+// respondResolution writes a resolution and counts its outcome
+func respondResolution(c *gin.Context, response ResolutionResponse) {
+	metrics.CommandResolutions.Inc(response.Outcome)
+	c.JSON(http.StatusOK, response)
+}
+
+// ResolveCommand finds the command a comment triggers, applying the repository's settings
+func ResolveCommand(c *gin.Context) {
+	var request ResolutionRequest
+	err := c.BindJSON(&request)
+	if err != nil {
+		panic(fmt.Sprintf("(ResolveCommand) c.BindJSON %s", err))
+	}
+
+	org := c.Param("org")
+	org = strings.ReplaceAll(org, "/", "")
+	repo := c.Param("repo")
+	repo = strings.ReplaceAll(repo, "/", "")
+
+	settings, err := loadRepositorySettings(c.Request.Context(), org, repo)
+	if err != nil {
+		panic(fmt.Sprintf("(ResolveCommand) loadRepositorySettings %s", err))
+	}
+
+	name, arguments, ok := parseComment(settings, request.Comment)
+	if !ok {
+		respondResolution(c, ResolutionResponse{Outcome: ResolutionNoMatch, Reason: fmt.Sprintf("comment does not start with the trigger prefix %q", settings.Trigger_prefix)})
+		return
+	}
+
+	if settings.Ignore_closed_prs && (request.Pull_request_state == "closed" || request.Pull_request_state == "merged") {
+		respondResolution(c, ResolutionResponse{Outcome: ResolutionIgnored, Reason: "commands on closed pull requests are ignored"})
+		return
+	}
+
+	if !branchAllowed(settings, request.Branch) {
+		respondResolution(c, ResolutionResponse{Outcome: ResolutionIgnored, Reason: fmt.Sprintf("branch %q is not allowed to run commands", request.Branch)})
+		return
+	}
+
+	commands, err := loadEffectiveCommands(c.Request.Context(), org, repo)
+	if err != nil {
+		panic(fmt.Sprintf("(ResolveCommand) loadEffectiveCommands %s", err))
+	}
+
+	for _, command := range commands {
+		var trigger commandTrigger
+		if err := json.Unmarshal([]byte(command.Data), &trigger); err != nil {
+			continue
+		}
+
+		if trigger.State != "" && trigger.State != "active" {
+			continue
+		}
+
+		if !triggerMatches(settings, name, trigger.Command) {
+			continue
+		}
+
+		commandResponse, err := buildCommandResponse(command)
+		if err != nil {
+			panic(fmt.Sprintf("(ResolveCommand) buildCommandResponse %s", err))
+		}
+
+		respondResolution(c, ResolutionResponse{
+			Outcome:   ResolutionMatched,
+			Trigger:   settings.Trigger_prefix + triggerName(trigger.Command),
+			Arguments: arguments,
+			Reaction:  settings.Default_reaction,
+			Command:   &commandResponse,
+		})
+		return
+	}
+
+	respondResolution(c, ResolutionResponse{Outcome: ResolutionNoMatch, Reason: fmt.Sprintf("no active command is triggered by %q", settings.Trigger_prefix+name)})
+}
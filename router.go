@@ -0,0 +1,132 @@
+package main
+
+import (
+	_ "embed"
+	"net/http"
+
+	"github.com/runwayapp/air-traffic-control/internal/apikeys"
+	"github.com/runwayapp/air-traffic-control/internal/config"
+	"github.com/runwayapp/air-traffic-control/internal/metrics"
+	"github.com/runwayapp/air-traffic-control/internal/middlewares"
+	"github.com/runwayapp/air-traffic-control/internal/ratelimit"
+	token "github.com/runwayapp/air-traffic-control/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// openapiSpec describes every route registered by newRouter, served at /api/v1/openapi.json
+//
+//go:embed openapi.json
+var openapiSpec []byte
+
+// newRouter builds the API router with its middlewares and every route
+// metrics are only served here when they have no listener of their own and an admin token is set
+func newRouter(cfg *config.Config, limiter *ratelimit.Limiter) *gin.Engine {
+	if cfg.Env != config.EnvDevelopment {
+		gin.SetMode(gin.ReleaseMode)
+	}
+	router := gin.New()
+
+	// Tag every request with an id that is echoed back and included in its log lines
+	router.Use(middlewares.RequestID())
+
+	// Continue the caller's trace, or start one, for every request
+	router.Use(middlewares.Tracing())
+
+	// Count requests and their latency by route
+	router.Use(middlewares.Metrics())
+
+	// Log one structured line per request, without the credentials some clients put in query params
+	router.Use(middlewares.RequestLogger("/healthz", "/readyz", "/metrics"))
+
+	// Recovery middleware recovers from any panics and writes a 500 if there was one.
+	router.Use(middlewares.Recovery())
+
+	// Database timeouts and outages are answered with a 504 or 503 instead of a 500
+	router.Use(middlewares.DatabaseErrors())
+
+	// scopes required by each route
+	commandsRead := middlewares.RequireScope(token.ScopeCommandsRead)
+	commandsWrite := middlewares.RequireScope(token.ScopeCommandsWrite)
+	admin := middlewares.RequireScope(token.ScopeAdmin)
+
+	protected := router.Group("/api/v1")
+	protected.Use(middlewares.JwtAuthMiddleware(revocations, oidcVerifier), middlewares.RateLimit(limiter, middlewares.OrgRateLimitKey))
+	protected.GET("/:org/:repo/commands", commandsRead, GetRepoCommands)
+	protected.GET("/:org/:repo/commands/:commandId", commandsRead, GetSingleCommand)
+	protected.POST("/:org/:repo/commands", commandsWrite, CreateCommand)
+	protected.PUT("/:org/:repo/commands/:commandId", commandsWrite, UpdateCommand)
+	protected.DELETE("/:org/:repo/commands/:commandId", commandsWrite, DeleteCommand)
+	protected.GET("/:org/:repo/commands/export", commandsRead, ExportCommands)
+	protected.POST("/:org/:repo/commands/import", commandsWrite, ImportCommands)
+	protected.POST("/:org/:repo/commands/resolve", commandsRead, ResolveCommand)
+	protected.POST("/:org/:repo/commands/:commandId/invocations", commandsWrite, CreateInvocation)
+	protected.GET("/:org/:repo/invocations/:invocationId", commandsRead, GetInvocation)
+	protected.POST("/:org/:repo/invocations/:invocationId/approve", commandsWrite, ApproveInvocation)
+	protected.POST("/:org/:repo/invocations/:invocationId/cancel", commandsWrite, CancelInvocation)
+	protected.POST("/:org/:repo/invocations/comments", commandsWrite, HandleInvocationComment)
+	protected.POST("/:org/:repo/drift", commandsRead, DetectDrift)
+	protected.GET("/:org/:repo/settings", commandsRead, GetRepositorySettings)
+	protected.PUT("/:org/:repo/settings", admin, UpdateRepositorySettings)
+	// org routes use prefixes with an underscore, which GitHub org names can't contain, so they never shadow /:org/:repo routes
+	protected.GET("/org_commands/:org", commandsRead, GetOrgCommands)
+	protected.GET("/org_commands/:org/:commandId", commandsRead, GetSingleOrgCommand)
+	protected.POST("/org_commands/:org", commandsWrite, CreateOrgCommand)
+	protected.PUT("/org_commands/:org/:commandId", commandsWrite, UpdateOrgCommand)
+	protected.DELETE("/org_commands/:org/:commandId", commandsWrite, DeleteOrgCommand)
+	protected.GET("/org_usage/:org", commandsRead, GetUsage)
+	protected.POST("/org_tokens/:org/revoke", admin, RevokeOrgToken)
+	protected.GET("/auth/whoami", Whoami)
+
+	apiKeyProtection := router.Group("/api/v1")
+	apiKeyProtection.Use(middlewares.ApiKeyAuthMiddleware(db, cfg.GitHub.AppApiKey))
+	rateLimitByLogin := middlewares.RateLimit(limiter, middlewares.LoginRateLimitKey)
+	rateLimitByApiKey := middlewares.RateLimit(limiter, middlewares.ApiKeyRateLimitKey)
+	rateLimitByOrg := middlewares.RateLimit(limiter, middlewares.OrgRateLimitKey)
+	apiKeyProtection.POST("/auth", rateLimitByLogin, middlewares.RequireApiKeyScope(apikeys.ScopeAuth), Auth)
+	apiKeyProtection.POST("/auth/refresh", rateLimitByApiKey, middlewares.RequireApiKeyScope(apikeys.ScopeAuth), RefreshToken)
+	apiKeyProtection.POST("/auth/introspect", rateLimitByApiKey, middlewares.RequireApiKeyScope(apikeys.ScopeAuth), IntrospectToken)
+	apiKeyProtection.POST("/auth/revoke", rateLimitByApiKey, middlewares.RequireApiKeyScope(apikeys.ScopeAuth), RevokeTokens)
+	apiKeyProtection.GET("/org_api_keys/:org", rateLimitByOrg, middlewares.RequireApiKeyScope(apikeys.ScopeApiKeys), GetApiKeys)
+	apiKeyProtection.POST("/org_api_keys/:org", rateLimitByOrg, middlewares.RequireApiKeyScope(apikeys.ScopeApiKeys), CreateApiKey)
+	apiKeyProtection.DELETE("/org_api_keys/:org/:keyId", rateLimitByOrg, middlewares.RequireApiKeyScope(apikeys.ScopeApiKeys), RevokeApiKey)
+
+	webhooks := router.Group("/api/v1/webhooks")
+	webhooks.Use(middlewares.GitHubWebhookMiddleware(cfg.GitHub.WebhookSecret))
+	webhooks.POST("/github", HandleGitHubWebhook)
+
+	// publish the public keys our tokens can be verified with
+	router.GET("/.well-known/jwks.json", JWKS)
+
+	// liveness, readiness and a detailed dependency report
+	router.GET("/healthz", Healthz)
+	router.GET("/readyz", Readyz)
+	router.GET("/health", Health)
+
+	// add ping endpoint
+	router.GET("/ping", func(c *gin.Context) {
+		c.JSON(200, gin.H{
+			"message": "pong",
+		})
+	})
+
+	router.GET("/", func(c *gin.Context) {
+		c.JSON(200, gin.H{
+			"message": "air-traffic-control application",
+		})
+	})
+
+	// the OpenAPI document describing this API
+	router.GET("/api/v1/openapi.json", OpenAPI)
+
+	if cfg.Metrics.Addr == "" && cfg.Metrics.Token != "" {
+		router.GET("/metrics", middlewares.MetricsTokenAuth(cfg.Metrics.Token), gin.WrapH(metrics.Default))
+	}
+
+	return router
+}
+
+// OpenAPI serves the OpenAPI 3 document describing this API
+func OpenAPI(c *gin.Context) {
+	c.Data(http.StatusOK, "application/json; charset=utf-8", openapiSpec)
+}
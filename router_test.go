@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/runwayapp/air-traffic-control/internal/config"
+	"github.com/runwayapp/air-traffic-control/internal/ratelimit"
+)
+
+// GitHub org names are alphanumeric with single hyphens between characters
+var githubOrgName = regexp.MustCompile(`^[A-Za-z0-9](?:[A-Za-z0-9-]*[A-Za-z0-9])?$`)
+
+var routeParam = regexp.MustCompile(`:[A-Za-z]+`)
+
+// every static /api/v1 prefix that is also a valid org name must not shadow that org's repository routes
+func TestRepoRoutesReachableForEveryOrgName(t *testing.T) {
+	cfg := &config.Config{Env: config.EnvProduction}
+	routes := newRouter(cfg, &ratelimit.Limiter{Store: ratelimit.NewMemoryStore()}).Routes()
+
+	// rebuild the routing table with handlers that report which route matched
+	router := gin.New()
+	for _, route := range routes {
+		router.Handle(route.Method, route.Path, func(c *gin.Context) { c.String(200, c.FullPath()) })
+	}
+
+	prefixes := map[string]bool{}
+	for _, route := range routes {
+		parts := strings.Split(strings.TrimPrefix(route.Path, "/api/v1/"), "/")
+		if strings.HasPrefix(route.Path, "/api/v1/") && !strings.HasPrefix(parts[0], ":") && githubOrgName.MatchString(parts[0]) {
+			prefixes[parts[0]] = true
+		}
+	}
+
+	for _, route := range routes {
+		if !strings.HasPrefix(route.Path, "/api/v1/:org/:repo/") {
+			continue
+		}
+		for org := range prefixes {
+			path := strings.Replace(route.Path, ":org", org, 1)
+			path = routeParam.ReplaceAllString(path, "x")
+
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, httptest.NewRequest(route.Method, path, nil))
+			if recorder.Body.String() != route.Path {
+				t.Errorf("%s %s matched %q instead of %s", route.Method, path, recorder.Body.String(), route.Path)
+			}
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+)
+
+// the prefix every command trigger used before repository settings existed
+const legacyTriggerPrefix = "."
+
+// maximum length of a repository's trigger prefix
+const maxTriggerPrefixLength = 16
+
+// RepositorySettings controls how comments in a repository are resolved to commands
+type RepositorySettings struct {
+	Trigger_prefix    string   `json:"trigger_prefix"`
+	Case_sensitive    bool     `json:"case_sensitive"`
+	Default_reaction  string   `json:"default_reaction"`
+	Allowed_branches  []string `json:"allowed_branches"`
+	Ignore_closed_prs bool     `json:"ignore_closed_prs"`
+	// names of org commands this repository does not inherit
+	Disabled_inherited_commands []string `json:"disabled_inherited_commands"`
+	// apply .github/runway.yml when it is pushed to the default branch
+	Reconcile_on_push bool `json:"reconcile_on_push"`
+}
+
+// RepositorySettingsRequest uses pointers so omitted fields fall back to their defaults
+type RepositorySettingsRequest struct {
+	Trigger_prefix              *string  `json:"trigger_prefix"`
+	Case_sensitive              *bool    `json:"case_sensitive"`
+	Default_reaction            *string  `json:"default_reaction"`
+	Allowed_branches            []string `json:"allowed_branches"`
+	Ignore_closed_prs           *bool    `json:"ignore_closed_prs"`
+	Disabled_inherited_commands []string `json:"disabled_inherited_commands"`
+	Reconcile_on_push           *bool    `json:"reconcile_on_push"`
+}
+
+type RepositorySettingsResponse struct {
+	Organization string `json:"organization"`
+	Repository   string `json:"repository"`
+	RepositorySettings
+}
+
+// defaultRepositorySettings are used for repositories without a row in the repositories table
+func defaultRepositorySettings() RepositorySettings {
+	return RepositorySettings{
+		Trigger_prefix:              legacyTriggerPrefix,
+		Case_sensitive:              true,
+		Default_reaction:            "",
+		Allowed_branches:            []string{},
+		Ignore_closed_prs:           false,
+		Disabled_inherited_commands: []string{},
+		Reconcile_on_push:           false,
+	}
+}
+
+// loadRepositorySettings returns the stored settings for a repository or the defaults if there are none
+func loadRepositorySettings(ctx context.Context, org string, repo string) (RepositorySettings, error) {
+	settings := defaultRepositorySettings()
+
+	var allowedBranches sql.NullString
+	var disabledInheritedCommands sql.NullString
+	query := `SELECT trigger_prefix, case_sensitive, default_reaction, allowed_branches, ignore_closed_prs, disabled_inherited_commands, reconcile_on_push FROM repositories WHERE organization = ? AND name = ?`
+	err := db.QueryRowContext(ctx, query, org, repo).Scan(&settings.Trigger_prefix, &settings.Case_sensitive, &settings.Default_reaction, &allowedBranches, &settings.Ignore_closed_prs, &disabledInheritedCommands, &settings.Reconcile_on_push)
+	if err == sql.ErrNoRows {
+		return defaultRepositorySettings(), nil
+	}
+	if err != nil {
+		return settings, err
+	}
+
+	if allowedBranches.Valid {
+		if err := json.Unmarshal([]byte(allowedBranches.String), &settings.Allowed_branches); err != nil {
+			return settings, err
+		}
+	}
+
+	if disabledInheritedCommands.Valid {
+		if err := json.Unmarshal([]byte(disabledInheritedCommands.String), &settings.Disabled_inherited_commands); err != nil {
+			return settings, err
+		}
+	}
+
+	return settings, nil
+}
+
+// validateTriggerPrefix ensures a prefix can be matched against the start of a comment
+func validateTriggerPrefix(prefix string) error {
+	if prefix == "" {
+		return fmt.Errorf("trigger_prefix must not be empty")
+	}
+	if len(prefix) > maxTriggerPrefixLength {
+		return fmt.Errorf("trigger_prefix must be at most %d characters", maxTriggerPrefixLength)
+	}
+	if strings.IndexFunc(prefix, unicode.IsSpace) != -1 {
+		return fmt.Errorf("trigger_prefix must not contain whitespace")
+	}
+	return nil
+}
+
+func GetRepositorySettings(c *gin.Context) {
+	org := c.Param("org")
+	org = strings.ReplaceAll(org, "/", "")
+	repo := c.Param("repo")
+	repo = strings.ReplaceAll(repo, "/", "")
+
+	settings, err := loadRepositorySettings(c.Request.Context(), org, repo)
+	if err != nil {
+		panic(fmt.Sprintf("(GetRepositorySettings) loadRepositorySettings %s", err))
+	}
+
+	c.JSON(http.StatusOK, RepositorySettingsResponse{Organization: org, Repository: repo, RepositorySettings: settings})
+}
+
+func UpdateRepositorySettings(c *gin.Context) {
+	var request RepositorySettingsRequest
+	err := c.BindJSON(&request)
+	if err != nil {
+		panic(fmt.Sprintf("(UpdateRepositorySettings) c.BindJSON %s", err))
+	}
+
+	org := c.Param("org")
+	org = strings.ReplaceAll(org, "/", "")
+	repo := c.Param("repo")
+	repo = strings.ReplaceAll(repo, "/", "")
+
+	// PUT replaces the settings, so anything omitted goes back to its default
+	settings := defaultRepositorySettings()
+	if request.Trigger_prefix != nil {
+		settings.Trigger_prefix = *request.Trigger_prefix
+	}
+	if request.Case_sensitive != nil {
+		settings.Case_sensitive = *request.Case_sensitive
+	}
+	if request.Default_reaction != nil {
+		settings.Default_reaction = *request.Default_reaction
+	}
+	if request.Allowed_branches != nil {
+		settings.Allowed_branches = request.Allowed_branches
+	}
+	if request.Ignore_closed_prs != nil {
+		settings.Ignore_closed_prs = *request.Ignore_closed_prs
+	}
+	if request.Disabled_inherited_commands != nil {
+		settings.Disabled_inherited_commands = request.Disabled_inherited_commands
+	}
+	if request.Reconcile_on_push != nil {
+		settings.Reconcile_on_push = *request.Reconcile_on_push
+	}
+
+	if err := validateTriggerPrefix(settings.Trigger_prefix); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	for _, branch := range settings.Allowed_branches {
+		if _, err := matchBranch(branch, ""); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("allowed_branches contains an invalid pattern %q", branch)})
+			return
+		}
+	}
+
+	allowedBranches, _ := json.Marshal(settings.Allowed_branches)
+	disabledInheritedCommands, _ := json.Marshal(settings.Disabled_inherited_commands)
+
+	query := `INSERT INTO repositories (organization, name, trigger_prefix, case_sensitive, default_reaction, allowed_branches, ignore_closed_prs, disabled_inherited_commands, reconcile_on_push)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE trigger_prefix = VALUES(trigger_prefix), case_sensitive = VALUES(case_sensitive), default_reaction = VALUES(default_reaction),
+		allowed_branches = VALUES(allowed_branches), ignore_closed_prs = VALUES(ignore_closed_prs), disabled_inherited_commands = VALUES(disabled_inherited_commands),
+		reconcile_on_push = VALUES(reconcile_on_push)`
+	_, err = db.ExecContext(c.Request.Context(), query, org, repo, settings.Trigger_prefix, settings.Case_sensitive, settings.Default_reaction, string(allowedBranches), settings.Ignore_closed_prs, string(disabledInheritedCommands), settings.Reconcile_on_push)
+	if err != nil {
+		panic(fmt.Sprintf("(UpdateRepositorySettings) db.Exec %s", err))
+	}
+
+	c.JSON(http.StatusOK, RepositorySettingsResponse{Organization: org, Repository: repo, RepositorySettings: settings})
+}